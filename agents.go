@@ -0,0 +1,416 @@
+package memoryos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultHeartbeatTimeout is how long an agent stays online after its last
+// heartbeat
+const DefaultHeartbeatTimeout = 90 * time.Second
+
+// purgeBatchSize is how many memories a purge collects per search
+const purgeBatchSize = 500
+
+// ValidateAgentID rejects agent IDs containing "/", which separates a
+// tenant's prefix from the IDs it stores
+func ValidateAgentID(agentID string) error {
+	if strings.Contains(agentID, "/") {
+		return errorf(ErrInvalid, "invalid agent id: %q", agentID)
+	}
+	return nil
+}
+
+// AgentStatus is an agent's liveness as seen by the directory
+type AgentStatus string
+
+const (
+	AgentOnline  AgentStatus = "online"
+	AgentOffline AgentStatus = "offline"
+)
+
+// AgentRecord is an agent's directory entry
+type AgentRecord struct {
+	AgentID      string      `json:"agent_id"`
+	Name         string      `json:"name"`
+	Role         string      `json:"role"`
+	RegisteredAt time.Time   `json:"registered_at"`
+	LastSeen     time.Time   `json:"last_seen"`
+	Status       AgentStatus `json:"status"`
+	Deregistered bool        `json:"deregistered,omitempty"`
+}
+
+// AgentFilter narrows an agent listing. Query matches the agent ID or name,
+// case-insensitively.
+type AgentFilter struct {
+	Status AgentStatus
+	Role   string
+	Query  string
+}
+
+// PurgeResult reports what a deregistration removed
+type PurgeResult struct {
+	AgentID         string `json:"agent_id"`
+	MemoriesDeleted int    `json:"memories_deleted"`
+	SkillsDeleted   int    `json:"skills_deleted"`
+	LocksReleased   int    `json:"locks_released"`
+}
+
+// State keys of an agent's directory entry and of the set of agent IDs
+// the directory lists
+const agentIndexKey = "agents"
+
+func agentRecordKey(agentID string) string {
+	return "agent:" + agentID
+}
+
+// AgentDirectory tracks registered agents, their heartbeats and
+// deregistration on top of the SharedMemoryManager, which only knows how to
+// register and look up a single agent. Each agent's entry is a record in
+// the StateBackend changed through a compare-and-set, so the server
+// instances and the CLI see the same agents and never overwrite each
+// other's changes.
+type AgentDirectory struct {
+	manager  SharedBackend
+	state    StateBackend
+	memoryos MemoryBackend
+	roster   *TeamRoster
+	skills   *SkillRegistry
+	locks    *LockManager
+	timeout  time.Duration
+	now      func() time.Time
+}
+
+// NewAgentDirectory creates a new agent directory
+func NewAgentDirectory(manager SharedBackend, state StateBackend, memoryos MemoryBackend, roster *TeamRoster, skills *SkillRegistry, locks *LockManager) *AgentDirectory {
+	return &AgentDirectory{
+		manager:  manager,
+		state:    state,
+		memoryos: memoryos,
+		roster:   roster,
+		skills:   skills,
+		locks:    locks,
+		timeout:  DefaultHeartbeatTimeout,
+		now:      time.Now,
+	}
+}
+
+// read returns an agent's stored entry, or nil if it has none, along with
+// the raw form that a compare-and-set must match
+func (d *AgentDirectory) read(ctx context.Context, agentID string) (*AgentRecord, string, error) {
+	raw, err := d.state.Get(ctx, agentRecordKey(agentID))
+	if errors.Is(err, ErrNotFound) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	var record AgentRecord
+	if err := json.Unmarshal([]byte(raw), &record); err != nil {
+		return nil, "", fmt.Errorf("directory entry of %s: %w", agentID, err)
+	}
+	return &record, raw, nil
+}
+
+// load returns a registered agent's entry and its raw form, adopting an
+// agent the manager registered before the directory existed. Unknown and
+// deregistered agents are ErrNotFound.
+func (d *AgentDirectory) load(ctx context.Context, agentID string) (*AgentRecord, string, error) {
+	record, raw, err := d.read(ctx, agentID)
+	if err != nil {
+		return nil, "", err
+	}
+	if record != nil {
+		if record.Deregistered {
+			return nil, "", errorf(ErrNotFound, "agent not registered: %s", agentID)
+		}
+		return record, raw, nil
+	}
+
+	agent, err := d.manager.GetAgent(ctx, agentID)
+	if err != nil {
+		return nil, "", errorf(ErrNotFound, "agent not registered: %s", agentID)
+	}
+	return &AgentRecord{
+		AgentID:      agent.ID,
+		Name:         agent.Name,
+		Role:         agent.Role,
+		RegisteredAt: agent.CreatedAt,
+	}, "", nil
+}
+
+// swap writes record if the stored entry is still old ("" when there was
+// none)
+func (d *AgentDirectory) swap(ctx context.Context, old string, record *AgentRecord) (bool, error) {
+	stored := *record
+	stored.Status = ""
+	data, err := json.Marshal(&stored)
+	if err != nil {
+		return false, err
+	}
+	return d.state.CompareAndSwap(ctx, agentRecordKey(record.AgentID), old, string(data), 0)
+}
+
+// update lets change edit a registered agent's entry and stores it through
+// a compare-and-set, rerunning change on the new entry when another writer
+// got there first
+func (d *AgentDirectory) update(ctx context.Context, agentID string, change func(record *AgentRecord)) (*AgentRecord, error) {
+	for attempt := 0; attempt < sharedWriteAttempts; attempt++ {
+		record, old, err := d.load(ctx, agentID)
+		if err != nil {
+			return nil, err
+		}
+		change(record)
+		ok, err := d.swap(ctx, old, record)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return record, nil
+		}
+	}
+	return nil, errorf(ErrConflict, "directory entry of %s keeps changing", agentID)
+}
+
+func (d *AgentDirectory) status(record *AgentRecord) AgentStatus {
+	if d.now().Sub(record.LastSeen) > d.timeout {
+		return AgentOffline
+	}
+	return AgentOnline
+}
+
+// Register adds an agent that the manager has registered to the directory.
+// Registering a deregistered agent ID again brings it back.
+func (d *AgentDirectory) Register(ctx context.Context, agent *Agent) error {
+	now := d.now()
+	record := &AgentRecord{
+		AgentID:      agent.ID,
+		Name:         agent.Name,
+		Role:         agent.Role,
+		RegisteredAt: now,
+		LastSeen:     now,
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt == sharedWriteAttempts {
+			return errorf(ErrConflict, "directory entry of %s keeps changing", agent.ID)
+		}
+		_, old, err := d.read(ctx, agent.ID)
+		if err != nil {
+			return err
+		}
+		ok, err := d.swap(ctx, old, record)
+		if err != nil {
+			return err
+		}
+		if ok {
+			break
+		}
+	}
+	return d.state.AddMember(ctx, agentIndexKey, agent.ID)
+}
+
+// Heartbeat records that an agent is alive. Agents registered before the
+// directory existed are adopted on their first heartbeat.
+func (d *AgentDirectory) Heartbeat(ctx context.Context, agentID string) (*AgentRecord, error) {
+	record, err := d.update(ctx, agentID, func(record *AgentRecord) {
+		record.LastSeen = d.now()
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := d.state.AddMember(ctx, agentIndexKey, agentID); err != nil {
+		return nil, err
+	}
+	record.Status = AgentOnline
+	return record, nil
+}
+
+// Get returns an agent's directory entry with its current status
+func (d *AgentDirectory) Get(ctx context.Context, agentID string) (*AgentRecord, error) {
+	record, _, err := d.read(ctx, agentID)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil || record.Deregistered {
+		return nil, errorf(ErrNotFound, "agent not registered: %s", agentID)
+	}
+	record.Status = d.status(record)
+	return record, nil
+}
+
+// IsDeregistered reports whether an agent has been deregistered. Agents
+// the directory has never seen are not.
+func (d *AgentDirectory) IsDeregistered(ctx context.Context, agentID string) (bool, error) {
+	record, _, err := d.read(ctx, agentID)
+	if err != nil {
+		return false, err
+	}
+	return record != nil && record.Deregistered, nil
+}
+
+// List returns the registered agents matching the filter, ordered by ID
+func (d *AgentDirectory) List(ctx context.Context, filter AgentFilter) ([]AgentRecord, error) {
+	ids, err := d.state.Members(ctx, agentIndexKey)
+	if err != nil {
+		return nil, err
+	}
+
+	query := strings.ToLower(filter.Query)
+	agents := []AgentRecord{}
+	for _, id := range ids {
+		record, _, err := d.read(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if record == nil || record.Deregistered {
+			continue
+		}
+		record.Status = d.status(record)
+
+		if filter.Status != "" && record.Status != filter.Status {
+			continue
+		}
+		if filter.Role != "" && record.Role != filter.Role {
+			continue
+		}
+		if query != "" && !strings.Contains(strings.ToLower(record.AgentID), query) &&
+			!strings.Contains(strings.ToLower(record.Name), query) {
+			continue
+		}
+		agents = append(agents, *record)
+	}
+
+	sort.Slice(agents, func(i, j int) bool {
+		return agents[i].AgentID < agents[j].AgentID
+	})
+	return agents, nil
+}
+
+// IDs lists the IDs of the registered agents
+func (d *AgentDirectory) IDs(ctx context.Context) ([]string, error) {
+	agents, err := d.List(ctx, AgentFilter{})
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(agents))
+	for i, agent := range agents {
+		ids[i] = agent.AgentID
+	}
+	return ids, nil
+}
+
+// Summary counts registered agents by status, for /health
+func (d *AgentDirectory) Summary(ctx context.Context) (map[string]interface{}, error) {
+	agents, err := d.List(ctx, AgentFilter{})
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make(map[string]AgentStatus, len(agents))
+	online := 0
+	for _, agent := range agents {
+		statuses[agent.AgentID] = agent.Status
+		if agent.Status == AgentOnline {
+			online++
+		}
+	}
+	return map[string]interface{}{
+		"total":    len(agents),
+		"online":   online,
+		"offline":  len(agents) - online,
+		"statuses": statuses,
+	}, nil
+}
+
+// Deregister removes an agent from the directory and from its teams. With
+// purge, the agent's memories, skills and locks are deleted first. Nothing
+// deleted is put back: if the purge fails, the agent stays registered and
+// calling Deregister again picks up what is left, so the counts only cover
+// the call that reports them.
+func (d *AgentDirectory) Deregister(ctx context.Context, agentID string, purge bool) (*PurgeResult, error) {
+	if _, _, err := d.load(ctx, agentID); err != nil {
+		return nil, err
+	}
+
+	result := &PurgeResult{AgentID: agentID}
+	if purge {
+		if err := d.purge(ctx, agentID, result); err != nil {
+			return result, fmt.Errorf("purge %s: %w", agentID, err)
+		}
+	}
+
+	if _, err := d.update(ctx, agentID, func(record *AgentRecord) {
+		record.Deregistered = true
+	}); err != nil {
+		return result, err
+	}
+	if err := d.state.RemoveMember(ctx, agentIndexKey, agentID); err != nil {
+		return result, err
+	}
+
+	if d.roster != nil {
+		if err := d.roster.RemoveAgent(ctx, agentID); err != nil {
+			return result, fmt.Errorf("remove %s from its teams: %w", agentID, err)
+		}
+	}
+	return result, nil
+}
+
+// purge deletes an agent's memories, skills and locks, counting them in
+// result. Memories are found by searching with an empty query, which the
+// MemoryBackend answers with the agent's memories in any order; they are
+// deleted a batch at a time until a search finds none. A backend that
+// keeps listing deleted memories fails the purge rather than looping.
+func (d *AgentDirectory) purge(ctx context.Context, agentID string, result *PurgeResult) error {
+	seen := make(map[string]bool)
+	for {
+		memories, err := d.memoryos.SearchMemories(ctx, agentID, "", purgeBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(memories) == 0 {
+			break
+		}
+
+		progress := false
+		for _, memory := range memories {
+			if seen[memory.ID] {
+				continue
+			}
+			err := d.memoryos.DeleteMemory(ctx, agentID, memory.Type, memory.ID)
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				return err
+			}
+			if err == nil {
+				result.MemoriesDeleted++
+			}
+			seen[memory.ID] = true
+			progress = true
+		}
+		if !progress {
+			return fmt.Errorf("memories of %s are still listed after deletion", agentID)
+		}
+	}
+
+	if d.skills != nil {
+		deleted, err := d.skills.Forget(ctx, agentID)
+		result.SkillsDeleted = deleted
+		if err != nil {
+			return fmt.Errorf("delete skills: %w", err)
+		}
+	}
+	if d.locks != nil {
+		released, err := d.locks.ReleaseOwner(ctx, agentID)
+		if err != nil {
+			return fmt.Errorf("release locks: %w", err)
+		}
+		result.LocksReleased = released
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package memoryos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// stickyMemory reports deletes as done without removing anything
+type stickyMemory struct {
+	*testMemory
+}
+
+func (m stickyMemory) DeleteMemory(ctx context.Context, agentID string, memoryType MemoryType, id string) error {
+	return nil
+}
+
+// failingDeletes fails to delete the memory with the given ID
+type failingDeletes struct {
+	*testMemory
+	id string
+}
+
+func (m failingDeletes) DeleteMemory(ctx context.Context, agentID string, memoryType MemoryType, id string) error {
+	if id == m.id {
+		return errors.New("disk full")
+	}
+	return m.testMemory.DeleteMemory(ctx, agentID, memoryType, id)
+}
+
+// downState fails every read
+type downState struct {
+	*LocalState
+	err error
+}
+
+func (s downState) Get(ctx context.Context, key string) (string, error) {
+	return "", s.err
+}
+
+func (s downState) Members(ctx context.Context, key string) ([]string, error) {
+	return nil, s.err
+}
+
+func newTestDirectory(b *testBackends, memory MemoryBackend) *AgentDirectory {
+	manager := coreShared{b.shared}
+	roster := NewTeamRoster(manager, b.state, newTestSharedStore(b))
+	return NewAgentDirectory(manager, b.state, memory, roster, NewSkillRegistry(coreSkills{b.skills}, b.state), nil)
+}
+
+func storeTestMemories(t *testing.T, b *testBackends, agentID string, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		memory := &Memory{ID: fmt.Sprintf("m%04d", i), AgentID: agentID, Type: MemoryTypeEpisodic, Content: "note"}
+		if err := b.memory.StoreMemory(context.Background(), memory); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDeregisterPurgesEveryBatch(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	b.shared.RegisterAgent(ctx, &Agent{ID: "a"})
+	storeTestMemories(t, b, "a", purgeBatchSize+7)
+
+	d := newTestDirectory(b, coreMemory{b.memory})
+	d.skills.Register(ctx, "a", &Skill{Name: "search"})
+	d.skills.Publish(ctx, "a", &SkillMemory{SkillName: "fetch"}, "")
+	result, err := d.Deregister(ctx, "a", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.MemoriesDeleted != purgeBatchSize+7 || result.SkillsDeleted != 2 {
+		t.Fatalf("result = %+v", result)
+	}
+	if left, _ := b.memory.SearchMemories(ctx, "a", "", 0); len(left) != 0 {
+		t.Fatalf("%d memories survived the purge", len(left))
+	}
+	if _, err := b.skills.GetSkill(ctx, "a", "search"); err == nil {
+		t.Fatal("skill survived the purge")
+	}
+}
+
+func TestDeregisterStopsWhenPurgeMakesNoProgress(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	b.shared.RegisterAgent(ctx, &Agent{ID: "a"})
+	storeTestMemories(t, b, "a", 3)
+
+	d := newTestDirectory(b, coreMemory{stickyMemory{b.memory}})
+	if _, err := d.Deregister(ctx, "a", true); err == nil {
+		t.Fatal("purge of undeletable memories succeeded")
+	}
+	if deregistered, err := d.IsDeregistered(ctx, "a"); err != nil || deregistered {
+		t.Fatalf("deregistered = %v, %v", deregistered, err)
+	}
+}
+
+func TestDeregisterResumesAFailedPurge(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	b.shared.RegisterAgent(ctx, &Agent{ID: "a"})
+	storeTestMemories(t, b, "a", 3)
+
+	d := newTestDirectory(b, coreMemory{failingDeletes{b.memory, "m0002"}})
+	d.Register(ctx, &Agent{ID: "a"})
+	if _, err := d.Deregister(ctx, "a", true); err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Fatalf("err = %v", err)
+	}
+	if _, err := d.Get(ctx, "a"); err != nil {
+		t.Fatalf("agent left registered after a failed purge: %v", err)
+	}
+
+	// Deregistering again deletes what the first purge left
+	d = newTestDirectory(b, coreMemory{b.memory})
+	result, err := d.Deregister(ctx, "a", true)
+	if err != nil || result.MemoriesDeleted != 1 {
+		t.Fatalf("result = %+v, %v", result, err)
+	}
+	if left, _ := b.memory.SearchMemories(ctx, "a", "", 0); len(left) != 0 {
+		t.Fatalf("%d memories after the purge resumed", len(left))
+	}
+}
+
+func TestDirectoryIsSharedAcrossInstances(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	// Two server instances on the same state
+	one, two := newTestDirectory(b, coreMemory{b.memory}), newTestDirectory(b, coreMemory{b.memory})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		d := one
+		if i%2 == 1 {
+			d = two
+		}
+		wg.Add(1)
+		go func(agentID string) {
+			defer wg.Done()
+			if err := d.Register(ctx, &Agent{ID: agentID}); err != nil {
+				t.Error(err)
+			}
+			if _, err := d.Heartbeat(ctx, agentID); err != nil {
+				t.Error(err)
+			}
+		}(fmt.Sprintf("agent-%d", i))
+	}
+	wg.Wait()
+
+	if _, err := two.Deregister(ctx, "agent-0", false); err != nil {
+		t.Fatal(err)
+	}
+	agents, err := one.List(ctx, AgentFilter{})
+	if err != nil || len(agents) != 19 {
+		t.Fatalf("%d agents listed: %v", len(agents), err)
+	}
+	if deregistered, _ := one.IsDeregistered(ctx, "agent-0"); !deregistered {
+		t.Fatal("deregistration not seen by the other instance")
+	}
+}
+
+func TestDirectoryFailsClosed(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	s := newTestServer(t, b)
+	registerTestAgent(t, s, "a")
+
+	down := errors.New("connection refused")
+	d := newTestDirectory(b, coreMemory{b.memory})
+	d.state = downState{b.state, down}
+	s.authz.agents = d
+	if _, err := d.IsDeregistered(ctx, "a"); !errors.Is(err, down) {
+		t.Fatalf("is deregistered with the state down: %v", err)
+	}
+	if err := s.authz.AuthorizeMemory(ctx, "a", "a", ActionRead); err == nil {
+		t.Fatal("authorized while the directory was unreadable")
+	}
+	if _, err := d.List(ctx, AgentFilter{}); !errors.Is(err, down) {
+		t.Fatalf("list with the state down: %v", err)
+	}
+}
+
+func TestDeregisterLeavesTeams(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	s := newTestServer(t, b)
+	registerTestAgent(t, s, "owner")
+	registerTestAgent(t, s, "member")
+	s.roster.Create(ctx, &Team{ID: "team", Name: "team"}, "owner")
+	s.roster.AddMember(ctx, "team", "member", TeamRoleWriter)
+
+	// The last owner is removed too
+	if _, err := s.agents.Deregister(ctx, "owner", false); err != nil {
+		t.Fatal(err)
+	}
+	members, err := s.roster.Members(ctx, "team")
+	if err != nil || len(members) != 1 || members[0].AgentID != "member" {
+		t.Fatalf("members = %+v, %v", members, err)
+	}
+	if teams, err := s.roster.TeamsFor(ctx, "owner"); err != nil || len(teams) != 0 {
+		t.Fatalf("owner still in %v, %v", teams, err)
+	}
+}
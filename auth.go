@@ -0,0 +1,432 @@
+package memoryos
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	_ "crypto/sha512"
+)
+
+// ErrUnauthenticated is returned when a request carries no valid credentials
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// errNoCredentials is returned for requests that carry no credentials at
+// all, as opposed to credentials that fail to verify
+var errNoCredentials = fmt.Errorf("%w: credentials required", ErrUnauthenticated)
+
+// jwtLeeway tolerates clock skew when checking exp and nbf
+const jwtLeeway = 30 * time.Second
+
+// apiKeyHashPrefix marks the hash algorithm of configured API keys
+const apiKeyHashPrefix = "sha256:"
+
+// Identity is the authenticated caller of a request
+type Identity struct {
+	AgentID  string `json:"agent_id,omitempty"`
+	TenantID string `json:"tenant_id,omitempty"`
+	Subject  string `json:"subject"`
+	Method   string `json:"method"` // api_key or jwt
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a context carrying the authenticated identity
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+type agentHeaderKey struct{}
+
+// trustAgentHeader marks a request to a server without authentication,
+// whose callers identify themselves with the X-Agent-ID header
+func trustAgentHeader(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), agentHeaderKey{}, true))
+}
+
+func agentHeaderTrusted(ctx context.Context) bool {
+	trusted, _ := ctx.Value(agentHeaderKey{}).(bool)
+	return trusted
+}
+
+// IdentityFromContext returns the identity the auth middleware attached
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(*Identity)
+	return id, ok
+}
+
+// APIKeyConfig is a static API key. Only the key's hash is configured; see
+// HashAPIKey.
+type APIKeyConfig struct {
+	Hash     string `json:"hash"`
+	Name     string `json:"name,omitempty"`
+	AgentID  string `json:"agent_id,omitempty"`
+	TenantID string `json:"tenant_id,omitempty"`
+}
+
+// JWTConfig verifies signed bearer tokens. HS256/384/512 tokens are checked
+// with HMACSecret, RS256/384/512 tokens with the PEM RSA public key. The
+// agent comes from the agent_id claim (or sub) and the tenant from the
+// tenant_id claim.
+type JWTConfig struct {
+	HMACSecret       string `json:"hmac_secret,omitempty"`
+	RSAPublicKey     string `json:"rsa_public_key,omitempty"`
+	RSAPublicKeyFile string `json:"rsa_public_key_file,omitempty"`
+	Issuer           string `json:"issuer,omitempty"`
+	Audience         string `json:"audience,omitempty"`
+}
+
+// AuthConfig configures request authentication. Public paths are served
+// without credentials.
+type AuthConfig struct {
+	APIKeys []APIKeyConfig `json:"api_keys,omitempty"`
+	JWT     *JWTConfig     `json:"jwt,omitempty"`
+	Public  []string       `json:"public,omitempty"`
+}
+
+// LoadAuthConfig reads an AuthConfig from a JSON file
+func LoadAuthConfig(path string) (*AuthConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg AuthConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// HashAPIKey returns the configured form of an API key
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return apiKeyHashPrefix + hex.EncodeToString(sum[:])
+}
+
+// Authenticator checks API keys and JWTs and attaches the caller's identity
+// to the request context. Agent and tenant API keys share one store of
+// hashes. Until it is configured to require credentials, requests without
+// any are let through and identify their agent with the X-Agent-ID header.
+type Authenticator struct {
+	mu        sync.RWMutex
+	keys      map[string]*Identity
+	required  bool
+	secret    []byte
+	publicKey *rsa.PublicKey
+	issuer    string
+	audience  string
+	public    map[string]bool
+	now       func() time.Time
+}
+
+// NewAuthenticator builds an authenticator that requires credentials on
+// every path but the configured public ones
+func NewAuthenticator(cfg *AuthConfig) (*Authenticator, error) {
+	a := newAuthenticator()
+	if err := a.configure(cfg); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// newAuthenticator builds an authenticator that only checks the
+// credentials requests choose to send
+func newAuthenticator() *Authenticator {
+	return &Authenticator{
+		keys:   make(map[string]*Identity),
+		public: make(map[string]bool),
+		now:    time.Now,
+	}
+}
+
+// normalizeKeyHash checks a configured API key hash and adds the algorithm
+// prefix if it is missing
+func normalizeKeyHash(hash string) (string, error) {
+	hash = strings.ToLower(strings.TrimSpace(hash))
+	if !strings.HasPrefix(hash, apiKeyHashPrefix) {
+		hash = apiKeyHashPrefix + hash
+	}
+	digest := strings.TrimPrefix(hash, apiKeyHashPrefix)
+	if _, err := hex.DecodeString(digest); err != nil || len(digest) != 2*sha256.Size {
+		return "", errorf(ErrInvalid, "api key hash must be a hex sha256 digest")
+	}
+	return hash, nil
+}
+
+// addKeys registers API key hashes for an identity. A hash registered for
+// a different identity is a conflict, and then no hash is added.
+func (a *Authenticator) addKeys(hashes []string, id *Identity) error {
+	normalized := make([]string, len(hashes))
+	for i, hash := range hashes {
+		var err error
+		if normalized[i], err = normalizeKeyHash(hash); err != nil {
+			return err
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, hash := range normalized {
+		if existing, ok := a.keys[hash]; ok && *existing != *id {
+			return errorf(ErrConflict, "api key already belongs to %s", existing.Subject)
+		}
+	}
+	for _, hash := range normalized {
+		copied := *id
+		a.keys[hash] = &copied
+	}
+	return nil
+}
+
+// configure applies an AuthConfig and starts requiring credentials. Keys
+// added earlier, such as tenant keys, are kept.
+func (a *Authenticator) configure(cfg *AuthConfig) error {
+	for _, key := range cfg.APIKeys {
+		subject := key.Name
+		if subject == "" {
+			subject = key.AgentID
+		}
+		id := &Identity{AgentID: key.AgentID, TenantID: key.TenantID, Subject: subject, Method: "api_key"}
+		if err := a.addKeys([]string{key.Hash}, id); err != nil {
+			return fmt.Errorf("api key %q: %w", key.Name, err)
+		}
+	}
+
+	if jwt := cfg.JWT; jwt != nil {
+		a.secret = []byte(jwt.HMACSecret)
+		a.issuer, a.audience = jwt.Issuer, jwt.Audience
+
+		pemData := []byte(jwt.RSAPublicKey)
+		if jwt.RSAPublicKeyFile != "" {
+			data, err := os.ReadFile(jwt.RSAPublicKeyFile)
+			if err != nil {
+				return err
+			}
+			pemData = data
+		}
+		if len(pemData) > 0 {
+			key, err := parseRSAPublicKey(pemData)
+			if err != nil {
+				return err
+			}
+			a.publicKey = key
+		}
+	}
+
+	for _, path := range cfg.Public {
+		a.public[path] = true
+	}
+	a.required = true
+	return nil
+}
+
+func parseRSAPublicKey(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("rsa public key: no PEM block")
+	}
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("rsa public key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("rsa public key: not an RSA key")
+	}
+	return key, nil
+}
+
+// Middleware rejects requests with invalid credentials with 401 and passes
+// the rest on with their identity in the context. Requests without
+// credentials are rejected too, unless the path is public or credentials
+// are not required.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.required {
+			r = trustAgentHeader(r)
+		}
+		id, err := a.Authenticate(r)
+		if errors.Is(err, errNoCredentials) && (!a.required || a.public[r.URL.Path]) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="memoryos"`)
+			writeError(w, err, http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), id)))
+	})
+}
+
+// Authenticate resolves the identity of a request from an X-API-Key header
+// or an Authorization bearer token, which may be an API key or a JWT
+func (a *Authenticator) Authenticate(r *http.Request) (*Identity, error) {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return a.apiKey(key)
+	}
+
+	auth := r.Header.Get("Authorization")
+	if auth == "" {
+		return nil, errNoCredentials
+	}
+	scheme, token, _ := strings.Cut(auth, " ")
+	if !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return nil, fmt.Errorf("%w: unsupported authorization scheme", ErrUnauthenticated)
+	}
+	if strings.Count(token, ".") == 2 {
+		return a.jwt(token)
+	}
+	return a.apiKey(token)
+}
+
+func (a *Authenticator) apiKey(key string) (*Identity, error) {
+	hash := HashAPIKey(key)
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	for configured, id := range a.keys {
+		if subtle.ConstantTimeCompare([]byte(configured), []byte(hash)) == 1 {
+			copied := *id
+			return &copied, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: invalid api key", ErrUnauthenticated)
+}
+
+type jwtClaims struct {
+	Subject   string          `json:"sub"`
+	AgentID   string          `json:"agent_id"`
+	TenantID  string          `json:"tenant_id"`
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"`
+	Expires   *float64        `json:"exp"`
+	NotBefore *float64        `json:"nbf"`
+}
+
+// jwt verifies a compact JWS token and its registered claims
+func (a *Authenticator) jwt(token string) (*Identity, error) {
+	parts := strings.Split(token, ".")
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed token signature", ErrUnauthenticated)
+	}
+	if err := a.verify(header.Alg, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims jwtClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+
+	now := a.now()
+	if claims.Expires == nil {
+		return nil, fmt.Errorf("%w: token has no expiry", ErrUnauthenticated)
+	}
+	if now.After(time.Unix(int64(*claims.Expires), 0).Add(jwtLeeway)) {
+		return nil, fmt.Errorf("%w: token expired", ErrUnauthenticated)
+	}
+	if claims.NotBefore != nil && now.Add(jwtLeeway).Before(time.Unix(int64(*claims.NotBefore), 0)) {
+		return nil, fmt.Errorf("%w: token not yet valid", ErrUnauthenticated)
+	}
+	if a.issuer != "" && claims.Issuer != a.issuer {
+		return nil, fmt.Errorf("%w: unexpected issuer", ErrUnauthenticated)
+	}
+	if a.audience != "" && !audienceContains(claims.Audience, a.audience) {
+		return nil, fmt.Errorf("%w: unexpected audience", ErrUnauthenticated)
+	}
+
+	agentID := claims.AgentID
+	if agentID == "" {
+		agentID = claims.Subject
+	}
+	return &Identity{AgentID: agentID, TenantID: claims.TenantID, Subject: claims.Subject, Method: "jwt"}, nil
+}
+
+func (a *Authenticator) verify(alg, signingInput string, signature []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("%w: unsupported token algorithm %q", ErrUnauthenticated, alg)
+	}
+
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("%w: unsupported token algorithm %q", ErrUnauthenticated, alg)
+	}
+
+	switch {
+	case strings.HasPrefix(alg, "HS") && len(a.secret) > 0:
+		mac := hmac.New(hash.New, a.secret)
+		mac.Write([]byte(signingInput))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return fmt.Errorf("%w: invalid token signature", ErrUnauthenticated)
+		}
+		return nil
+	case strings.HasPrefix(alg, "RS") && a.publicKey != nil:
+		digest := hash.New()
+		digest.Write([]byte(signingInput))
+		if err := rsa.VerifyPKCS1v15(a.publicKey, hash, digest.Sum(nil), signature); err != nil {
+			return fmt.Errorf("%w: invalid token signature", ErrUnauthenticated)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: unsupported token algorithm %q", ErrUnauthenticated, alg)
+	}
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return fmt.Errorf("%w: malformed token", ErrUnauthenticated)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: malformed token", ErrUnauthenticated)
+	}
+	return nil
+}
+
+// audienceContains checks an aud claim, which may be a string or a list
+func audienceContains(raw json.RawMessage, audience string) bool {
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		return single == audience
+	}
+	var list []string
+	if err := json.Unmarshal(raw, &list); err == nil {
+		return containsString(list, audience)
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package memoryos
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func signTestJWT(t *testing.T, secret string, claims map[string]interface{}) string {
+	t.Helper()
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(input))
+	return input + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestJWTRequiresExpiry(t *testing.T) {
+	auth, err := NewAuthenticator(&AuthConfig{JWT: &JWTConfig{HMACSecret: "secret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authenticate := func(token string) error {
+		req := httptest.NewRequest(http.MethodGet, "/memory", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		_, err := auth.Authenticate(req)
+		return err
+	}
+
+	if err := authenticate(signTestJWT(t, "secret", map[string]interface{}{"sub": "a"})); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("token without exp: %v", err)
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+	if err := authenticate(signTestJWT(t, "secret", map[string]interface{}{"sub": "a", "exp": exp})); err != nil {
+		t.Fatalf("token with exp: %v", err)
+	}
+}
+
+func TestPublicPathsRejectBadCredentials(t *testing.T) {
+	s := newTestServer(t, newTestBackends())
+	cfg := &AuthConfig{APIKeys: []APIKeyConfig{{Hash: HashAPIKey("good"), AgentID: "a"}}, Public: []string{"/health"}}
+	if err := s.SetAuth(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	health := func(key string) int {
+		req := httptest.NewRequest(http.MethodGet, "/health", nil)
+		if key != "" {
+			req.Header.Set(APIKeyHeader, key)
+		}
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := health(""); code != http.StatusOK {
+		t.Fatalf("public path without credentials: status %d", code)
+	}
+	if code := health("bad"); code != http.StatusUnauthorized {
+		t.Fatalf("public path with a bad key: status %d", code)
+	}
+	if code := health("good"); code != http.StatusOK {
+		t.Fatalf("public path with a good key: status %d", code)
+	}
+}
+
+func TestTenantKeysShareTheHashedStore(t *testing.T) {
+	s := newTestServer(t, newTestBackends())
+	hash := HashAPIKey("acme-key")
+	if err := s.tenants.Load("acme=" + hash); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.tenants.Load("acme=acme-key"); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("plaintext key in the tenant spec: %v", err)
+	}
+	if _, ok := s.auth.keys["acme-key"]; ok {
+		t.Fatal("plaintext key stored")
+	}
+
+	// Turning authentication on keeps the tenant's key
+	if err := s.SetAuth(&AuthConfig{APIKeys: []APIKeyConfig{{Hash: HashAPIKey("agent-key"), AgentID: "a"}}}); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/health", nil)
+	req.Header.Set(APIKeyHeader, "acme-key")
+	id, err := s.auth.Authenticate(req)
+	if err != nil || id.TenantID != "acme" {
+		t.Fatalf("tenant key = %+v, %v", id, err)
+	}
+
+	if err := s.AddTenant("other", "agent-key"); !errors.Is(err, ErrConflict) {
+		t.Fatalf("agent key reused for a tenant: %v", err)
+	}
+}
@@ -0,0 +1,316 @@
+package memoryos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrForbidden is returned when the calling agent may not perform an action
+var ErrForbidden = errors.New("forbidden")
+
+// AgentIDHeader carries the ID of the agent making a request
+const AgentIDHeader = "X-Agent-ID"
+
+// Action is an operation checked by the Authorizer
+type Action string
+
+const (
+	ActionRead   Action = "read"
+	ActionWrite  Action = "write"
+	ActionDelete Action = "delete"
+)
+
+// Agent permissions and per-key ACL values understood by the Authorizer
+const (
+	PermissionRead  = "read"
+	PermissionWrite = "write"
+	PermissionAdmin = "admin"
+	PermissionNone  = "none"
+)
+
+// DefaultAgentPermissions are given to agents registered without a role or
+// permissions, which only admins may set
+var DefaultAgentPermissions = []string{PermissionRead, PermissionWrite}
+
+// RoleAdmin is the agent role that bypasses ownership and membership checks
+const RoleAdmin = "admin"
+
+// AuthzError explains why a request was denied
+type AuthzError struct {
+	AgentID string
+	Action  Action
+	Reason  string
+}
+
+func (e *AuthzError) Error() string {
+	return fmt.Sprintf("forbidden: agent %q may not %s: %s", e.AgentID, e.Action, e.Reason)
+}
+
+func (e *AuthzError) Unwrap() error {
+	return ErrForbidden
+}
+
+// ErrorDetails reports who was denied what in error responses
+func (e *AuthzError) ErrorDetails() interface{} {
+	return map[string]interface{}{"agent_id": e.AgentID, "action": e.Action, "reason": e.Reason}
+}
+
+// Authorizer checks the calling agent's permissions, team membership and
+// per-key ACLs before memory and shared memory operations
+type Authorizer struct {
+	manager SharedBackend
+	shared  *SharedStore
+	scopes  *ScopeDirectory
+	roster  *TeamRoster
+	agents  *AgentDirectory
+}
+
+// NewAuthorizer creates a new authorizer
+func NewAuthorizer(manager SharedBackend, shared *SharedStore, scopes *ScopeDirectory, roster *TeamRoster, agents *AgentDirectory) *Authorizer {
+	return &Authorizer{
+		manager: manager,
+		shared:  shared,
+		scopes:  scopes,
+		roster:  roster,
+		agents:  agents,
+	}
+}
+
+// CallerID resolves the calling agent. An authenticated identity that
+// names an agent always wins. A tenant key names no agent: it speaks for
+// every agent of its tenant, so its callers name theirs with the X-Agent-ID
+// header, which only resolves within the tenant the key selected.
+// Otherwise the header is only trusted when the server does not require
+// authentication. Query parameters never identify the caller.
+func CallerID(r *http.Request) string {
+	if id, ok := IdentityFromContext(r.Context()); ok {
+		if id.AgentID != "" {
+			return id.AgentID
+		}
+		if id.TenantID != "" {
+			return r.Header.Get(AgentIDHeader)
+		}
+	}
+	if agentHeaderTrusted(r.Context()) {
+		return r.Header.Get(AgentIDHeader)
+	}
+	return ""
+}
+
+func (a *Authorizer) resolve(ctx context.Context, callerID string, action Action) (*Agent, error) {
+	if callerID == "" {
+		return nil, &AuthzError{Action: action, Reason: "calling agent not identified"}
+	}
+
+	agent, err := a.manager.GetAgent(ctx, callerID)
+	if err != nil {
+		return nil, &AuthzError{AgentID: callerID, Action: action, Reason: "agent is not registered"}
+	}
+	deregistered, err := a.agents.IsDeregistered(ctx, callerID)
+	if err != nil {
+		return nil, err
+	}
+	if deregistered {
+		return nil, &AuthzError{AgentID: callerID, Action: action, Reason: "agent is not registered"}
+	}
+
+	if !hasPermission(agent.Permissions, action) && agent.Role != RoleAdmin {
+		return nil, &AuthzError{AgentID: callerID, Action: action, Reason: "missing " + requiredPermission(action) + " permission"}
+	}
+	return agent, nil
+}
+
+// AuthorizeAgent checks that the caller is a registered agent whose
+// permissions allow the action
+func (a *Authorizer) AuthorizeAgent(ctx context.Context, callerID string, action Action) error {
+	_, err := a.resolve(ctx, callerID, action)
+	return err
+}
+
+// AuthorizeMemory checks that the caller may act on memories owned by ownerID.
+// Agents may only touch their own memories unless they are admins.
+func (a *Authorizer) AuthorizeMemory(ctx context.Context, callerID, ownerID string, action Action) error {
+	agent, err := a.resolve(ctx, callerID, action)
+	if err != nil {
+		return err
+	}
+
+	if ownerID != callerID && !isAdmin(agent) {
+		return &AuthzError{AgentID: callerID, Action: action, Reason: "memories belong to agent " + ownerID}
+	}
+	return nil
+}
+
+// AuthorizeAdmin checks that the caller is an admin agent
+func (a *Authorizer) AuthorizeAdmin(ctx context.Context, callerID string, action Action) error {
+	agent, err := a.resolve(ctx, callerID, action)
+	if err != nil {
+		return err
+	}
+	if !isAdmin(agent) {
+		return &AuthzError{AgentID: callerID, Action: action, Reason: "admin role required"}
+	}
+	return nil
+}
+
+// AuthorizeTeamOwner checks that the caller owns the team or is an admin
+func (a *Authorizer) AuthorizeTeamOwner(ctx context.Context, callerID, teamID string, action Action) error {
+	agent, err := a.resolve(ctx, callerID, action)
+	if err != nil {
+		return err
+	}
+	if isAdmin(agent) {
+		return nil
+	}
+
+	member, err := a.roster.Member(ctx, teamID, callerID)
+	if err != nil || member.Role != TeamRoleOwner {
+		return &AuthzError{AgentID: callerID, Action: action, Reason: "owner role in team " + teamID + " required"}
+	}
+	return nil
+}
+
+// AuthorizeShared checks that the caller belongs to the scope (a member of
+// the team, or of a team in the organization) and that the key's ACL, or
+// the scope's default permission when the ACL does not name the caller,
+// allows the action. Team owners may act on every key of their team and
+// team readers may only read.
+func (a *Authorizer) AuthorizeShared(ctx context.Context, callerID string, ref ScopeRef, key string, action Action) error {
+	agent, err := a.resolve(ctx, callerID, action)
+	if err != nil {
+		return err
+	}
+	if isAdmin(agent) {
+		return nil
+	}
+
+	switch ref.Scope {
+	case ScopeTeam:
+		member, err := a.roster.Member(ctx, ref.ID, callerID)
+		if err != nil {
+			return &AuthzError{AgentID: callerID, Action: action, Reason: "not a member of team " + ref.ID}
+		}
+		switch member.Role {
+		case TeamRoleOwner:
+			return nil
+		case TeamRoleReader:
+			if action != ActionRead {
+				return &AuthzError{AgentID: callerID, Action: action, Reason: "reader role in team " + ref.ID}
+			}
+		}
+	case ScopeOrganization:
+		member, err := a.inOrganization(ctx, callerID, ref.ID)
+		if err != nil {
+			return err
+		}
+		if !member {
+			return &AuthzError{AgentID: callerID, Action: action, Reason: "not a member of organization " + ref.ID}
+		}
+	}
+
+	perm, err := a.scopes.Default(ctx, ref.Scope)
+	if err != nil {
+		return err
+	}
+	source := string(ref.Scope) + " scope default"
+	if key != "" {
+		// A key that does not exist yet has no ACL to apply
+		if entry, err := a.shared.Get(ctx, ref.Namespace(), key); err == nil {
+			if p, ok := entry.ACL[callerID]; ok {
+				perm, source = p, "ACL on "+key
+			}
+		}
+	}
+	if !aclAllows(perm, action) {
+		return &AuthzError{AgentID: callerID, Action: action, Reason: source + " grants " + perm}
+	}
+	return nil
+}
+
+// AuthorizeSharedAdmin checks that the caller may change who can access a
+// shared key: an admin agent, the owner of the key's team, or an agent the
+// key's ACL grants admin
+func (a *Authorizer) AuthorizeSharedAdmin(ctx context.Context, callerID string, ref ScopeRef, key string, action Action) error {
+	agent, err := a.resolve(ctx, callerID, action)
+	if err != nil {
+		return err
+	}
+	if isAdmin(agent) {
+		return nil
+	}
+
+	if ref.Scope == ScopeTeam {
+		if member, err := a.roster.Member(ctx, ref.ID, callerID); err == nil && member.Role == TeamRoleOwner {
+			return nil
+		}
+	}
+	entry, err := a.shared.Get(ctx, ref.Namespace(), key)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if err == nil && entry.ACL[callerID] == PermissionAdmin {
+		return nil
+	}
+	return &AuthzError{AgentID: callerID, Action: action, Reason: "team owner or admin permission on " + key + " required"}
+}
+
+// inOrganization reports whether the agent is a member of any of the
+// organization's teams
+func (a *Authorizer) inOrganization(ctx context.Context, agentID, orgID string) (bool, error) {
+	teams, err := a.scopes.TeamsIn(ctx, orgID)
+	if err != nil {
+		return false, err
+	}
+	for _, teamID := range teams {
+		if _, err := a.roster.Member(ctx, teamID, agentID); err == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func requiredPermission(action Action) string {
+	if action == ActionRead {
+		return PermissionRead
+	}
+	return PermissionWrite
+}
+
+func hasPermission(permissions []string, action Action) bool {
+	required := requiredPermission(action)
+	for _, p := range permissions {
+		if p == required || p == PermissionAdmin {
+			return true
+		}
+		if p == PermissionWrite && required == PermissionRead {
+			return true
+		}
+	}
+	return false
+}
+
+func isAdmin(agent *Agent) bool {
+	return agent.Role == RoleAdmin || containsString(agent.Permissions, PermissionAdmin)
+}
+
+func aclAllows(perm string, action Action) bool {
+	switch perm {
+	case PermissionAdmin, PermissionWrite:
+		return true
+	case PermissionRead:
+		return action == ActionRead
+	default:
+		return false
+	}
+}
+
+func containsString(values []string, target string) bool {
+	for _, v := range values {
+		if v == target {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package memoryos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCallerIDIgnoresQueryParameter(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/memory?agent_id=victim", nil)
+	req = req.WithContext(context.WithValue(req.Context(), agentHeaderKey{}, true))
+	if id := CallerID(req); id != "" {
+		t.Fatalf("caller identified from the query as %q", id)
+	}
+
+	req.Header.Set(AgentIDHeader, "a")
+	if id := CallerID(req); id != "a" {
+		t.Fatalf("caller = %q", id)
+	}
+}
+
+func TestCallerIDIgnoresHeaderWithAuth(t *testing.T) {
+	b := newTestBackends()
+	s := newTestServer(t, b)
+	registerTestAgent(t, s, "victim")
+	if err := s.SetAuth(&AuthConfig{APIKeys: []APIKeyConfig{{Hash: HashAPIKey("secret"), Name: "a", AgentID: "a"}}, Public: []string{"/memory"}}); err != nil {
+		t.Fatal(err)
+	}
+	s.memoryos.StoreMemory(context.Background(), &Memory{ID: "m", AgentID: "victim", Type: MemoryTypeEpisodic, Content: "secret"})
+
+	// A public path without credentials must not trust the header
+	rec := serve(t, s, http.MethodGet, "/memory?agent_id=victim&id=m", "victim", "")
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestSharedACLRequiresOwnerOrAdmin(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	s := newTestServer(t, b)
+	for _, id := range []string{"owner", "writer", "delegate"} {
+		registerTestAgent(t, s, id)
+	}
+	if err := s.roster.Create(ctx, &Team{ID: "team", Name: "team"}, "owner"); err != nil {
+		t.Fatal(err)
+	}
+	s.roster.AddMember(ctx, "team", "writer", TeamRoleWriter)
+	s.roster.AddMember(ctx, "team", "delegate", TeamRoleWriter)
+	if _, err := s.shared.Create(ctx, "team", "k", json.RawMessage(`1`), "owner"); err != nil {
+		t.Fatal(err)
+	}
+
+	grantSelf := `{"writer": "admin"}`
+	rec := serve(t, s, http.MethodPut, "/shared/acl?team_id=team&key=k", "writer", grantSelf)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("writer changed the ACL: %d %s", rec.Code, rec.Body)
+	}
+
+	rec = serve(t, s, http.MethodPut, "/shared/acl?team_id=team&key=k", "owner", `{"delegate": "admin"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("owner: %d %s", rec.Code, rec.Body)
+	}
+	rec = serve(t, s, http.MethodPut, "/shared/acl?team_id=team&key=k", "delegate", `{"delegate": "admin", "writer": "read"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("ACL admin: %d %s", rec.Code, rec.Body)
+	}
+
+	err := s.authz.AuthorizeShared(ctx, "writer", TeamScope("team"), "k", ActionWrite)
+	if !errors.Is(err, ErrForbidden) {
+		t.Fatalf("writer after ACL change: %v", err)
+	}
+}
+
+func TestAuthorizeMemoryOwnership(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServer(t, newTestBackends())
+	registerTestAgent(t, s, "a")
+	registerTestAgent(t, s, "b")
+	registerTestAgent(t, s, "root", PermissionAdmin)
+
+	if err := s.authz.AuthorizeMemory(ctx, "a", "a", ActionWrite); err != nil {
+		t.Fatalf("own memories: %v", err)
+	}
+	if err := s.authz.AuthorizeMemory(ctx, "a", "b", ActionRead); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("other agent's memories: %v", err)
+	}
+	if err := s.authz.AuthorizeMemory(ctx, "root", "b", ActionWrite); err != nil {
+		t.Fatalf("admin: %v", err)
+	}
+	if err := s.authz.AuthorizeMemory(ctx, "ghost", "ghost", ActionRead); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("unregistered agent: %v", err)
+	}
+}
+
+func TestAgentRegistrationRequiresAdminForPermissions(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	s := newTestServer(t, b)
+	registerTestAgent(t, s, "a")
+	s.manager.RegisterAgent(ctx, &Agent{ID: "root", Name: "root", Role: RoleAdmin, Permissions: []string{PermissionWrite}})
+
+	for _, target := range []string{"/agent", "/v1/agents"} {
+		if rec := serve(t, s, http.MethodPost, target, "a", `{"ID":"sneaky","Role":"admin"}`); rec.Code != http.StatusForbidden {
+			t.Fatalf("%s: non-admin set a role: %d %s", target, rec.Code, rec.Body)
+		}
+		if rec := serve(t, s, http.MethodPost, target, "", `{"ID":"sneaky","Permissions":["admin"]}`); rec.Code != http.StatusForbidden {
+			t.Fatalf("%s: anonymous caller set permissions: %d %s", target, rec.Code, rec.Body)
+		}
+		if rec := serve(t, s, http.MethodPost, target, "a", `{"ID":"a","Name":"hijacked"}`); rec.Code != http.StatusConflict {
+			t.Fatalf("%s: re-registered an existing agent: %d %s", target, rec.Code, rec.Body)
+		}
+	}
+	if agent, _ := s.manager.GetAgent(ctx, "a"); agent.Name != "a" {
+		t.Fatalf("existing agent overwritten: %+v", agent)
+	}
+
+	if rec := serve(t, s, http.MethodPost, "/agent", "", `{"ID":"plain"}`); rec.Code != http.StatusOK {
+		t.Fatalf("plain registration: %d %s", rec.Code, rec.Body)
+	}
+	plain, _ := s.manager.GetAgent(ctx, "plain")
+	if plain.Role != "" || len(plain.Permissions) != len(DefaultAgentPermissions) {
+		t.Fatalf("plain agent = %+v", plain)
+	}
+
+	if rec := serve(t, s, http.MethodPost, "/v1/agents", "root", `{"ID":"ops","Role":"admin"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("admin registration: %d %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,121 @@
+package memoryos
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// MemoryBackend stores and retrieves agent memories. *MemoryOS implements
+// it; tenant servers wrap it to keep each tenant's agents apart.
+type MemoryBackend interface {
+	StoreMemory(ctx context.Context, memory *Memory) error
+	GetMemory(ctx context.Context, agentID string, memoryType MemoryType, id string) (*Memory, error)
+	DeleteMemory(ctx context.Context, agentID string, memoryType MemoryType, id string) error
+	UpdateMemory(ctx context.Context, memory *Memory) error
+	SearchMemories(ctx context.Context, agentID, query string, limit int) ([]*Memory, error)
+	GetContextWindow(ctx context.Context, agentID string, maxTokens int) (string, error)
+	GetMemoryStats(ctx context.Context, agentID string) (*MemoryStats, error)
+}
+
+// SharedBackend stores agents, teams and shared values.
+// *SharedMemoryManager implements it.
+type SharedBackend interface {
+	GetSystemHealth(ctx context.Context) (map[string]interface{}, error)
+	RegisterAgent(ctx context.Context, agent *Agent) error
+	GetAgent(ctx context.Context, agentID string) (*Agent, error)
+	CreateTeam(ctx context.Context, team *Team) error
+	GetTeam(ctx context.Context, teamID string) (*Team, error)
+	CreateSharedValue(ctx context.Context, teamID, key, value string) error
+	GetSharedValue(ctx context.Context, teamID, key string) (string, error)
+	UpdateSharedValue(ctx context.Context, teamID, key, value string) error
+	DeleteSharedValue(ctx context.Context, teamID, key string) error
+}
+
+// SkillBackend indexes agent skills. *SkillIndex implements it.
+type SkillBackend interface {
+	RegisterSkill(ctx context.Context, agentID string, skill *Skill) error
+	GetSkill(ctx context.Context, agentID, name string) (*Skill, error)
+	GetSkillsByCategory(ctx context.Context, agentID, category string) ([]*Skill, error)
+	DeleteSkill(ctx context.Context, agentID, name string) error
+}
+
+// storageError reports a lookup miss from the core storage layer as
+// ErrNotFound. The core returns misses as redis.Nil or as a "not found"
+// message; any other failure, such as Redis being unreachable, is passed
+// on unchanged.
+func storageError(err error) error {
+	if err == nil || errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if errors.Is(err, redis.Nil) || strings.Contains(strings.ToLower(err.Error()), "not found") {
+		return &kindError{kind: ErrNotFound, msg: err.Error(), cause: err}
+	}
+	return err
+}
+
+// coreMemory classifies the errors of the core MemoryOS
+type coreMemory struct {
+	MemoryBackend
+}
+
+func (c coreMemory) GetMemory(ctx context.Context, agentID string, memoryType MemoryType, id string) (*Memory, error) {
+	memory, err := c.MemoryBackend.GetMemory(ctx, agentID, memoryType, id)
+	return memory, storageError(err)
+}
+
+func (c coreMemory) DeleteMemory(ctx context.Context, agentID string, memoryType MemoryType, id string) error {
+	return storageError(c.MemoryBackend.DeleteMemory(ctx, agentID, memoryType, id))
+}
+
+func (c coreMemory) UpdateMemory(ctx context.Context, memory *Memory) error {
+	return storageError(c.MemoryBackend.UpdateMemory(ctx, memory))
+}
+
+func (c coreMemory) StoreMemories(ctx context.Context, memories []*Memory, opts BatchOptions) []error {
+	return storeMemories(ctx, c.MemoryBackend, memories, opts)
+}
+
+// coreShared classifies the errors of the core SharedMemoryManager
+type coreShared struct {
+	SharedBackend
+}
+
+func (c coreShared) GetAgent(ctx context.Context, agentID string) (*Agent, error) {
+	agent, err := c.SharedBackend.GetAgent(ctx, agentID)
+	return agent, storageError(err)
+}
+
+func (c coreShared) GetTeam(ctx context.Context, teamID string) (*Team, error) {
+	team, err := c.SharedBackend.GetTeam(ctx, teamID)
+	return team, storageError(err)
+}
+
+func (c coreShared) GetSharedValue(ctx context.Context, teamID, key string) (string, error) {
+	value, err := c.SharedBackend.GetSharedValue(ctx, teamID, key)
+	return value, storageError(err)
+}
+
+func (c coreShared) UpdateSharedValue(ctx context.Context, teamID, key, value string) error {
+	return storageError(c.SharedBackend.UpdateSharedValue(ctx, teamID, key, value))
+}
+
+func (c coreShared) DeleteSharedValue(ctx context.Context, teamID, key string) error {
+	return storageError(c.SharedBackend.DeleteSharedValue(ctx, teamID, key))
+}
+
+// coreSkills classifies the errors of the core SkillIndex
+type coreSkills struct {
+	SkillBackend
+}
+
+func (c coreSkills) GetSkill(ctx context.Context, agentID, name string) (*Skill, error) {
+	skill, err := c.SkillBackend.GetSkill(ctx, agentID, name)
+	return skill, storageError(err)
+}
+
+func (c coreSkills) DeleteSkill(ctx context.Context, agentID, name string) error {
+	return storageError(c.SkillBackend.DeleteSkill(ctx, agentID, name))
+}
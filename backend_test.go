@@ -0,0 +1,361 @@
+package memoryos
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// testMemory is an in-memory MemoryBackend. It reports misses the way the
+// core does, as "not found" messages, so tests go through storageError.
+type testMemory struct {
+	mu       sync.Mutex
+	memories map[string]*Memory
+	fail     error
+}
+
+func newTestMemory() *testMemory {
+	return &testMemory{memories: make(map[string]*Memory)}
+}
+
+func (m *testMemory) StoreMemory(ctx context.Context, memory *Memory) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.fail != nil {
+		return m.fail
+	}
+	if memory.ID == "" {
+		memory.ID = uuid.New().String()
+	}
+	now := time.Now()
+	memory.CreatedAt, memory.UpdatedAt = now, now
+	copied := *memory
+	m.memories[memory.AgentID+"/"+memory.ID] = &copied
+	return nil
+}
+
+func (m *testMemory) GetMemory(ctx context.Context, agentID string, memoryType MemoryType, id string) (*Memory, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.fail != nil {
+		return nil, m.fail
+	}
+	memory, ok := m.memories[agentID+"/"+id]
+	if !ok || (memoryType != "" && memory.Type != memoryType) {
+		return nil, fmt.Errorf("memory not found: %s", id)
+	}
+	copied := *memory
+	return &copied, nil
+}
+
+func (m *testMemory) DeleteMemory(ctx context.Context, agentID string, memoryType MemoryType, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.fail != nil {
+		return m.fail
+	}
+	if _, ok := m.memories[agentID+"/"+id]; !ok {
+		return fmt.Errorf("memory not found: %s", id)
+	}
+	delete(m.memories, agentID+"/"+id)
+	return nil
+}
+
+func (m *testMemory) UpdateMemory(ctx context.Context, memory *Memory) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.fail != nil {
+		return m.fail
+	}
+	if _, ok := m.memories[memory.AgentID+"/"+memory.ID]; !ok {
+		return fmt.Errorf("memory not found: %s", memory.ID)
+	}
+	copied := *memory
+	m.memories[memory.AgentID+"/"+memory.ID] = &copied
+	return nil
+}
+
+func (m *testMemory) SearchMemories(ctx context.Context, agentID, query string, limit int) ([]*Memory, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.fail != nil {
+		return nil, m.fail
+	}
+	results := []*Memory{}
+	for _, memory := range m.memories {
+		if memory.AgentID == agentID && strings.Contains(memory.Content, query) {
+			copied := *memory
+			results = append(results, &copied)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].ID < results[j].ID })
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+func (m *testMemory) GetContextWindow(ctx context.Context, agentID string, maxTokens int) (string, error) {
+	return "", nil
+}
+
+func (m *testMemory) GetMemoryStats(ctx context.Context, agentID string) (*MemoryStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.fail != nil {
+		return nil, m.fail
+	}
+	stats := &MemoryStats{ByType: make(map[string]int)}
+	for _, memory := range m.memories {
+		if memory.AgentID == agentID {
+			stats.TotalMemories++
+			stats.ByType[string(memory.Type)]++
+		}
+	}
+	return stats, nil
+}
+
+// testShared is an in-memory SharedBackend
+type testShared struct {
+	mu     sync.Mutex
+	agents map[string]*Agent
+	teams  map[string]*Team
+	values map[string]string
+	fail   error
+}
+
+func newTestShared() *testShared {
+	return &testShared{
+		agents: make(map[string]*Agent),
+		teams:  make(map[string]*Team),
+		values: make(map[string]string),
+	}
+}
+
+func (s *testShared) GetSystemHealth(ctx context.Context) (map[string]interface{}, error) {
+	return map[string]interface{}{"status": "ok"}, nil
+}
+
+func (s *testShared) RegisterAgent(ctx context.Context, agent *Agent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if agent.ID == "" {
+		agent.ID = uuid.New().String()
+	}
+	copied := *agent
+	s.agents[agent.ID] = &copied
+	return nil
+}
+
+func (s *testShared) GetAgent(ctx context.Context, agentID string) (*Agent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	agent, ok := s.agents[agentID]
+	if !ok {
+		return nil, fmt.Errorf("agent not found: %s", agentID)
+	}
+	copied := *agent
+	return &copied, nil
+}
+
+func (s *testShared) CreateTeam(ctx context.Context, team *Team) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if team.ID == "" {
+		team.ID = uuid.New().String()
+	}
+	copied := *team
+	s.teams[team.ID] = &copied
+	return nil
+}
+
+func (s *testShared) GetTeam(ctx context.Context, teamID string) (*Team, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	team, ok := s.teams[teamID]
+	if !ok {
+		return nil, fmt.Errorf("team not found: %s", teamID)
+	}
+	copied := *team
+	return &copied, nil
+}
+
+func (s *testShared) CreateSharedValue(ctx context.Context, teamID, key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail != nil {
+		return s.fail
+	}
+	s.values[sharedKey(teamID, key)] = value
+	return nil
+}
+
+func (s *testShared) GetSharedValue(ctx context.Context, teamID, key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail != nil {
+		return "", s.fail
+	}
+	value, ok := s.values[sharedKey(teamID, key)]
+	if !ok {
+		return "", fmt.Errorf("shared value not found: %s", key)
+	}
+	return value, nil
+}
+
+func (s *testShared) UpdateSharedValue(ctx context.Context, teamID, key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail != nil {
+		return s.fail
+	}
+	s.values[sharedKey(teamID, key)] = value
+	return nil
+}
+
+func (s *testShared) DeleteSharedValue(ctx context.Context, teamID, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail != nil {
+		return s.fail
+	}
+	delete(s.values, sharedKey(teamID, key))
+	return nil
+}
+
+func (s *testShared) setFail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fail = err
+}
+
+// testSkills is an in-memory SkillBackend
+type testSkills struct {
+	mu     sync.Mutex
+	skills map[string]*Skill
+}
+
+func newTestSkills() *testSkills {
+	return &testSkills{skills: make(map[string]*Skill)}
+}
+
+func (s *testSkills) RegisterSkill(ctx context.Context, agentID string, skill *Skill) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	copied := *skill
+	s.skills[agentID+"/"+skill.Name] = &copied
+	return nil
+}
+
+func (s *testSkills) GetSkill(ctx context.Context, agentID, name string) (*Skill, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	skill, ok := s.skills[agentID+"/"+name]
+	if !ok {
+		return nil, fmt.Errorf("skill not found: %s", name)
+	}
+	copied := *skill
+	return &copied, nil
+}
+
+func (s *testSkills) GetSkillsByCategory(ctx context.Context, agentID, category string) ([]*Skill, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	skills := []*Skill{}
+	for k, skill := range s.skills {
+		if strings.HasPrefix(k, agentID+"/") && skill.Category == category {
+			copied := *skill
+			skills = append(skills, &copied)
+		}
+	}
+	sort.Slice(skills, func(i, j int) bool { return skills[i].Name < skills[j].Name })
+	return skills, nil
+}
+
+func (s *testSkills) DeleteSkill(ctx context.Context, agentID, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.skills[agentID+"/"+name]; !ok {
+		return fmt.Errorf("skill not found: %s", name)
+	}
+	delete(s.skills, agentID+"/"+name)
+	return nil
+}
+
+// testBackends are the storage a test server runs on
+type testBackends struct {
+	memory *testMemory
+	shared *testShared
+	skills *testSkills
+	state  *LocalState
+}
+
+func newTestBackends() *testBackends {
+	return &testBackends{
+		memory: newTestMemory(),
+		shared: newTestShared(),
+		skills: newTestSkills(),
+		state:  NewLocalState(),
+	}
+}
+
+// storage is the unprefixed storage tenant servers are built on
+func (b *testBackends) storage() *tenantStorage {
+	return &tenantStorage{
+		memory: coreMemory{b.memory},
+		shared: coreShared{b.shared},
+		skills: coreSkills{b.skills},
+		state:  b.state,
+	}
+}
+
+// newTestServer builds a server on the test backends the way NewServer
+// builds one on the core
+func newTestServer(t *testing.T, b *testBackends) *Server {
+	t.Helper()
+	storage := b.storage()
+	s := newTenantServer(storage, DefaultTenantID)
+	s.auth = newAuthenticator()
+	s.tenants = NewTenantRegistry(s, storage)
+	s.limits = NewRateLimiter(RateLimitConfig{})
+	s.idempotency = NewIdempotencyStore(storage.state, DefaultIdempotencyWindow)
+	s.mux = http.NewServeMux()
+	s.timeouts = DefaultTimeouts()
+	s.routes()
+	return s
+}
+
+// registerTestAgent registers an agent that may write its own memories
+func registerTestAgent(t *testing.T, s *Server, agentID string, permissions ...string) {
+	t.Helper()
+	if len(permissions) == 0 {
+		permissions = []string{PermissionWrite}
+	}
+	agent := &Agent{ID: agentID, Name: agentID, Permissions: permissions}
+	if err := s.manager.RegisterAgent(context.Background(), agent); err != nil {
+		t.Fatalf("register %s: %v", agentID, err)
+	}
+}
+
+// serve sends a request to the server's handler as agentID
+func serve(t *testing.T, s *Server, method, target, agentID, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if agentID != "" {
+		req.Header.Set("X-Agent-ID", agentID)
+	}
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	return rec
+}
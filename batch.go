@@ -0,0 +1,170 @@
+package memoryos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// maxBatchSize bounds the number of items in one batch request
+const maxBatchSize = 500
+
+// ErrBatchAborted marks the memories of an atomic batch that were not
+// stored because another memory of the batch failed
+var ErrBatchAborted = errors.New("batch aborted")
+
+// BatchOptions control how a batch of memories is stored
+type BatchOptions struct {
+	// Atomic stores every memory of the batch or none of them
+	Atomic bool `json:"atomic,omitempty"`
+}
+
+// BatchMemoryBackend is implemented by backends that store a batch of
+// memories themselves, for example to honour an atomic batch with a
+// transaction. The returned slice holds each memory's error, nil for the
+// memories that were stored.
+type BatchMemoryBackend interface {
+	StoreMemories(ctx context.Context, memories []*Memory, opts BatchOptions) []error
+}
+
+// BatchItemResult is the outcome of one item of a batch
+type BatchItemResult struct {
+	Index  int       `json:"index"`
+	ID     string    `json:"id,omitempty"`
+	Status int       `json:"status"`
+	Memory *Memory   `json:"memory,omitempty"`
+	Error  *APIError `json:"error,omitempty"`
+}
+
+// BatchResult reports the outcome of every item of a batch
+type BatchResult struct {
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	Results   []BatchItemResult `json:"results"`
+}
+
+func newBatchResult(size int) *BatchResult {
+	return &BatchResult{Results: make([]BatchItemResult, 0, size)}
+}
+
+// add records the outcome of the next item
+func (b *BatchResult) add(id string, status int, memory *Memory, err error) {
+	item := BatchItemResult{Index: len(b.Results), ID: id, Status: status, Memory: memory}
+	if err != nil {
+		item.Status, item.Error = apiError(err, http.StatusInternalServerError)
+		item.Memory = nil
+		b.Failed++
+	} else {
+		b.Succeeded++
+	}
+	b.Results = append(b.Results, item)
+}
+
+// status is 200 when every item succeeded and 207 Multi-Status otherwise
+func (b *BatchResult) status() int {
+	if b.Failed > 0 {
+		return http.StatusMultiStatus
+	}
+	return http.StatusOK
+}
+
+// StoreMemories stores a batch of memories and returns the error of each,
+// nil for those stored. The core writes them one at a time; an atomic batch
+// stops at the first failure and removes the memories already stored.
+func (m *MemoryOS) StoreMemories(ctx context.Context, memories []*Memory, opts BatchOptions) []error {
+	return storeEach(ctx, m, memories, opts)
+}
+
+// storeMemories stores a batch through backend, handing over the whole batch
+// when the backend supports it
+func storeMemories(ctx context.Context, backend MemoryBackend, memories []*Memory, opts BatchOptions) []error {
+	if batcher, ok := backend.(BatchMemoryBackend); ok {
+		return batcher.StoreMemories(ctx, memories, opts)
+	}
+	return storeEach(ctx, backend, memories, opts)
+}
+
+// storeEach stores a batch one memory at a time. An atomic batch stops at
+// the first failure and deletes the memories stored before it, even when
+// ctx has been cancelled; a memory that could not be deleted keeps the
+// delete error so the caller knows it is still stored.
+func storeEach(ctx context.Context, backend MemoryBackend, memories []*Memory, opts BatchOptions) []error {
+	errs := make([]error, len(memories))
+	for i, memory := range memories {
+		if errs[i] = backend.StoreMemory(ctx, memory); errs[i] == nil || !opts.Atomic {
+			continue
+		}
+		cleanup := context.WithoutCancel(ctx)
+		for j, stored := range memories[:i] {
+			if err := backend.DeleteMemory(cleanup, stored.AgentID, stored.Type, stored.ID); err != nil {
+				errs[j] = fmt.Errorf("batch aborted but memory %s could not be removed: %w", stored.ID, err)
+			}
+		}
+		return abortBatch(errs)
+	}
+	return errs
+}
+
+// forwardBatch passes the memories without an error so far on to backend
+// and merges the outcome into errs. An atomic batch that already has an
+// error is aborted without storing anything.
+func forwardBatch(ctx context.Context, backend MemoryBackend, memories []*Memory, errs []error, opts BatchOptions) []error {
+	pending := make([]*Memory, 0, len(memories))
+	index := make([]int, 0, len(memories))
+	for i, err := range errs {
+		if err != nil {
+			if opts.Atomic {
+				return abortBatch(errs)
+			}
+			continue
+		}
+		pending = append(pending, memories[i])
+		index = append(index, i)
+	}
+	if len(pending) == 0 {
+		return errs
+	}
+
+	for j, err := range storeMemories(ctx, backend, pending, opts) {
+		errs[index[j]] = err
+	}
+	return errs
+}
+
+// abortBatch marks every memory without an error as aborted
+func abortBatch(errs []error) []error {
+	for i, err := range errs {
+		if err == nil {
+			errs[i] = ErrBatchAborted
+		}
+	}
+	return errs
+}
+
+// getMemories looks up memories by ID, reporting each miss in the result
+func getMemories(ctx context.Context, backend MemoryBackend, agentID string, memoryType MemoryType, ids []string) *BatchResult {
+	result := newBatchResult(len(ids))
+	for _, id := range ids {
+		memory, err := backend.GetMemory(ctx, agentID, memoryType, id)
+		result.add(id, http.StatusOK, memory, err)
+	}
+	return result
+}
+
+// deleteMemories deletes the given memories, reporting each failure in the
+// result
+func deleteMemories(ctx context.Context, backend MemoryBackend, memories []*Memory) *BatchResult {
+	result := newBatchResult(len(memories))
+	for _, memory := range memories {
+		err := backend.DeleteMemory(ctx, memory.AgentID, memory.Type, memory.ID)
+		result.add(memory.ID, http.StatusOK, nil, err)
+	}
+	return result
+}
+
+// hasConditions reports whether the query narrows the agent's memories at
+// all, so that deleting by an empty query cannot wipe an agent
+func (q MemoryQuery) hasConditions() bool {
+	return q.Type != nil || len(q.Tags) > 0 || len(q.Keywords) > 0 || q.MinImportance > 0 || q.Since != nil
+}
@@ -0,0 +1,44 @@
+package memoryos
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// rejectedStore fails to store the memory with the given ID
+type rejectedStore struct {
+	failingDeletes
+	reject string
+}
+
+func (m rejectedStore) StoreMemory(ctx context.Context, memory *Memory) error {
+	if memory.ID == m.reject {
+		return errorf(ErrInvalid, "rejected")
+	}
+	return m.testMemory.StoreMemory(ctx, memory)
+}
+
+func TestAtomicBatchReportsFailedRollback(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	backend := coreMemory{rejectedStore{failingDeletes{b.memory, "m1"}, "m3"}}
+
+	var memories []*Memory
+	for _, id := range []string{"m1", "m2", "m3", "m4"} {
+		memories = append(memories, &Memory{ID: id, AgentID: "a", Type: MemoryTypeEpisodic, Content: id})
+	}
+	errs := storeEach(ctx, backend, memories, BatchOptions{Atomic: true})
+
+	if errs[0] == nil || errors.Is(errs[0], ErrBatchAborted) || !strings.Contains(errs[0].Error(), "disk full") {
+		t.Fatalf("memory left behind by the rollback: %v", errs[0])
+	}
+	if !errors.Is(errs[1], ErrBatchAborted) || !errors.Is(errs[3], ErrBatchAborted) || !errors.Is(errs[2], ErrInvalid) {
+		t.Fatalf("errs = %v", errs)
+	}
+	left, _ := b.memory.SearchMemories(ctx, "a", "", 0)
+	if len(left) != 1 || left[0].ID != "m1" {
+		t.Fatalf("left after rollback: %v", left)
+	}
+}
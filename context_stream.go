@@ -0,0 +1,166 @@
+package memoryos
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// Events emitted while a context window is built
+const (
+	ContextEventMemory   = "memory"
+	ContextEventProgress = "progress"
+	ContextEventSummary  = "summary"
+	ContextEventError    = "error"
+)
+
+// ContextStrategy names how ContextBuilder compresses a context
+const ContextStrategy = "importance_recency"
+
+// minTruncatedTokens is the smallest budget worth filling with the start of
+// a memory that does not fit whole
+const minTruncatedTokens = 16
+
+// ContextProgress reports the outcome of one compression step
+type ContextProgress struct {
+	Step       string `json:"step"`
+	Candidates int    `json:"candidates"`
+	Removed    int    `json:"removed,omitempty"`
+	Selected   int    `json:"selected,omitempty"`
+	Tokens     int    `json:"tokens"`
+	MaxTokens  int    `json:"max_tokens"`
+}
+
+// ContextSelection is a memory selected for the context and the line it
+// contributes
+type ContextSelection struct {
+	Position  int     `json:"position"`
+	Memory    *Memory `json:"memory"`
+	Line      string  `json:"line"`
+	Tokens    int     `json:"tokens"`
+	Truncated bool    `json:"truncated,omitempty"`
+}
+
+// ContextBuilder assembles an agent's context window from its most
+// important and most recent memories, reporting each step as it goes so
+// that clients can start on the context before it is complete
+type ContextBuilder struct {
+	memoryos MemoryBackend
+	now      func() time.Time
+}
+
+// NewContextBuilder creates a context builder
+func NewContextBuilder(memoryos MemoryBackend) *ContextBuilder {
+	return &ContextBuilder{memoryos: memoryos, now: time.Now}
+}
+
+// Build selects memories until maxTokens is reached. emit is called with a
+// ContextSelection for every memory as it is selected and a
+// ContextProgress after each step; an error from emit stops the build.
+func (b *ContextBuilder) Build(ctx context.Context, agentID string, maxTokens int, emit func(event string, data interface{}) error) (*CompressedContext, error) {
+	memories, err := b.memoryos.SearchMemories(ctx, agentID, "", queryScanLimit)
+	if err != nil {
+		return nil, err
+	}
+	original := 0
+	for _, memory := range memories {
+		original += estimateTokens(contextLine(memory, memory.Content))
+	}
+	if err := emit(ContextEventProgress, ContextProgress{Step: "collect", Candidates: len(memories), Tokens: original, MaxTokens: maxTokens}); err != nil {
+		return nil, err
+	}
+
+	// Repeated content only earns its place once
+	seen := make(map[string]bool, len(memories))
+	unique := make([]*Memory, 0, len(memories))
+	for _, memory := range memories {
+		hash := contentHash(memory.Content)
+		if !seen[hash] {
+			seen[hash] = true
+			unique = append(unique, memory)
+		}
+	}
+	if err := emit(ContextEventProgress, ContextProgress{Step: "dedupe", Candidates: len(unique), Removed: len(memories) - len(unique), MaxTokens: maxTokens}); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(unique, func(i, j int) bool {
+		if unique[i].Importance != unique[j].Importance {
+			return unique[i].Importance > unique[j].Importance
+		}
+		return unique[i].UpdatedAt.After(unique[j].UpdatedAt)
+	})
+	if err := emit(ContextEventProgress, ContextProgress{Step: "rank", Candidates: len(unique), MaxTokens: maxTokens}); err != nil {
+		return nil, err
+	}
+
+	result := &CompressedContext{
+		ID:               uuid.New().String(),
+		AgentID:          agentID,
+		OriginalSize:     original,
+		IncludedMemories: []string{},
+		Strategy:         ContextStrategy,
+	}
+	var summary strings.Builder
+	used := 0
+	for _, memory := range unique {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		selection := ContextSelection{Position: len(result.IncludedMemories), Memory: memory, Line: contextLine(memory, memory.Content)}
+		selection.Tokens = estimateTokens(selection.Line)
+		if used+selection.Tokens > maxTokens {
+			remaining := maxTokens - used
+			if remaining < minTruncatedTokens {
+				break
+			}
+			selection.Line = truncateLine(memory, remaining)
+			selection.Tokens = estimateTokens(selection.Line)
+			selection.Truncated = true
+		}
+
+		summary.WriteString(selection.Line)
+		result.IncludedMemories = append(result.IncludedMemories, memory.ID)
+		used += selection.Tokens
+		if err := emit(ContextEventMemory, selection); err != nil {
+			return nil, err
+		}
+		if selection.Truncated {
+			break
+		}
+	}
+	if err := emit(ContextEventProgress, ContextProgress{Step: "select", Candidates: len(unique), Selected: len(result.IncludedMemories), Tokens: used, MaxTokens: maxTokens}); err != nil {
+		return nil, err
+	}
+
+	result.Summary = summary.String()
+	result.CompressedSize = used
+	result.CreatedAt = b.now()
+	return result, nil
+}
+
+// contextLine renders a memory as one line of context
+func contextLine(memory *Memory, content string) string {
+	return fmt.Sprintf("(%s) %s\n", memory.Type, content)
+}
+
+// truncateLine shortens a memory's line to fit within tokens, cutting its
+// content at a rune boundary
+func truncateLine(memory *Memory, tokens int) string {
+	const ellipsis = "..."
+	content := memory.Content
+	if budget := tokens*4 - len(contextLine(memory, ellipsis)); budget < len(content) {
+		cut := max(budget, 0)
+		for cut > 0 && !utf8.RuneStart(content[cut]) {
+			cut--
+		}
+		content = content[:cut]
+	}
+	return contextLine(memory, content+ellipsis)
+}
@@ -0,0 +1,44 @@
+package memoryos
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestContextPlainMatchesStream(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServer(t, newTestBackends())
+	registerTestAgent(t, s, "a")
+	for _, content := range []string{"met the team", "shipped the release", "planned the next sprint"} {
+		if err := s.memoryos.StoreMemory(ctx, &Memory{AgentID: "a", Type: MemoryTypeEpisodic, Content: content, Importance: 0.5}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := serve(t, s, http.MethodGet, "/context?agent_id=a&max_tokens=1000", "a", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var plain map[string]string
+	if err := json.NewDecoder(rec.Body).Decode(&plain); err != nil {
+		t.Fatal(err)
+	}
+
+	rec = serve(t, s, http.MethodGet, "/context?agent_id=a&max_tokens=1000&stream=true", "a", "")
+	var summary CompressedContext
+	lines := strings.Split(rec.Body.String(), "\n")
+	for i, line := range lines {
+		if line == "event: "+ContextEventSummary && i+1 < len(lines) {
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[i+1], "data: ")), &summary); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if plain["context"] == "" || plain["context"] != summary.Summary {
+		t.Fatalf("plain context %q, streamed %q", plain["context"], summary.Summary)
+	}
+}
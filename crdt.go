@@ -0,0 +1,490 @@
+package memoryos
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CRDTType names a conflict-free replicated data type
+type CRDTType string
+
+const (
+	CRDTLWWRegister CRDTType = "lww-register" // last-writer-wins register
+	CRDTORSet       CRDTType = "or-set"       // observed-remove set
+	CRDTPNCounter   CRDTType = "pn-counter"   // increment/decrement counter
+	CRDTRGAText     CRDTType = "rga-text"     // replicated growable array of text
+)
+
+// CRDT is a state-based replicated data type. Merge is commutative,
+// associative and idempotent, so replicas that exchange state converge.
+type CRDT interface {
+	Type() CRDTType
+	Value() interface{}
+	Merge(other CRDT) error
+}
+
+// CRDTState is the wire and storage form of a CRDT
+type CRDTState struct {
+	Type  CRDTType        `json:"type"`
+	State json.RawMessage `json:"state"`
+}
+
+// NewCRDT creates an empty CRDT of the given type
+func NewCRDT(typ CRDTType) (CRDT, error) {
+	switch typ {
+	case CRDTLWWRegister:
+		return &LWWRegister{}, nil
+	case CRDTORSet:
+		return NewORSet(), nil
+	case CRDTPNCounter:
+		return NewPNCounter(), nil
+	case CRDTRGAText:
+		return &RGAText{}, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown CRDT type %q", ErrInvalidValue, typ)
+	}
+}
+
+// EncodeCRDT serializes a CRDT for storage or sync
+func EncodeCRDT(c CRDT) (CRDTState, error) {
+	state, err := json.Marshal(c)
+	if err != nil {
+		return CRDTState{}, err
+	}
+	return CRDTState{Type: c.Type(), State: state}, nil
+}
+
+// DecodeCRDT restores a CRDT from its serialized state
+func DecodeCRDT(state CRDTState) (CRDT, error) {
+	c, err := NewCRDT(state.Type)
+	if err != nil {
+		return nil, err
+	}
+	if len(state.State) > 0 {
+		if err := json.Unmarshal(state.State, c); err != nil {
+			return nil, fmt.Errorf("%w: %s state: %v", ErrInvalidValue, state.Type, err)
+		}
+	}
+	return c, nil
+}
+
+func crdtTypeMismatch(want CRDTType, got CRDT) error {
+	return fmt.Errorf("%w: cannot merge %s into %s", ErrInvalidValue, got.Type(), want)
+}
+
+// ========== LWW REGISTER ==========
+
+// LWWRegister holds a single value; the write with the highest timestamp
+// wins, with the replica ID breaking ties
+type LWWRegister struct {
+	Data      json.RawMessage `json:"value,omitempty"`
+	Timestamp int64           `json:"timestamp"`
+	Replica   string          `json:"replica"`
+}
+
+func (r *LWWRegister) Type() CRDTType { return CRDTLWWRegister }
+
+func (r *LWWRegister) Value() interface{} { return r.Data }
+
+// Set writes a value stamped with the replica's clock
+func (r *LWWRegister) Set(value json.RawMessage, timestamp int64, replica string) {
+	r.Merge(&LWWRegister{Data: value, Timestamp: timestamp, Replica: replica})
+}
+
+func (r *LWWRegister) Merge(other CRDT) error {
+	o, ok := other.(*LWWRegister)
+	if !ok {
+		return crdtTypeMismatch(CRDTLWWRegister, other)
+	}
+	if o.Timestamp > r.Timestamp || (o.Timestamp == r.Timestamp && o.Replica > r.Replica) {
+		*r = *o
+	}
+	return nil
+}
+
+// ========== PN COUNTER ==========
+
+// PNCounter is a counter that supports increments and decrements from any
+// number of replicas
+type PNCounter struct {
+	P map[string]int64 `json:"p"`
+	N map[string]int64 `json:"n"`
+}
+
+// NewPNCounter creates a zero counter
+func NewPNCounter() *PNCounter {
+	return &PNCounter{P: make(map[string]int64), N: make(map[string]int64)}
+}
+
+func (c *PNCounter) Type() CRDTType { return CRDTPNCounter }
+
+func (c *PNCounter) Value() interface{} {
+	var total int64
+	for _, v := range c.P {
+		total += v
+	}
+	for _, v := range c.N {
+		total -= v
+	}
+	return total
+}
+
+// Increment adds delta (which may be negative) on behalf of a replica
+func (c *PNCounter) Increment(replica string, delta int64) {
+	if c.P == nil {
+		c.P = make(map[string]int64)
+	}
+	if c.N == nil {
+		c.N = make(map[string]int64)
+	}
+	if delta >= 0 {
+		c.P[replica] += delta
+	} else {
+		c.N[replica] -= delta
+	}
+}
+
+func (c *PNCounter) Merge(other CRDT) error {
+	o, ok := other.(*PNCounter)
+	if !ok {
+		return crdtTypeMismatch(CRDTPNCounter, other)
+	}
+	c.P = mergeMax(c.P, o.P)
+	c.N = mergeMax(c.N, o.N)
+	return nil
+}
+
+func mergeMax(a, b map[string]int64) map[string]int64 {
+	if a == nil {
+		a = make(map[string]int64)
+	}
+	for k, v := range b {
+		if v > a[k] {
+			a[k] = v
+		}
+	}
+	return a
+}
+
+// ========== OR SET ==========
+
+// ORSet is an observed-remove set: an element is present while it has an
+// add tag that has not been removed, so concurrent add wins over remove
+type ORSet struct {
+	Adds    map[string]map[string]bool `json:"adds"`
+	Removes map[string]map[string]bool `json:"removes"`
+}
+
+// NewORSet creates an empty set
+func NewORSet() *ORSet {
+	return &ORSet{
+		Adds:    make(map[string]map[string]bool),
+		Removes: make(map[string]map[string]bool),
+	}
+}
+
+func (s *ORSet) Type() CRDTType { return CRDTORSet }
+
+// Value returns the present elements in a stable order
+func (s *ORSet) Value() interface{} {
+	elements := []string{}
+	for element, tags := range s.Adds {
+		for tag := range tags {
+			if !s.Removes[element][tag] {
+				elements = append(elements, element)
+				break
+			}
+		}
+	}
+	sort.Strings(elements)
+	return elements
+}
+
+// Add inserts an element with a tag unique to this add, such as
+// "<replica>:<counter>"
+func (s *ORSet) Add(element, tag string) {
+	if s.Adds == nil {
+		s.Adds = make(map[string]map[string]bool)
+	}
+	if s.Adds[element] == nil {
+		s.Adds[element] = make(map[string]bool)
+	}
+	s.Adds[element][tag] = true
+}
+
+// Remove tombstones every add of the element this replica has observed
+func (s *ORSet) Remove(element string) {
+	if s.Removes == nil {
+		s.Removes = make(map[string]map[string]bool)
+	}
+	for tag := range s.Adds[element] {
+		if s.Removes[element] == nil {
+			s.Removes[element] = make(map[string]bool)
+		}
+		s.Removes[element][tag] = true
+	}
+}
+
+func (s *ORSet) Merge(other CRDT) error {
+	o, ok := other.(*ORSet)
+	if !ok {
+		return crdtTypeMismatch(CRDTORSet, other)
+	}
+	s.Adds = unionTags(s.Adds, o.Adds)
+	s.Removes = unionTags(s.Removes, o.Removes)
+	return nil
+}
+
+func unionTags(a, b map[string]map[string]bool) map[string]map[string]bool {
+	if a == nil {
+		a = make(map[string]map[string]bool)
+	}
+	for element, tags := range b {
+		if a[element] == nil {
+			a[element] = make(map[string]bool)
+		}
+		for tag := range tags {
+			a[element][tag] = true
+		}
+	}
+	return a
+}
+
+// ========== RGA TEXT ==========
+
+// RGAID identifies an RGA node by Lamport counter and replica
+type RGAID struct {
+	Counter int64  `json:"c"`
+	Replica string `json:"r"`
+}
+
+func (id RGAID) isHead() bool { return id.Counter == 0 && id.Replica == "" }
+
+func (id RGAID) less(other RGAID) bool {
+	if id.Counter != other.Counter {
+		return id.Counter < other.Counter
+	}
+	return id.Replica < other.Replica
+}
+
+// RGANode is one character of replicated text, inserted after another node
+type RGANode struct {
+	ID      RGAID  `json:"id"`
+	After   RGAID  `json:"after"`
+	Char    string `json:"ch"`
+	Deleted bool   `json:"del,omitempty"`
+}
+
+// RGAText is a replicated growable array holding collaboratively edited
+// text. Deleted characters stay as tombstones so inserts can anchor on them.
+type RGAText struct {
+	Nodes []RGANode `json:"nodes"`
+}
+
+func (t *RGAText) Type() CRDTType { return CRDTRGAText }
+
+func (t *RGAText) Value() interface{} { return t.String() }
+
+// String returns the visible text
+func (t *RGAText) String() string {
+	var b strings.Builder
+	for _, node := range t.ordered() {
+		if !node.Deleted {
+			b.WriteString(node.Char)
+		}
+	}
+	return b.String()
+}
+
+// ordered linearizes the nodes: children follow their anchor, newest first
+func (t *RGAText) ordered() []RGANode {
+	children := make(map[RGAID][]RGANode)
+	for _, node := range t.Nodes {
+		children[node.After] = append(children[node.After], node)
+	}
+	for _, siblings := range children {
+		sort.Slice(siblings, func(i, j int) bool {
+			return siblings[j].ID.less(siblings[i].ID)
+		})
+	}
+
+	ordered := make([]RGANode, 0, len(t.Nodes))
+	var walk func(parent RGAID)
+	walk = func(parent RGAID) {
+		for _, node := range children[parent] {
+			ordered = append(ordered, node)
+			walk(node.ID)
+		}
+	}
+	walk(RGAID{})
+	return ordered
+}
+
+func (t *RGAText) clock() int64 {
+	var max int64
+	for _, node := range t.Nodes {
+		if node.ID.Counter > max {
+			max = node.ID.Counter
+		}
+	}
+	return max
+}
+
+// Insert adds text at a visible position on behalf of a replica
+func (t *RGAText) Insert(pos int, text, replica string) {
+	anchor := RGAID{}
+	visible := 0
+	for _, node := range t.ordered() {
+		if visible == pos {
+			break
+		}
+		anchor = node.ID
+		if !node.Deleted {
+			visible++
+		}
+	}
+
+	counter := t.clock()
+	for _, ch := range text {
+		counter++
+		id := RGAID{Counter: counter, Replica: replica}
+		t.Nodes = append(t.Nodes, RGANode{ID: id, After: anchor, Char: string(ch)})
+		anchor = id
+	}
+}
+
+// Delete removes n visible characters starting at pos
+func (t *RGAText) Delete(pos, n int) {
+	targets := make(map[RGAID]bool)
+	visible := 0
+	for _, node := range t.ordered() {
+		if node.Deleted {
+			continue
+		}
+		if visible >= pos && visible < pos+n {
+			targets[node.ID] = true
+		}
+		visible++
+	}
+	for i := range t.Nodes {
+		if targets[t.Nodes[i].ID] {
+			t.Nodes[i].Deleted = true
+		}
+	}
+}
+
+func (t *RGAText) Merge(other CRDT) error {
+	o, ok := other.(*RGAText)
+	if !ok {
+		return crdtTypeMismatch(CRDTRGAText, other)
+	}
+
+	index := make(map[RGAID]int, len(t.Nodes))
+	for i, node := range t.Nodes {
+		index[node.ID] = i
+	}
+	for _, node := range o.Nodes {
+		if node.ID.isHead() {
+			continue
+		}
+		if i, ok := index[node.ID]; ok {
+			t.Nodes[i].Deleted = t.Nodes[i].Deleted || node.Deleted
+			continue
+		}
+		index[node.ID] = len(t.Nodes)
+		t.Nodes = append(t.Nodes, node)
+	}
+	return nil
+}
+
+// ========== SHARED STORE ==========
+
+// crdtRecord returns the CRDT held by a key in CRDT mode
+func crdtRecord(record *sharedRecord, key string) (CRDT, error) {
+	if record.CRDT == "" {
+		return nil, fmt.Errorf("%w: %s is not in CRDT mode", ErrInvalidValue, key)
+	}
+	return DecodeCRDT(CRDTState{Type: record.CRDT, State: record.State})
+}
+
+// setCRDT stores a CRDT's state in a record along with its resolved value
+func setCRDT(record *sharedRecord, c CRDT) error {
+	state, err := EncodeCRDT(c)
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(c.Value())
+	if err != nil {
+		return err
+	}
+	record.CRDT, record.State, record.Value = state.Type, state.State, value
+	return nil
+}
+
+// CRDT returns the state of a key in CRDT mode along with the key
+func (s *SharedStore) CRDT(ctx context.Context, teamID, key string) (*SharedMemory, CRDTState, error) {
+	record, _, err := s.load(ctx, teamID, key)
+	if err != nil {
+		return nil, CRDTState{}, err
+	}
+	if record.CRDT == "" {
+		return nil, CRDTState{}, fmt.Errorf("%w: %s is not in CRDT mode", ErrInvalidValue, key)
+	}
+	return record.entry(teamID, key), CRDTState{Type: record.CRDT, State: record.State}, nil
+}
+
+// MergeCRDT merges a replica's CRDT state into a shared key, creating the
+// key in CRDT mode if it does not exist yet. The key's CRDT type is
+// recorded when it is created, and merges of another type are rejected.
+// Merges commute, so no version precondition is needed; the version only
+// advances when the state changes. The key's value is the CRDT's resolved
+// value.
+func (s *SharedStore) MergeCRDT(ctx context.Context, teamID, key, agentID string, state CRDTState) (*SharedMemory, CRDTState, error) {
+	incoming, err := DecodeCRDT(state)
+	if err != nil {
+		return nil, CRDTState{}, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		var merged CRDTState
+		entry, err := s.write(ctx, teamID, key, agentID, SharedPrecondition{}, func(updated *sharedRecord) error {
+			current, err := crdtRecord(updated, key)
+			if err != nil {
+				return err
+			}
+			if current.Type() != incoming.Type() {
+				return fmt.Errorf("%w: %s holds a %s, not a %s", ErrInvalidValue, key, current.Type(), incoming.Type())
+			}
+			if err := current.Merge(incoming); err != nil {
+				return err
+			}
+			previous := updated.State
+			if err := setCRDT(updated, current); err != nil {
+				return err
+			}
+			merged = CRDTState{Type: updated.CRDT, State: updated.State}
+			if bytes.Equal(previous, updated.State) {
+				return errUnchanged
+			}
+			return nil
+		})
+		if !errors.Is(err, ErrNotFound) {
+			return entry, merged, err
+		}
+
+		init := &sharedRecord{}
+		if err := setCRDT(init, incoming); err != nil {
+			return nil, CRDTState{}, err
+		}
+		entry, err = s.create(ctx, teamID, key, agentID, init)
+		if !errors.Is(err, ErrConflict) || attempt == sharedWriteAttempts {
+			return entry, CRDTState{Type: init.CRDT, State: init.State}, err
+		}
+		// Another replica created the key first; merge into its state
+	}
+}
@@ -0,0 +1,77 @@
+package memoryos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func counterState(t *testing.T, replica string, delta int64) CRDTState {
+	t.Helper()
+	counter := NewPNCounter()
+	counter.Increment(replica, delta)
+	state, err := EncodeCRDT(counter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func TestMergeCRDTStoresResolvedValue(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	store := newTestSharedStore(b)
+
+	if _, _, err := store.MergeCRDT(ctx, "team", "hits", "a", counterState(t, "r1", 3)); err != nil {
+		t.Fatal(err)
+	}
+	entry, state, err := store.MergeCRDT(ctx, "team", "hits", "b", counterState(t, "r2", 4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Type != CRDTPNCounter || entry.CRDT != CRDTPNCounter {
+		t.Fatalf("type %q, entry type %q", state.Type, entry.CRDT)
+	}
+
+	got, err := newTestSharedStore(b).Get(ctx, "team", "hits")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Content != "7" {
+		t.Fatalf("GET returned %s instead of the resolved value", got.Content)
+	}
+
+	// Merging the same state again changes nothing
+	again, _, err := store.MergeCRDT(ctx, "team", "hits", "b", counterState(t, "r2", 4))
+	if err != nil || again.Version != entry.Version {
+		t.Fatalf("idempotent merge moved version %d to %d: %v", entry.Version, again.Version, err)
+	}
+}
+
+func TestCRDTModeIsExplicit(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	store := newTestSharedStore(b)
+
+	// A plain value that happens to look like a CRDT envelope
+	lookalike := `{"type":"pn-counter","state":{"p":{"r1":5}}}`
+	if _, err := store.Create(ctx, "team", "plain", json.RawMessage(lookalike), "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Update(ctx, "team", "plain", json.RawMessage(lookalike), "a", SharedPrecondition{}); err != nil {
+		t.Fatalf("plain value treated as a CRDT: %v", err)
+	}
+	if _, _, err := store.MergeCRDT(ctx, "team", "plain", "a", counterState(t, "r1", 1)); !errors.Is(err, ErrInvalidValue) {
+		t.Fatalf("merge into a plain key: %v", err)
+	}
+
+	store.MergeCRDT(ctx, "team", "crdt", "a", counterState(t, "r1", 1))
+	if _, err := store.Update(ctx, "team", "crdt", json.RawMessage(`5`), "a", SharedPrecondition{}); !errors.Is(err, ErrInvalidValue) {
+		t.Fatalf("overwrite of a CRDT key: %v", err)
+	}
+	register, _ := EncodeCRDT(&LWWRegister{})
+	if _, _, err := store.MergeCRDT(ctx, "team", "crdt", "a", register); !errors.Is(err, ErrInvalidValue) {
+		t.Fatalf("merge of another CRDT type: %v", err)
+	}
+}
@@ -0,0 +1,454 @@
+package memoryos
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Metadata recorded on a memory flagged as a near-duplicate
+const (
+	MetaDuplicateOf    = "duplicate_of"
+	MetaDuplicateScore = "duplicate_score"
+)
+
+// DefaultDedupScanLimit is how many of an agent's memories a
+// near-duplicate check compares a new memory with, unless the policy sets
+// its own limit
+const DefaultDedupScanLimit = 1000
+
+// dedupImportanceBoost is added to a memory's importance each time it is
+// stored again
+const dedupImportanceBoost = 0.05
+
+// NearDuplicateAction selects what happens to a near-duplicate memory
+type NearDuplicateAction string
+
+const (
+	// NearDuplicateMerge folds the new memory's tags and metadata into the
+	// existing memory instead of storing it
+	NearDuplicateMerge NearDuplicateAction = "merge"
+	// NearDuplicateFlag stores the new memory with metadata naming the
+	// memory it resembles
+	NearDuplicateFlag NearDuplicateAction = "flag"
+)
+
+// DedupPolicy configures deduplication for one memory type
+type DedupPolicy struct {
+	// Exact turns a store of content already held by the agent, after
+	// normalizing case and whitespace, into a bump of the existing memory
+	Exact bool `json:"exact,omitempty"`
+	// Threshold is the similarity from 0 to 1 at which a memory counts as
+	// a near-duplicate; zero disables near-duplicate detection
+	Threshold float64 `json:"threshold,omitempty"`
+	// NearDuplicates is the action for near-duplicates, merge by default
+	NearDuplicates NearDuplicateAction `json:"near_duplicates,omitempty"`
+	// ScanLimit bounds how many of the agent's memories each store
+	// compares with when looking for near-duplicates, DefaultDedupScanLimit
+	// if zero. Every store with a threshold pays for this scan, and an
+	// agent holding more memories than the limit only has the ones the
+	// backend lists first compared, so older near-duplicates can be missed.
+	ScanLimit int `json:"scan_limit,omitempty"`
+}
+
+// scanLimit is the number of memories a near-duplicate check compares with
+func (p DedupPolicy) scanLimit() int {
+	if p.ScanLimit > 0 {
+		return p.ScanLimit
+	}
+	return DefaultDedupScanLimit
+}
+
+func (p DedupPolicy) enabled() bool {
+	return p.Exact || p.Threshold > 0
+}
+
+// DedupConfig holds the default policy and per-type overrides. The zero
+// value stores every memory as given.
+type DedupConfig struct {
+	Default DedupPolicy                `json:"default"`
+	Types   map[MemoryType]DedupPolicy `json:"types,omitempty"`
+}
+
+// Validate checks every policy's threshold and action
+func (c DedupConfig) Validate() error {
+	policies := map[MemoryType]DedupPolicy{"default": c.Default}
+	for t, policy := range c.Types {
+		policies[t] = policy
+	}
+	for t, policy := range policies {
+		if policy.Threshold < 0 || policy.Threshold > 1 {
+			return errorf(ErrInvalid, "dedup %s: threshold must be between 0.0 and 1.0", t)
+		}
+		if policy.ScanLimit < 0 || policy.ScanLimit > queryScanLimit {
+			return errorf(ErrInvalid, "dedup %s: scan_limit must be between 0 and %d", t, queryScanLimit)
+		}
+		switch policy.NearDuplicates {
+		case "", NearDuplicateMerge, NearDuplicateFlag:
+		default:
+			return errorf(ErrInvalid, "dedup %s: unknown near-duplicate action %q", t, policy.NearDuplicates)
+		}
+	}
+	return nil
+}
+
+// Policy returns the policy for a memory type
+func (c DedupConfig) Policy(memoryType MemoryType) DedupPolicy {
+	if policy, ok := c.Types[memoryType]; ok {
+		return policy
+	}
+	return c.Default
+}
+
+const (
+	// dedupPendingPrefix marks an index entry claimed by a store that has
+	// not inserted its memory yet
+	dedupPendingPrefix = "pending:"
+	// dedupClaimLease bounds how long a pending entry holds the content
+	// when its store never finishes
+	dedupClaimLease = 30 * time.Second
+	// dedupClaimWait and dedupClaimAttempts bound how long a store waits
+	// for another store of the same content
+	dedupClaimWait     = 50 * time.Millisecond
+	dedupClaimAttempts = 20
+)
+
+// Deduplicator wraps a MemoryBackend and folds duplicate stores into the
+// memories the agent already has. Exact duplicates are found through an
+// index in the shared state from normalized content to memory ID, which a
+// store claims before inserting, so two copies stored at once on any
+// instance cannot both be inserted. Near-duplicates are found by scanning
+// the agent's memories.
+type Deduplicator struct {
+	MemoryBackend
+	mu     sync.Mutex
+	state  StateBackend
+	config DedupConfig
+	now    func() time.Time
+}
+
+// NewDeduplicator creates a deduplicator that keeps its index in state,
+// with deduplication off
+func NewDeduplicator(backend MemoryBackend, state StateBackend) *Deduplicator {
+	return &Deduplicator{MemoryBackend: backend, state: state, now: time.Now}
+}
+
+// dedupKey is the index entry for an agent's memories of one type with
+// the given content hash
+func dedupKey(agentID string, memoryType MemoryType, hash string) string {
+	return "dedup:" + agentID + ":" + string(memoryType) + ":" + hash
+}
+
+// SetConfig replaces the deduplication policies
+func (d *Deduplicator) SetConfig(config DedupConfig) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.config = config
+}
+
+// Config returns the current policies
+func (d *Deduplicator) Config() DedupConfig {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.config
+}
+
+// StoreMemory stores a memory unless it duplicates one of the agent's
+// memories of the same type. On a duplicate the existing memory is updated
+// and copied into memory, so the caller sees its ID.
+func (d *Deduplicator) StoreMemory(ctx context.Context, memory *Memory) error {
+	_, err := d.store(ctx, memory)
+	return err
+}
+
+// StoreMemories deduplicates each memory of a batch against the agent's
+// memories and the ones stored earlier in the batch. An atomic batch that
+// fails undoes the inserts and updates made before the failure; a memory
+// that could not be undone keeps the reason.
+func (d *Deduplicator) StoreMemories(ctx context.Context, memories []*Memory, opts BatchOptions) []error {
+	config := d.Config()
+	enabled := false
+	for _, memory := range memories {
+		enabled = enabled || config.Policy(memory.Type).enabled()
+	}
+	if !enabled {
+		return storeMemories(ctx, d.MemoryBackend, memories, opts)
+	}
+
+	errs := make([]error, len(memories))
+	undo := make([]func(context.Context) error, len(memories))
+	for i, memory := range memories {
+		revert, err := d.store(ctx, memory)
+		if errs[i] = err; err == nil {
+			undo[i] = revert
+			continue
+		}
+		if opts.Atomic {
+			cleanup := context.WithoutCancel(ctx)
+			for j := i - 1; j >= 0; j-- {
+				if err := undo[j](cleanup); err != nil {
+					errs[j] = fmt.Errorf("batch aborted but memory %s could not be undone: %w", memories[j].ID, err)
+				}
+			}
+			return abortBatch(errs)
+		}
+	}
+	return errs
+}
+
+// DeleteMemory deletes a memory and its index entry. An entry left behind
+// is dropped by the next store that finds it stale.
+func (d *Deduplicator) DeleteMemory(ctx context.Context, agentID string, memoryType MemoryType, id string) error {
+	existing, err := d.MemoryBackend.GetMemory(ctx, agentID, memoryType, id)
+	if err != nil {
+		return err
+	}
+	if err := d.MemoryBackend.DeleteMemory(ctx, agentID, memoryType, id); err != nil {
+		return err
+	}
+	d.state.CompareAndDelete(ctx, dedupKey(existing.AgentID, existing.Type, contentHash(existing.Content)), id)
+	return nil
+}
+
+// UpdateMemory updates a memory and moves its index entry when its content
+// or type changes
+func (d *Deduplicator) UpdateMemory(ctx context.Context, memory *Memory) error {
+	existing, err := d.MemoryBackend.GetMemory(ctx, memory.AgentID, memory.Type, memory.ID)
+	if err != nil {
+		return err
+	}
+	if err := d.MemoryBackend.UpdateMemory(ctx, memory); err != nil {
+		return err
+	}
+	oldKey := dedupKey(existing.AgentID, existing.Type, contentHash(existing.Content))
+	if newKey := dedupKey(memory.AgentID, memory.Type, contentHash(memory.Content)); newKey != oldKey {
+		d.state.CompareAndDelete(ctx, oldKey, memory.ID)
+		d.state.CompareAndSwap(ctx, newKey, "", memory.ID, 0)
+	}
+	return nil
+}
+
+// store stores or folds one memory and returns a function that undoes it
+func (d *Deduplicator) store(ctx context.Context, memory *Memory) (func(context.Context) error, error) {
+	policy := d.Config().Policy(memory.Type)
+	if memory.ID == "" {
+		memory.ID = uuid.New().String()
+	}
+	key := dedupKey(memory.AgentID, memory.Type, contentHash(memory.Content))
+	if !policy.Exact {
+		return d.storeNear(ctx, memory, policy, key, "")
+	}
+
+	pending := dedupPendingPrefix + memory.ID
+	for attempt := 0; ; attempt++ {
+		claimed, err := d.state.CompareAndSwap(ctx, key, "", pending, dedupClaimLease)
+		if err != nil {
+			return nil, err
+		}
+		if claimed {
+			break
+		}
+		existing, err := d.indexed(ctx, key, memory)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			return d.bump(ctx, existing, memory, false)
+		}
+		if attempt == dedupClaimAttempts {
+			return nil, errorf(ErrConflict, "the same content is being stored by another request")
+		}
+	}
+	return d.storeNear(ctx, memory, policy, key, pending)
+}
+
+// indexed returns the memory the index entry at key points to. It returns
+// nil after waiting for a pending entry or after dropping a stale one, and
+// the caller claims the entry again.
+func (d *Deduplicator) indexed(ctx context.Context, key string, memory *Memory) (*Memory, error) {
+	id, err := d.state.Get(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(id, dedupPendingPrefix) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(dedupClaimWait):
+			return nil, nil
+		}
+	}
+
+	existing, err := d.MemoryBackend.GetMemory(ctx, memory.AgentID, memory.Type, id)
+	if err == nil && contentHash(existing.Content) == contentHash(memory.Content) {
+		return existing, nil
+	}
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	// The memory was deleted or rewritten without going through the index
+	_, err = d.state.CompareAndDelete(ctx, key, id)
+	return nil, err
+}
+
+// storeNear stores a memory that has no exact duplicate, merging or
+// flagging it when it resembles one. pending is the claim on the index
+// entry at key, or empty when exact deduplication is off; the entry is
+// pointed at the stored memory or released.
+func (d *Deduplicator) storeNear(ctx context.Context, memory *Memory, policy DedupPolicy, key, pending string) (func(context.Context) error, error) {
+	release := func() {
+		if pending != "" {
+			d.state.CompareAndDelete(context.WithoutCancel(ctx), key, pending)
+		}
+	}
+
+	var nearest *Memory
+	best := 0.0
+	if policy.Threshold > 0 {
+		existing, err := d.MemoryBackend.SearchMemories(ctx, memory.AgentID, "", policy.scanLimit())
+		if err != nil {
+			release()
+			return nil, err
+		}
+		for _, candidate := range existing {
+			if candidate.Type != memory.Type || candidate.ID == memory.ID {
+				continue
+			}
+			if score := similarity(candidate, memory); score >= policy.Threshold && score > best {
+				nearest, best = candidate, score
+			}
+		}
+	}
+
+	if nearest != nil && policy.NearDuplicates != NearDuplicateFlag {
+		release()
+		return d.bump(ctx, nearest, memory, true)
+	}
+	if nearest != nil {
+		if memory.Metadata == nil {
+			memory.Metadata = make(map[string]interface{})
+		}
+		memory.Metadata[MetaDuplicateOf] = nearest.ID
+		memory.Metadata[MetaDuplicateScore] = math.Round(best*1000) / 1000
+	}
+
+	if err := d.MemoryBackend.StoreMemory(ctx, memory); err != nil {
+		release()
+		return nil, err
+	}
+	// The index is also kept while exact deduplication is off, so turning
+	// it on later finds these memories. A pending entry that cannot be
+	// pointed at the memory expires with its lease.
+	d.state.CompareAndSwap(ctx, key, pending, memory.ID, 0)
+	return func(ctx context.Context) error {
+		return d.DeleteMemory(ctx, memory.AgentID, memory.Type, memory.ID)
+	}, nil
+}
+
+// bump records another store of an existing memory: its access count and
+// importance go up, and with merge the new tags and metadata are added,
+// new metadata values replacing old ones. Undoing it restores the earlier
+// memory only if nothing has changed it since.
+func (d *Deduplicator) bump(ctx context.Context, existing, memory *Memory, merge bool) (func(context.Context) error, error) {
+	previous := *existing
+	updated := *existing
+
+	now := d.now()
+	updated.AccessCount++
+	updated.AccessedAt = now
+	updated.UpdatedAt = now
+	updated.Importance = math.Round(math.Min(1, math.Max(existing.Importance, memory.Importance)+dedupImportanceBoost)*1000) / 1000
+
+	if merge {
+		updated.Tags = append([]string(nil), existing.Tags...)
+		for _, tag := range memory.Tags {
+			if !containsString(updated.Tags, tag) {
+				updated.Tags = append(updated.Tags, tag)
+			}
+		}
+		if len(memory.Metadata) > 0 {
+			updated.Metadata = make(map[string]interface{}, len(existing.Metadata)+len(memory.Metadata))
+			for k, v := range existing.Metadata {
+				updated.Metadata[k] = v
+			}
+			for k, v := range memory.Metadata {
+				updated.Metadata[k] = v
+			}
+		}
+	}
+
+	if err := d.MemoryBackend.UpdateMemory(ctx, &updated); err != nil {
+		return nil, err
+	}
+	*memory = updated
+	return func(ctx context.Context) error {
+		current, err := d.MemoryBackend.GetMemory(ctx, updated.AgentID, updated.Type, updated.ID)
+		if err != nil {
+			return err
+		}
+		if current.AccessCount != updated.AccessCount || !current.UpdatedAt.Equal(updated.UpdatedAt) {
+			return errorf(ErrConflict, "memory %s changed since it was updated", updated.ID)
+		}
+		return d.MemoryBackend.UpdateMemory(ctx, &previous)
+	}, nil
+}
+
+// contentHash hashes content with case and runs of whitespace normalized
+func contentHash(content string) string {
+	normalized := strings.Join(strings.Fields(strings.ToLower(content)), " ")
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// similarity scores two memories from 0 to 1: the cosine similarity of
+// their embeddings when both have embeddings of the same size, otherwise
+// the Jaccard similarity of their words
+func similarity(a, b *Memory) float64 {
+	if len(a.Embeddings) > 0 && len(a.Embeddings) == len(b.Embeddings) {
+		return cosineSimilarity(a.Embeddings, b.Embeddings)
+	}
+
+	words := func(content string) map[string]bool {
+		set := make(map[string]bool)
+		for _, word := range strings.Fields(strings.ToLower(content)) {
+			set[strings.Trim(word, ".,;:!?\"'()[]{}")] = true
+		}
+		delete(set, "")
+		return set
+	}
+	wa, wb := words(a.Content), words(b.Content)
+	if len(wa) == 0 || len(wb) == 0 {
+		return 0
+	}
+	shared := 0
+	for word := range wa {
+		if wb[word] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(wa)+len(wb)-shared)
+}
+
+func cosineSimilarity(a, b []float64) float64 {
+	var dot, na, nb float64
+	for i := range a {
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
@@ -0,0 +1,153 @@
+package memoryos
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func newTestDeduplicator(b *testBackends) *Deduplicator {
+	d := NewDeduplicator(coreMemory{b.memory}, b.state)
+	d.SetConfig(DedupConfig{Default: DedupPolicy{Exact: true}})
+	return d
+}
+
+func TestDedupExactAcrossInstances(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	first, second := newTestDeduplicator(b), newTestDeduplicator(b)
+
+	var wg sync.WaitGroup
+	memories := make([]*Memory, 8)
+	for i := range memories {
+		memories[i] = &Memory{AgentID: "a", Type: MemoryTypeEpisodic, Content: "Hello   World"}
+		d := first
+		if i%2 == 1 {
+			d = second
+		}
+		wg.Add(1)
+		go func(d *Deduplicator, memory *Memory) {
+			defer wg.Done()
+			if err := d.StoreMemory(ctx, memory); err != nil {
+				t.Error(err)
+			}
+		}(d, memories[i])
+	}
+	wg.Wait()
+
+	all, _ := b.memory.SearchMemories(ctx, "a", "", 0)
+	if len(all) != 1 {
+		t.Fatalf("%d memories stored", len(all))
+	}
+	if all[0].AccessCount != len(memories)-1 {
+		t.Fatalf("access count %d", all[0].AccessCount)
+	}
+	for _, memory := range memories {
+		if memory.ID != all[0].ID {
+			t.Fatalf("store returned %s, want %s", memory.ID, all[0].ID)
+		}
+	}
+}
+
+func TestDedupIndexFollowsUpdatesAndDeletes(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	d := newTestDeduplicator(b)
+	count := func() int {
+		all, _ := b.memory.SearchMemories(ctx, "a", "", 0)
+		return len(all)
+	}
+
+	memory := &Memory{AgentID: "a", Type: MemoryTypeEpisodic, Content: "first"}
+	if err := d.StoreMemory(ctx, memory); err != nil {
+		t.Fatal(err)
+	}
+	updated := *memory
+	updated.Content = "second"
+	if err := d.UpdateMemory(ctx, &updated); err != nil {
+		t.Fatal(err)
+	}
+
+	d.StoreMemory(ctx, &Memory{AgentID: "a", Type: MemoryTypeEpisodic, Content: "second"})
+	if count() != 1 {
+		t.Fatal("updated content not found in the index")
+	}
+	d.StoreMemory(ctx, &Memory{AgentID: "a", Type: MemoryTypeEpisodic, Content: "first"})
+	if count() != 2 {
+		t.Fatal("old content still indexed after the update")
+	}
+
+	if err := d.DeleteMemory(ctx, "a", MemoryTypeEpisodic, memory.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.state.Get(ctx, dedupKey("a", MemoryTypeEpisodic, contentHash("second"))); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("deleted memory still indexed: %v", err)
+	}
+
+	// An entry for a memory removed behind the index's back is dropped
+	b.state.CompareAndSwap(ctx, dedupKey("a", MemoryTypeEpisodic, contentHash("third")), "", "gone", 0)
+	third := &Memory{AgentID: "a", Type: MemoryTypeEpisodic, Content: "third"}
+	if err := d.StoreMemory(ctx, third); err != nil || third.ID == "gone" || count() != 2 {
+		t.Fatalf("stale entry: %v, id %s, %d memories", err, third.ID, count())
+	}
+}
+
+func TestDedupUndoKeepsLaterChanges(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	d := newTestDeduplicator(b)
+	d.StoreMemory(ctx, &Memory{ID: "m", AgentID: "a", Type: MemoryTypeEpisodic, Content: "same"})
+
+	revert, err := d.store(ctx, &Memory{AgentID: "a", Type: MemoryTypeEpisodic, Content: "same"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Another store bumps the memory again before the undo
+	if err := d.StoreMemory(ctx, &Memory{AgentID: "a", Type: MemoryTypeEpisodic, Content: "same"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := revert(ctx); !errors.Is(err, ErrConflict) {
+		t.Fatalf("undo over a later change: %v", err)
+	}
+	if memory, _ := b.memory.GetMemory(ctx, "a", MemoryTypeEpisodic, "m"); memory.AccessCount != 2 {
+		t.Fatalf("access count %d after a refused undo", memory.AccessCount)
+	}
+}
+
+func TestDedupNearScanIsBounded(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	d := NewDeduplicator(coreMemory{b.memory}, b.state)
+	if err := (DedupConfig{Default: DedupPolicy{Threshold: 0.5, ScanLimit: -1}}).Validate(); err == nil {
+		t.Fatal("negative scan limit accepted")
+	}
+	d.SetConfig(DedupConfig{Default: DedupPolicy{Threshold: 0.9, ScanLimit: 2}})
+
+	// The near-duplicate sorts after the two memories the scan covers
+	for _, m := range []*Memory{
+		{ID: "a", Content: "alpha"},
+		{ID: "b", Content: "beta"},
+		{ID: "c", Content: "the quick brown fox"},
+	} {
+		m.AgentID, m.Type = "agent", MemoryTypeSemantic
+		b.memory.StoreMemory(ctx, m)
+	}
+	memory := &Memory{ID: "d", AgentID: "agent", Type: MemoryTypeSemantic, Content: "the quick brown fox"}
+	if err := d.StoreMemory(ctx, memory); err != nil {
+		t.Fatal(err)
+	}
+	if all, _ := b.memory.SearchMemories(ctx, "agent", "", 0); len(all) != 4 {
+		t.Fatalf("%d memories: a memory past the scan limit was merged", len(all))
+	}
+
+	// Within the default limit it is found
+	d.SetConfig(DedupConfig{Default: DedupPolicy{Threshold: 0.9}})
+	again := &Memory{ID: "e", AgentID: "agent", Type: MemoryTypeSemantic, Content: "the quick brown fox"}
+	if err := d.StoreMemory(ctx, again); err != nil {
+		t.Fatal(err)
+	}
+	if all, _ := b.memory.SearchMemories(ctx, "agent", "", 0); len(all) != 4 {
+		t.Fatalf("%d memories: the near-duplicate was not merged", len(all))
+	}
+}
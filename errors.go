@@ -0,0 +1,223 @@
+package memoryos
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Kinds of error returned across the package. Errors are matched with
+// errors.Is and mapped onto HTTP status codes; ErrForbidden and
+// ErrQuotaExceeded are declared with the authorizer and quotas.
+var (
+	ErrNotFound = errors.New("not found")
+	ErrConflict = errors.New("conflict")
+	ErrInvalid  = errors.New("invalid")
+)
+
+// RequestIDHeader carries the ID of a request. Clients may send their own;
+// otherwise the server assigns one. It is echoed on every response and in
+// error bodies.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds client-supplied request IDs
+const maxRequestIDLength = 128
+
+// errorKinds maps each kind of error onto its HTTP status and the code
+// reported in error bodies. The first match wins.
+var errorKinds = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{ErrUnauthenticated, http.StatusUnauthorized, "unauthenticated"},
+	{ErrBatchAborted, http.StatusFailedDependency, "batch_aborted"},
+	{ErrForbidden, http.StatusForbidden, "forbidden"},
+	{ErrNotFound, http.StatusNotFound, "not_found"},
+	{ErrConflict, http.StatusConflict, "conflict"},
+	{ErrIdempotencyKeyReused, http.StatusUnprocessableEntity, "idempotency_key_reused"},
+	{ErrOffsetExpired, http.StatusGone, "offset_expired"},
+	{ErrTooLarge, http.StatusRequestEntityTooLarge, "too_large"},
+	{ErrLocked, http.StatusLocked, "locked"},
+	{ErrQuotaExceeded, http.StatusTooManyRequests, "quota_exceeded"},
+	{ErrRateLimited, http.StatusTooManyRequests, "rate_limited"},
+	{ErrInvalid, http.StatusBadRequest, "invalid"},
+}
+
+// statusCodes names errors that only carry an HTTP status
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            "invalid",
+	http.StatusUnauthorized:          "unauthenticated",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusConflict:              "conflict",
+	http.StatusGone:                  "gone",
+	http.StatusRequestEntityTooLarge: "too_large",
+	http.StatusLocked:                "locked",
+	http.StatusFailedDependency:      "failed_dependency",
+	http.StatusTooManyRequests:       "too_many_requests",
+	http.StatusInternalServerError:   "internal",
+}
+
+// kindError is an error of one of the kinds above with its own message
+type kindError struct {
+	kind  error
+	msg   string
+	cause error
+}
+
+func (e *kindError) Error() string {
+	return e.msg
+}
+
+func (e *kindError) Unwrap() []error {
+	if e.cause == nil {
+		return []error{e.kind}
+	}
+	return []error{e.kind, e.cause}
+}
+
+// newError returns a sentinel error that also matches kind
+func newError(kind error, msg string) error {
+	return &kindError{kind: kind, msg: msg}
+}
+
+// errorf formats an error of the given kind. %w verbs wrap as usual.
+func errorf(kind error, format string, args ...interface{}) error {
+	err := fmt.Errorf(format, args...)
+	return &kindError{kind: kind, msg: err.Error(), cause: err}
+}
+
+// APIError is the JSON body of every error response. Clients decode it
+// with DecodeAPIError and can match it against the package's errors with
+// errors.Is.
+type APIError struct {
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+	Status    int         `json:"-"`
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// Is reports whether the error has the code of target, so that
+// errors.Is(err, ErrNotFound) works on decoded responses
+func (e *APIError) Is(target error) bool {
+	for _, kind := range errorKinds {
+		if kind.err == target {
+			return kind.code == e.Code
+		}
+	}
+	return false
+}
+
+// detailedError is implemented by errors that carry structured details for
+// the error body
+type detailedError interface {
+	ErrorDetails() interface{}
+}
+
+// errorStatus returns the status and code for err, or fallback when err is
+// of no known kind
+func errorStatus(err error, fallback int) (int, string) {
+	for _, kind := range errorKinds {
+		if errors.Is(err, kind.err) {
+			return kind.status, kind.code
+		}
+	}
+	return fallback, statusCode(fallback)
+}
+
+func statusCode(status int) string {
+	if code, ok := statusCodes[status]; ok {
+		return code
+	}
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+// writeError writes err as a JSON error body. The status follows the
+// error's kind; fallback is used for errors of no known kind.
+func writeError(w http.ResponseWriter, err error, fallback int) {
+	status, body := apiError(err, fallback)
+	writeAPIError(w, status, body)
+}
+
+// apiError builds the error body for err and returns it with its status
+func apiError(err error, fallback int) (int, *APIError) {
+	status, code := errorStatus(err, fallback)
+	body := &APIError{Code: code, Message: err.Error(), Status: status}
+	var detailed detailedError
+	if errors.As(err, &detailed) {
+		body.Details = detailed.ErrorDetails()
+	}
+	return status, body
+}
+
+// httpError writes a JSON error body with an explicit status. It replaces
+// http.Error for errors the handler classifies itself.
+func httpError(w http.ResponseWriter, message string, status int) {
+	writeAPIError(w, status, &APIError{Code: statusCode(status), Message: message})
+}
+
+func writeAPIError(w http.ResponseWriter, status int, body *APIError) {
+	body.RequestID = w.Header().Get(RequestIDHeader)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// DecodeAPIError reads the error body of a failed response. Bodies that
+// are not an error envelope become the message of an error coded by the
+// response status.
+func DecodeAPIError(resp *http.Response) error {
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	apiErr := &APIError{}
+	if err := json.Unmarshal(data, apiErr); err != nil || apiErr.Code == "" {
+		apiErr = &APIError{Code: statusCode(resp.StatusCode), Message: strings.TrimSpace(string(data))}
+	}
+	apiErr.Status = resp.StatusCode
+	if apiErr.RequestID == "" {
+		apiErr.RequestID = resp.Header.Get(RequestIDHeader)
+	}
+	return apiErr
+}
+
+// withRequestID assigns every request an ID, keeping a well-formed one
+// sent by the client, and echoes it on the response
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.New().String()
+			r.Header.Set(RequestIDHeader, id)
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,185 @@
+package memoryos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrOffsetExpired is returned when a subscriber asks to resume from an
+// offset that has already been evicted from the feed
+var ErrOffsetExpired = errors.New("offset expired")
+
+// DefaultFeedRetention is how many events the feed keeps per namespace
+const DefaultFeedRetention = 1000
+
+// subscriberBuffer is how many undelivered events a subscriber may queue
+// before it is dropped and has to resume from its last offset
+const subscriberBuffer = 64
+
+// ChangeType identifies what happened to a shared key
+type ChangeType string
+
+const (
+	ChangeCreate ChangeType = "create"
+	ChangeUpdate ChangeType = "update"
+	ChangeDelete ChangeType = "delete"
+)
+
+// ChangeEvent describes a single change to a team's shared memory
+type ChangeEvent struct {
+	Offset    uint64          `json:"offset"`
+	TeamID    string          `json:"team_id"`
+	Key       string          `json:"key"`
+	Type      ChangeType      `json:"type"`
+	Version   int             `json:"version"`
+	AgentID   string          `json:"agent_id"`
+	Value     json.RawMessage `json:"value,omitempty"`
+	Timestamp time.Time       `json:"timestamp"`
+}
+
+// feedPollInterval is how often a subscription checks the feed for events
+// written by any server instance
+const feedPollInterval = 100 * time.Millisecond
+
+// ChangeFeed fans out shared memory changes to subscribers. Each namespace
+// has its own monotonically increasing offsets and a bounded backlog so
+// that reconnecting agents can resume where they left off. Events are
+// kept as a stream in the StateBackend, so offsets survive restarts and
+// are the same on every server instance; subscriptions poll the stream.
+type ChangeFeed struct {
+	state  StateBackend
+	retain int
+}
+
+// Subscription receives live events for one namespace until closed
+type Subscription struct {
+	C <-chan ChangeEvent
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewChangeFeed creates a new change feed that retains the given number of
+// events per namespace
+func NewChangeFeed(state StateBackend, retain int) *ChangeFeed {
+	if retain <= 0 {
+		retain = DefaultFeedRetention
+	}
+	return &ChangeFeed{state: state, retain: retain}
+}
+
+func feedKey(namespace string) string {
+	return "feed:" + namespace
+}
+
+// Publish assigns the event its offset and appends it to the feed
+func (f *ChangeFeed) Publish(ctx context.Context, event ChangeEvent) (ChangeEvent, error) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return event, err
+	}
+	offset, err := f.state.Append(ctx, feedKey(event.TeamID), string(data), f.retain)
+	if err != nil {
+		return event, err
+	}
+	event.Offset = offset
+	return event, nil
+}
+
+// read returns up to count events after the given offset
+func (f *ChangeFeed) read(ctx context.Context, teamID string, after uint64, count int) ([]ChangeEvent, error) {
+	entries, err := f.state.ReadAfter(ctx, feedKey(teamID), after, count)
+	if err != nil {
+		return nil, err
+	}
+	events := make([]ChangeEvent, 0, len(entries))
+	for _, entry := range entries {
+		var event ChangeEvent
+		if err := json.Unmarshal([]byte(entry.Value), &event); err != nil {
+			return nil, fmt.Errorf("feed event %d: %w", entry.Offset, err)
+		}
+		event.Offset = entry.Offset
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// Subscribe registers for a namespace's events and returns the backlog of
+// events after the given offset. Pass 0 to receive only new events. An
+// offset the feed has not reached yet is rejected with ErrOffsetExpired
+// like an evicted one. The subscription ends with ctx or Close; a
+// subscriber that falls subscriberBuffer events behind is dropped and has
+// to resume from its last offset.
+func (f *ChangeFeed) Subscribe(ctx context.Context, teamID string, after uint64) (*Subscription, []ChangeEvent, error) {
+	first, last, err := f.state.StreamBounds(ctx, feedKey(teamID))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var backlog []ChangeEvent
+	if after > 0 {
+		if after > last {
+			return nil, nil, fmt.Errorf("%w: offset %d is ahead of the feed, whose next offset is %d; resubscribe from 0", ErrOffsetExpired, after, last+1)
+		}
+		if first == 0 {
+			first = last + 1
+		}
+		if after+1 < first {
+			return nil, nil, fmt.Errorf("%w: oldest retained offset is %d", ErrOffsetExpired, first)
+		}
+		if backlog, err = f.read(ctx, teamID, after, f.retain); err != nil {
+			return nil, nil, err
+		}
+		if len(backlog) > 0 {
+			last = backlog[len(backlog)-1].Offset
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	ch := make(chan ChangeEvent, subscriberBuffer)
+	sub := &Subscription{C: ch, cancel: cancel, done: make(chan struct{})}
+	go f.poll(ctx, teamID, last, ch, sub.done)
+	return sub, backlog, nil
+}
+
+// poll delivers the events after offset until ctx ends, the subscriber
+// falls behind or the feed cannot be read
+func (f *ChangeFeed) poll(ctx context.Context, teamID string, offset uint64, ch chan<- ChangeEvent, done chan<- struct{}) {
+	defer close(done)
+	defer close(ch)
+
+	ticker := time.NewTicker(feedPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		events, err := f.read(ctx, teamID, offset, subscriberBuffer)
+		if err != nil {
+			return
+		}
+		for _, event := range events {
+			select {
+			case ch <- event:
+				offset = event.Offset
+			default:
+				return
+			}
+		}
+	}
+}
+
+// Close stops delivery and closes the subscription channel
+func (s *Subscription) Close() {
+	s.cancel()
+	<-s.done
+}
@@ -0,0 +1,125 @@
+package memoryos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChangeFeedRejectsOffsetAheadOfHead(t *testing.T) {
+	ctx := context.Background()
+	feed := NewChangeFeed(NewLocalState(), 10)
+	feed.Publish(ctx, ChangeEvent{TeamID: "team", Key: "k"})
+	feed.Publish(ctx, ChangeEvent{TeamID: "team", Key: "k"})
+
+	if _, _, err := feed.Subscribe(ctx, "team", 7); !errors.Is(err, ErrOffsetExpired) {
+		t.Fatalf("offset ahead of the feed: %v", err)
+	}
+
+	sub, backlog, err := feed.Subscribe(ctx, "team", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	if len(backlog) != 1 || backlog[0].Offset != 2 {
+		t.Fatalf("backlog = %+v", backlog)
+	}
+}
+
+func TestChangeFeedExpiredOffset(t *testing.T) {
+	ctx := context.Background()
+	feed := NewChangeFeed(NewLocalState(), 2)
+	for i := 0; i < 5; i++ {
+		feed.Publish(ctx, ChangeEvent{TeamID: "team", Key: "k"})
+	}
+	if _, _, err := feed.Subscribe(ctx, "team", 1); !errors.Is(err, ErrOffsetExpired) {
+		t.Fatalf("evicted offset: %v", err)
+	}
+	if _, _, err := feed.Subscribe(ctx, "team", 3); err != nil {
+		t.Fatalf("oldest retained offset: %v", err)
+	}
+}
+
+func TestChangeFeedIsSharedAcrossInstances(t *testing.T) {
+	ctx := context.Background()
+	state, _ := newTestRedisState(t)
+	// A publisher, and a subscriber on another instance that started after
+	// the first events were written
+	one := NewChangeFeed(prefixState(state, stateKeyPrefix), 10)
+	one.Publish(ctx, ChangeEvent{TeamID: "team", Key: "a"})
+	one.Publish(ctx, ChangeEvent{TeamID: "team", Key: "b"})
+	two := NewChangeFeed(prefixState(state, stateKeyPrefix), 10)
+
+	sub, backlog, err := two.Subscribe(ctx, "team", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	if len(backlog) != 1 || backlog[0].Offset != 2 || backlog[0].Key != "b" {
+		t.Fatalf("backlog = %+v", backlog)
+	}
+
+	published, err := one.Publish(ctx, ChangeEvent{TeamID: "team", Key: "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case event := <-sub.C:
+		if event.Offset != published.Offset || event.Key != "c" {
+			t.Fatalf("event = %+v", event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("event published on another instance was not delivered")
+	}
+}
+
+func TestSharedEventsFilterByKeyACL(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	s := newTestServer(t, b)
+	registerTestAgent(t, s, "owner")
+	registerTestAgent(t, s, "member")
+	s.roster.Create(ctx, &Team{ID: "team", Name: "team"}, "owner")
+	s.roster.AddMember(ctx, "team", "member", TeamRoleWriter)
+
+	s.shared.Create(ctx, "team", "public", json.RawMessage(`1`), "owner")
+	s.shared.Create(ctx, "team", "secret", json.RawMessage(`"hidden"`), "owner")
+	s.shared.SetACL(ctx, "team", "secret", map[string]string{"member": PermissionNone})
+	s.shared.Update(ctx, "team", "public", json.RawMessage(`2`), "owner", SharedPrecondition{})
+
+	reqCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/shared/events?team_id=team&after=1", nil).WithContext(reqCtx)
+	req.Header.Set(AgentIDHeader, "member")
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+
+	body := rec.Body.String()
+	if strings.Contains(body, "hidden") || strings.Contains(body, `"key":"secret"`) {
+		t.Fatalf("stream leaked a key the caller may not read:\n%s", body)
+	}
+	if !strings.Contains(body, `"key":"public"`) {
+		t.Fatalf("stream is missing a readable key:\n%s", body)
+	}
+}
+
+func TestSharedEventsRejectsBadCursor(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServer(t, newTestBackends())
+	registerTestAgent(t, s, "owner")
+	s.roster.Create(ctx, &Team{ID: "team", Name: "team"}, "owner")
+
+	rec := serve(t, s, http.MethodGet, "/shared/events?team_id=team&after=x1", "owner", "")
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	rec = serve(t, s, http.MethodGet, "/shared/events?team_id=team&after=99", "owner", "")
+	if rec.Code != http.StatusGone {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+}
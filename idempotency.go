@@ -0,0 +1,408 @@
+package memoryos
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// IdempotencyKeyHeader lets clients retry a write safely. The first
+// response for a key is remembered and returned again for retries.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotentReplayHeader marks a response replayed for a retried key
+const IdempotentReplayHeader = "Idempotent-Replayed"
+
+// DefaultIdempotencyWindow is how long idempotency keys are remembered
+const DefaultIdempotencyWindow = 24 * time.Hour
+
+const (
+	// maxIdempotencyKeyLength bounds client-supplied keys
+	maxIdempotencyKeyLength = 255
+	// maxIdempotentBody bounds the request body read to fingerprint a
+	// request; a full batch at the default validation limits fits
+	maxIdempotentBody = 64 << 20
+	// idempotencyLease is how long a key stays claimed by a request that
+	// has not finished. A request running longer loses its claim.
+	idempotencyLease = 5 * time.Minute
+	// maxRecordedBody bounds the response body kept for replay; larger
+	// responses are not remembered
+	maxRecordedBody = 1 << 20
+)
+
+// ErrIdempotencyKeyReused is returned when a key is sent again with a
+// different request
+var ErrIdempotencyKeyReused = errors.New("idempotency key reused with a different request")
+
+type idempotencyKey struct{}
+
+// WithIdempotencyKey returns a context whose memory stores through an
+// IdempotentMemory happen once per key
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKey{}, key)
+}
+
+// IdempotencyKeyFromContext returns the key set by WithIdempotencyKey
+func IdempotencyKeyFromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(idempotencyKey{}).(string)
+	return key, ok && key != ""
+}
+
+// idempotencyRecord is what the state holds for a key: a pending claim
+// while the first request runs, then its result once it has finished
+type idempotencyRecord struct {
+	Fingerprint string          `json:"fingerprint"`
+	Claim       string          `json:"claim,omitempty"`
+	Done        bool            `json:"done,omitempty"`
+	Result      json.RawMessage `json:"result,omitempty"`
+}
+
+// idempotencyClaim is a key claimed by the request that must finish it
+type idempotencyClaim struct {
+	key         string
+	fingerprint string
+	pending     string
+}
+
+// IdempotencyStore remembers the outcome of requests by idempotency key
+// for a window. A key is bound to a fingerprint of its first request;
+// reusing it for a different request is rejected. Keys live in the shared
+// state, so a retry that reaches another instance is answered the same way.
+type IdempotencyStore struct {
+	mu     sync.Mutex
+	state  StateBackend
+	window time.Duration
+}
+
+// NewIdempotencyStore creates a store that remembers keys in state for
+// window. A zero window disables it.
+func NewIdempotencyStore(state StateBackend, window time.Duration) *IdempotencyStore {
+	return &IdempotencyStore{state: state, window: window}
+}
+
+// SetWindow changes how long new keys are remembered; zero disables the
+// store. Keys already remembered expire with the window they were stored
+// with.
+func (s *IdempotencyStore) SetWindow(window time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.window = window
+}
+
+// Enabled reports whether the store remembers keys
+func (s *IdempotencyStore) Enabled() bool {
+	return s.currentWindow() > 0
+}
+
+func (s *IdempotencyStore) currentWindow() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.window
+}
+
+func idempotencyStateKey(key string) string {
+	return "idempotency:" + key
+}
+
+// begin claims a key for a request. It returns the remembered result when
+// the key has already been answered, or a claim that the caller must
+// finish. The claim is a pending record that expires after
+// idempotencyLease, so a key is not held forever by a crashed instance.
+func (s *IdempotencyStore) begin(ctx context.Context, key, fingerprint string) (*idempotencyClaim, json.RawMessage, error) {
+	pending, err := json.Marshal(idempotencyRecord{Fingerprint: fingerprint, Claim: uuid.New().String()})
+	if err != nil {
+		return nil, nil, err
+	}
+	claim := &idempotencyClaim{key: idempotencyStateKey(key), fingerprint: fingerprint, pending: string(pending)}
+
+	// The record can expire between the claim and the read; try again then
+	for attempt := 0; attempt < 3; attempt++ {
+		claimed, err := s.state.CompareAndSwap(ctx, claim.key, "", claim.pending, idempotencyLease)
+		if err != nil {
+			return nil, nil, err
+		}
+		if claimed {
+			return claim, nil, nil
+		}
+
+		value, err := s.state.Get(ctx, claim.key)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		var record idempotencyRecord
+		if err := json.Unmarshal([]byte(value), &record); err != nil {
+			return nil, nil, fmt.Errorf("decoding idempotency record: %w", err)
+		}
+		if record.Fingerprint != fingerprint {
+			return nil, nil, ErrIdempotencyKeyReused
+		}
+		if !record.Done {
+			break
+		}
+		return nil, record.Result, nil
+	}
+	return nil, nil, errorf(ErrConflict, "a request with this idempotency key is still in progress")
+}
+
+// finish records the result of a claimed key. When keep is false the claim
+// is dropped so that a retry runs again. A claim that has expired in the
+// meantime is left to whoever holds the key now.
+func (s *IdempotencyStore) finish(ctx context.Context, claim *idempotencyClaim, result interface{}, keep bool) error {
+	ctx = context.WithoutCancel(ctx)
+	if !keep {
+		_, err := s.state.CompareAndDelete(ctx, claim.key, claim.pending)
+		return err
+	}
+
+	if err := s.record(ctx, claim, result); err != nil {
+		// Without a record a retry must be able to run again
+		s.state.CompareAndDelete(ctx, claim.key, claim.pending)
+		return err
+	}
+	return nil
+}
+
+// record replaces a pending claim with the finished result
+func (s *IdempotencyStore) record(ctx context.Context, claim *idempotencyClaim, result interface{}) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	done, err := json.Marshal(idempotencyRecord{Fingerprint: claim.fingerprint, Done: true, Result: data})
+	if err != nil {
+		return err
+	}
+	_, err = s.state.CompareAndSwap(ctx, claim.key, claim.pending, string(done), s.currentWindow())
+	return err
+}
+
+// recordedResponse is a response kept for replay
+type recordedResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+// Middleware replays the first response to a write carrying an
+// Idempotency-Key header. Keys are scoped to the caller. Server errors,
+// rate limiting and streamed responses are not remembered, so retrying
+// them runs the request again.
+func (s *IdempotencyStore) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" || !s.Enabled() || r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			httpError(w, "Idempotency-Key is too long", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBody))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeError(w, errorf(ErrTooLarge, "request body exceeds %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+				return
+			}
+			writeError(w, errorf(ErrInvalid, "reading request body: %w", err), http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		sum := sha256.New()
+		io.WriteString(sum, r.Method+" "+r.URL.RequestURI()+"\n")
+		sum.Write(body)
+		scoped := callerKey(r) + "|" + key
+
+		claim, result, err := s.begin(r.Context(), scoped, hex.EncodeToString(sum.Sum(nil)))
+		if err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+		if claim == nil {
+			var response recordedResponse
+			if err := json.Unmarshal(result, &response); err != nil {
+				writeError(w, fmt.Errorf("decoding remembered response: %w", err), http.StatusInternalServerError)
+				return
+			}
+			response.replay(w)
+			return
+		}
+
+		// The response has been sent by the time the claim is finished,
+		// so a failure to record it only means a retry runs again
+		recorder := &idempotentWriter{ResponseWriter: w}
+		kept := false
+		defer func() {
+			if !kept {
+				s.finish(r.Context(), claim, nil, false)
+			}
+		}()
+		next.ServeHTTP(recorder, r)
+
+		if response, ok := recorder.recorded(); ok {
+			s.finish(r.Context(), claim, response, true)
+			kept = true
+		}
+	})
+}
+
+// replay writes a remembered response. The request ID header stays the
+// retry's own.
+func (rr *recordedResponse) replay(w http.ResponseWriter) {
+	for name, values := range rr.Header {
+		if name != http.CanonicalHeaderKey(RequestIDHeader) {
+			w.Header()[name] = values
+		}
+	}
+	w.Header().Set(IdempotentReplayHeader, "true")
+	w.WriteHeader(rr.Status)
+	w.Write(rr.Body)
+}
+
+// idempotentWriter copies a response as it is written. It keeps the
+// Flusher and Hijacker of the underlying writer; a flushed or hijacked
+// response is a stream and is not remembered.
+type idempotentWriter struct {
+	http.ResponseWriter
+	status   int
+	header   http.Header
+	body     bytes.Buffer
+	streamed bool
+}
+
+func (iw *idempotentWriter) WriteHeader(status int) {
+	if iw.status == 0 {
+		iw.status = status
+		iw.header = iw.ResponseWriter.Header().Clone()
+	}
+	iw.ResponseWriter.WriteHeader(status)
+}
+
+func (iw *idempotentWriter) Write(p []byte) (int, error) {
+	if iw.status == 0 {
+		iw.WriteHeader(http.StatusOK)
+	}
+	if iw.body.Len()+len(p) > maxRecordedBody {
+		iw.streamed = true
+	} else if !iw.streamed {
+		iw.body.Write(p)
+	}
+	return iw.ResponseWriter.Write(p)
+}
+
+// Flush sends buffered data to the client
+func (iw *idempotentWriter) Flush() {
+	iw.streamed = true
+	http.NewResponseController(iw.ResponseWriter).Flush()
+}
+
+// Hijack hands the connection to the handler
+func (iw *idempotentWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	iw.streamed = true
+	return http.NewResponseController(iw.ResponseWriter).Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (iw *idempotentWriter) Unwrap() http.ResponseWriter {
+	return iw.ResponseWriter
+}
+
+// recorded returns the response if it may be replayed
+func (iw *idempotentWriter) recorded() (*recordedResponse, bool) {
+	if iw.streamed || iw.status >= http.StatusInternalServerError || iw.status == http.StatusTooManyRequests {
+		return nil, false
+	}
+	if iw.status == 0 {
+		// The handler wrote nothing, which net/http answers with 200
+		iw.status = http.StatusOK
+		iw.header = iw.ResponseWriter.Header().Clone()
+	}
+	return &recordedResponse{Status: iw.status, Header: iw.header, Body: iw.body.Bytes()}, true
+}
+
+// IdempotentMemory wraps a MemoryBackend so that a store whose context
+// carries an idempotency key (see WithIdempotencyKey) happens only once
+// per key and agent. A retry gets the memory stored by the first call;
+// reusing the key for a different memory returns ErrIdempotencyKeyReused.
+// Failed stores are not remembered. It is for Go callers of a backend;
+// HTTP requests go through IdempotencyStore.Middleware instead.
+type IdempotentMemory struct {
+	MemoryBackend
+	store *IdempotencyStore
+}
+
+// NewIdempotentMemory creates a wrapper that remembers keys in state for
+// window
+func NewIdempotentMemory(backend MemoryBackend, state StateBackend, window time.Duration) *IdempotentMemory {
+	return &IdempotentMemory{MemoryBackend: backend, store: NewIdempotencyStore(state, window)}
+}
+
+// StoreMemory stores a memory once per idempotency key
+func (m *IdempotentMemory) StoreMemory(ctx context.Context, memory *Memory) error {
+	key, ok := IdempotencyKeyFromContext(ctx)
+	if !ok || !m.store.Enabled() {
+		return m.MemoryBackend.StoreMemory(ctx, memory)
+	}
+
+	fingerprint, err := memoryFingerprint(memory)
+	if err != nil {
+		return err
+	}
+	claim, result, err := m.store.begin(ctx, "memory:"+memory.AgentID+"|"+key, fingerprint)
+	if err != nil {
+		return err
+	}
+	if claim == nil {
+		var stored Memory
+		if err := json.Unmarshal(result, &stored); err != nil {
+			return fmt.Errorf("decoding remembered memory: %w", err)
+		}
+		*memory = stored
+		return nil
+	}
+
+	if err := m.MemoryBackend.StoreMemory(ctx, memory); err != nil {
+		m.store.finish(ctx, claim, nil, false)
+		return err
+	}
+	// The memory is stored; failing to remember it only means a retry
+	// stores it again
+	m.store.finish(ctx, claim, memory, true)
+	return nil
+}
+
+// memoryFingerprint hashes the fields a client sets on a new memory
+func memoryFingerprint(memory *Memory) (string, error) {
+	data, err := json.Marshal(storeMemoryRequest{
+		AgentID:    memory.AgentID,
+		Type:       string(memory.Type),
+		Content:    memory.Content,
+		Metadata:   memory.Metadata,
+		Tags:       memory.Tags,
+		Importance: memory.Importance,
+		Embeddings: memory.Embeddings,
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,127 @@
+package memoryos
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// countingHandler answers with status and counts the requests it served
+func countingHandler(calls *int, status int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		*calls++
+		w.WriteHeader(status)
+		io.WriteString(w, `{"ok":true}`)
+	})
+}
+
+func idempotentRequest(key string, body io.Reader) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/memory", body)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set(IdempotencyKeyHeader, key)
+	return r
+}
+
+func TestIdempotencySharedAcrossInstances(t *testing.T) {
+	state := NewLocalState()
+	calls := 0
+	first := NewIdempotencyStore(state, time.Hour).Middleware(countingHandler(&calls, http.StatusCreated))
+	second := NewIdempotencyStore(state, time.Hour).Middleware(countingHandler(&calls, http.StatusCreated))
+
+	rec := httptest.NewRecorder()
+	first.ServeHTTP(rec, idempotentRequest("k", strings.NewReader(`{"a":1}`)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("first: %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	second.ServeHTTP(rec, idempotentRequest("k", strings.NewReader(`{"a":1}`)))
+	if calls != 1 || rec.Code != http.StatusCreated || rec.Header().Get(IdempotentReplayHeader) != "true" || rec.Body.String() != `{"ok":true}` {
+		t.Fatalf("retry on another instance: calls %d, %d %q", calls, rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	second.ServeHTTP(rec, idempotentRequest("k", strings.NewReader(`{"a":2}`)))
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("reused key: %d", rec.Code)
+	}
+}
+
+func TestIdempotencyPendingAndFailedRequests(t *testing.T) {
+	ctx := context.Background()
+	state := NewLocalState()
+	store := NewIdempotencyStore(state, time.Hour)
+
+	// A key claimed by a request still running elsewhere is a conflict
+	claim, _, err := store.begin(ctx, "|addr:10.0.0.1|k", "fingerprint")
+	if err != nil || claim == nil {
+		t.Fatalf("claim: %v", err)
+	}
+	if _, _, err := store.begin(ctx, "|addr:10.0.0.1|k", "fingerprint"); !errors.Is(err, ErrConflict) {
+		t.Fatalf("second claim: %v", err)
+	}
+	if err := store.finish(ctx, claim, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := state.Get(ctx, idempotencyStateKey("|addr:10.0.0.1|k")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("released claim still stored: %v", err)
+	}
+
+	// Server errors are not remembered, so the retry runs again
+	calls := 0
+	handler := store.Middleware(countingHandler(&calls, http.StatusInternalServerError))
+	for i := 0; i < 2; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), idempotentRequest("k", strings.NewReader(`{}`)))
+	}
+	if calls != 2 {
+		t.Fatalf("failed request replayed: %d calls", calls)
+	}
+}
+
+func TestIdempotencyRejectsLargeBodies(t *testing.T) {
+	calls := 0
+	handler := NewIdempotencyStore(NewLocalState(), time.Hour).Middleware(countingHandler(&calls, http.StatusOK))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, idempotentRequest("k", bytes.NewReader(make([]byte, maxIdempotentBody+1))))
+	if rec.Code != http.StatusRequestEntityTooLarge || calls != 0 {
+		t.Fatalf("status %d, %d calls", rec.Code, calls)
+	}
+}
+
+func TestIdempotentMemorySharedState(t *testing.T) {
+	b := newTestBackends()
+	state := NewLocalState()
+	ctx := WithIdempotencyKey(context.Background(), "k")
+
+	memory := &Memory{ID: "m1", AgentID: "a", Type: MemoryTypeEpisodic, Content: "hello"}
+	if err := NewIdempotentMemory(coreMemory{b.memory}, state, time.Hour).StoreMemory(ctx, memory); err != nil {
+		t.Fatal(err)
+	}
+	retry := &Memory{ID: "m2", AgentID: "a", Type: MemoryTypeEpisodic, Content: "hello"}
+	if err := NewIdempotentMemory(coreMemory{b.memory}, state, time.Hour).StoreMemory(ctx, retry); err != nil {
+		t.Fatal(err)
+	}
+	if retry.ID != "m1" {
+		t.Fatalf("retry stored %s", retry.ID)
+	}
+	if all, _ := b.memory.SearchMemories(ctx, "a", "", 0); len(all) != 1 {
+		t.Fatalf("%d memories stored", len(all))
+	}
+
+	// Keys are per agent: another agent's memory is not a retry
+	other := &Memory{ID: "m3", AgentID: "b", Type: MemoryTypeEpisodic, Content: "hello"}
+	if err := NewIdempotentMemory(coreMemory{b.memory}, state, time.Hour).StoreMemory(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	if other.ID != "m3" || other.AgentID != "b" {
+		t.Fatalf("another agent got %+v", other)
+	}
+}
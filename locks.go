@@ -0,0 +1,237 @@
+package memoryos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrLocked is returned when a shared key is leased to another agent
+var ErrLocked = errors.New("locked")
+
+// ErrLeaseNotHeld is returned when renewing or releasing a lease the caller
+// does not hold, either because it expired or because the token is stale
+var ErrLeaseNotHeld = newError(ErrConflict, "lease not held")
+
+const (
+	// DefaultLeaseTTL is used when a lock request does not specify a TTL
+	DefaultLeaseTTL = 30 * time.Second
+	// MaxLeaseTTL caps how long a single lease can run without renewal
+	MaxLeaseTTL = 10 * time.Minute
+)
+
+// Lease is an exclusive, expiring hold on a shared key. The fencing token
+// increases with every new lease so storage can reject writes from a holder
+// whose lease has already been taken over.
+type Lease struct {
+	TeamID     string    `json:"team_id"`
+	Key        string    `json:"key"`
+	Owner      string    `json:"owner"`
+	Token      uint64    `json:"token"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// LockManager hands out leases on shared keys. Leases live in the
+// StateBackend with a TTL, so they hold across every server instance and a
+// crashed agent never holds a key forever: a lease is taken with an atomic
+// set-if-absent, and renewal and release only succeed while the stored
+// lease still carries the caller's fencing token.
+type LockManager struct {
+	state StateBackend
+	now   func() time.Time
+}
+
+// NewLockManager creates a new lock manager
+func NewLockManager(state StateBackend) *LockManager {
+	return &LockManager{state: state, now: time.Now}
+}
+
+func clampLeaseTTL(ttl time.Duration) time.Duration {
+	if ttl <= 0 {
+		return DefaultLeaseTTL
+	}
+	if ttl > MaxLeaseTTL {
+		return MaxLeaseTTL
+	}
+	return ttl
+}
+
+const leaseKeyPrefix = "lease:"
+
+// leaseTokenKey counts the fencing tokens handed out
+const leaseTokenKey = "lease-token"
+
+// active returns the live lease on a key, or nil, along with its stored
+// form
+func (l *LockManager) active(ctx context.Context, teamID, key string) (*Lease, string, error) {
+	raw, err := l.state.Get(ctx, leaseKeyPrefix+sharedKey(teamID, key))
+	if errors.Is(err, ErrNotFound) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	var lease Lease
+	if err := json.Unmarshal([]byte(raw), &lease); err != nil {
+		return nil, "", fmt.Errorf("lease on %s: %w", key, err)
+	}
+	if !l.now().Before(lease.ExpiresAt) {
+		return nil, raw, nil
+	}
+	return &lease, raw, nil
+}
+
+// put writes a lease if the stored one is still old ("" for none)
+func (l *LockManager) put(ctx context.Context, lease *Lease, old string) (bool, error) {
+	data, err := json.Marshal(lease)
+	if err != nil {
+		return false, err
+	}
+	ttl := lease.ExpiresAt.Sub(l.now())
+	return l.state.CompareAndSwap(ctx, leaseKeyPrefix+sharedKey(lease.TeamID, lease.Key), old, string(data), ttl)
+}
+
+// Acquire takes a lease on a shared key. Re-acquiring a lease the owner
+// already holds extends it and keeps its fencing token.
+func (l *LockManager) Acquire(ctx context.Context, teamID, key, owner string, ttl time.Duration) (*Lease, error) {
+	if owner == "" {
+		return nil, errorf(ErrInvalid, "owner required")
+	}
+
+	for attempt := 0; attempt < sharedWriteAttempts; attempt++ {
+		current, raw, err := l.active(ctx, teamID, key)
+		if err != nil {
+			return nil, err
+		}
+
+		now := l.now()
+		var lease Lease
+		if current != nil {
+			if current.Owner != owner {
+				return nil, fmt.Errorf("%w: %s is held by %s until %s", ErrLocked, key, current.Owner, current.ExpiresAt.Format(time.RFC3339))
+			}
+			lease = *current
+		} else {
+			token, err := l.state.IncrBy(ctx, leaseTokenKey, 1)
+			if err != nil {
+				return nil, err
+			}
+			lease = Lease{TeamID: teamID, Key: key, Owner: owner, Token: uint64(token), AcquiredAt: now}
+		}
+		lease.ExpiresAt = now.Add(clampLeaseTTL(ttl))
+
+		ok, err := l.put(ctx, &lease, raw)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return &lease, nil
+		}
+		// The lease changed hands since it was read
+	}
+	return nil, fmt.Errorf("%w: %s is contended", ErrLocked, key)
+}
+
+// Renew extends a lease held by owner with the given fencing token
+func (l *LockManager) Renew(ctx context.Context, teamID, key, owner string, token uint64, ttl time.Duration) (*Lease, error) {
+	lease, raw, err := l.active(ctx, teamID, key)
+	if err != nil {
+		return nil, err
+	}
+	if lease == nil || lease.Owner != owner || lease.Token != token {
+		return nil, fmt.Errorf("%w: %s", ErrLeaseNotHeld, key)
+	}
+
+	lease.ExpiresAt = l.now().Add(clampLeaseTTL(ttl))
+	ok, err := l.put(ctx, lease, raw)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrLeaseNotHeld, key)
+	}
+	return lease, nil
+}
+
+// Release gives up a lease held by owner with the given fencing token
+func (l *LockManager) Release(ctx context.Context, teamID, key, owner string, token uint64) error {
+	lease, raw, err := l.active(ctx, teamID, key)
+	if err != nil {
+		return err
+	}
+	if lease == nil || lease.Owner != owner || lease.Token != token {
+		return fmt.Errorf("%w: %s", ErrLeaseNotHeld, key)
+	}
+
+	ok, err := l.state.CompareAndDelete(ctx, leaseKeyPrefix+sharedKey(teamID, key), raw)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrLeaseNotHeld, key)
+	}
+	return nil
+}
+
+// Holder returns the current lease on a key, or nil if it is not leased
+func (l *LockManager) Holder(ctx context.Context, teamID, key string) (*Lease, error) {
+	lease, _, err := l.active(ctx, teamID, key)
+	return lease, err
+}
+
+// CheckWrite rejects a write to a leased key by anyone other than the lease
+// owner. A non-zero fencing token must match the live lease.
+func (l *LockManager) CheckWrite(ctx context.Context, teamID, key, agentID string, token uint64) error {
+	lease, _, err := l.active(ctx, teamID, key)
+	if err != nil {
+		return err
+	}
+	if lease == nil {
+		if token != 0 {
+			return fmt.Errorf("%w: fencing token %d is no longer valid for %s", ErrLocked, token, key)
+		}
+		return nil
+	}
+	if lease.Owner != agentID {
+		return fmt.Errorf("%w: %s is held by %s", ErrLocked, key, lease.Owner)
+	}
+	if token != 0 && token != lease.Token {
+		return fmt.Errorf("%w: stale fencing token %d for %s", ErrLocked, token, key)
+	}
+	return nil
+}
+
+// ReleaseOwner drops every lease held by owner and returns how many were
+// released
+func (l *LockManager) ReleaseOwner(ctx context.Context, owner string) (int, error) {
+	keys, err := l.state.Keys(ctx, leaseKeyPrefix)
+	if err != nil {
+		return 0, err
+	}
+
+	released := 0
+	for _, k := range keys {
+		raw, err := l.state.Get(ctx, k)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return released, err
+		}
+		var lease Lease
+		if err := json.Unmarshal([]byte(raw), &lease); err != nil || lease.Owner != owner {
+			continue
+		}
+		ok, err := l.state.CompareAndDelete(ctx, k, raw)
+		if err != nil {
+			return released, err
+		}
+		if ok {
+			released++
+		}
+	}
+	return released, nil
+}
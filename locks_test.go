@@ -0,0 +1,113 @@
+package memoryos
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestLockManagerLeaseIsSharedAcrossInstances(t *testing.T) {
+	ctx := context.Background()
+	state := NewLocalState()
+	one, two := NewLockManager(state), NewLockManager(state)
+
+	lease, err := one.Acquire(ctx, "team", "k", "a", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := two.Acquire(ctx, "team", "k", "b", time.Minute); !errors.Is(err, ErrLocked) {
+		t.Fatalf("second holder: %v", err)
+	}
+	if err := two.CheckWrite(ctx, "team", "k", "b", 0); !errors.Is(err, ErrLocked) {
+		t.Fatalf("write by non-holder: %v", err)
+	}
+	if err := two.CheckWrite(ctx, "team", "k", "a", lease.Token); err != nil {
+		t.Fatalf("write by holder: %v", err)
+	}
+
+	again, err := two.Acquire(ctx, "team", "k", "a", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Token != lease.Token {
+		t.Fatalf("re-acquire changed the token from %d to %d", lease.Token, again.Token)
+	}
+}
+
+func TestLockManagerTokenCheckedRelease(t *testing.T) {
+	ctx := context.Background()
+	state := NewLocalState()
+	locks := NewLockManager(state)
+
+	lease, _ := locks.Acquire(ctx, "team", "k", "a", time.Minute)
+	if err := locks.Release(ctx, "team", "k", "a", lease.Token+1); !errors.Is(err, ErrLeaseNotHeld) {
+		t.Fatalf("release with a stale token: %v", err)
+	}
+	if err := locks.Release(ctx, "team", "k", "a", lease.Token); err != nil {
+		t.Fatal(err)
+	}
+
+	next, err := locks.Acquire(ctx, "team", "k", "b", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.Token <= lease.Token {
+		t.Fatalf("token did not advance: %d after %d", next.Token, lease.Token)
+	}
+	// The old holder can neither renew nor release the new lease
+	if _, err := locks.Renew(ctx, "team", "k", "a", lease.Token, time.Minute); !errors.Is(err, ErrLeaseNotHeld) {
+		t.Fatalf("renew by old holder: %v", err)
+	}
+	if err := locks.CheckWrite(ctx, "team", "k", "b", lease.Token); !errors.Is(err, ErrLocked) {
+		t.Fatalf("write with the old token: %v", err)
+	}
+}
+
+func TestLockManagerExpiry(t *testing.T) {
+	ctx := context.Background()
+	state := NewLocalState()
+	locks := NewLockManager(state)
+	now := time.Now()
+	locks.now = func() time.Time { return now }
+	state.now = locks.now
+
+	locks.Acquire(ctx, "team", "k", "a", time.Second)
+	now = now.Add(2 * time.Second)
+	if lease, err := locks.Holder(ctx, "team", "k"); err != nil || lease != nil {
+		t.Fatalf("expired lease still held: %+v %v", lease, err)
+	}
+	if _, err := locks.Acquire(ctx, "team", "k", "b", time.Second); err != nil {
+		t.Fatalf("acquire after expiry: %v", err)
+	}
+}
+
+func TestLockManagerReleaseOwner(t *testing.T) {
+	ctx := context.Background()
+	locks := NewLockManager(NewLocalState())
+
+	locks.Acquire(ctx, "team", "one", "a", time.Minute)
+	locks.Acquire(ctx, "team", "two", "a", time.Minute)
+	locks.Acquire(ctx, "team", "three", "b", time.Minute)
+
+	released, err := locks.ReleaseOwner(ctx, "a")
+	if err != nil || released != 2 {
+		t.Fatalf("released %d: %v", released, err)
+	}
+	if lease, _ := locks.Holder(ctx, "team", "three"); lease == nil {
+		t.Fatal("released another owner's lease")
+	}
+}
+
+func TestSharedLockRejectsBadToken(t *testing.T) {
+	b := newTestBackends()
+	s := newTestServer(t, b)
+	registerTestAgent(t, s, "a")
+	s.roster.Create(context.Background(), &Team{ID: "team", Name: "team"}, "a")
+
+	rec := serve(t, s, http.MethodDelete, "/shared/lock?team_id=team&key=k&agent_id=a&token=12abc", "a", "")
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+}
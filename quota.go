@@ -0,0 +1,484 @@
+package memoryos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrQuotaExceeded is returned when storing a memory would take an agent or
+// tenant past one of its limits
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// ErrTooLarge is returned when a single memory is larger than any quota
+// allows
+var ErrTooLarge = errors.New("memory too large")
+
+// Quota limits what can be stored. Zero means unlimited.
+type Quota struct {
+	MaxMemories        int                `json:"max_memories,omitempty"`
+	MaxTokens          int                `json:"max_tokens,omitempty"`
+	MaxMemoriesPerType map[MemoryType]int `json:"max_memories_per_type,omitempty"`
+	MaxEmbeddingDims   int                `json:"max_embedding_dims,omitempty"`
+	MaxTokensPerMemory int                `json:"max_tokens_per_memory,omitempty"`
+}
+
+// QuotaPolicy holds the limits applied to each agent and to the tenant as
+// a whole
+type QuotaPolicy struct {
+	Agent  Quota `json:"agent"`
+	Tenant Quota `json:"tenant"`
+}
+
+// QuotaUsage reports current usage against one limit
+type QuotaUsage struct {
+	Limit string `json:"limit"`
+	Used  int    `json:"used"`
+	Max   int    `json:"max"`
+}
+
+// QuotaError names the limit a write ran into
+type QuotaError struct {
+	Scope string
+	Limit string
+	Used  int
+	Max   int
+	err   error
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%s: %s %s is %d, limit %d", e.err, e.Scope, e.Limit, e.Used, e.Max)
+}
+
+func (e *QuotaError) Unwrap() error {
+	return e.err
+}
+
+// ErrorDetails reports the limit that was hit in error responses
+func (e *QuotaError) ErrorDetails() interface{} {
+	return map[string]interface{}{"scope": e.Scope, "limit": e.Limit, "used": e.Used, "max": e.Max}
+}
+
+// Usage counters are kept in state so that every server instance charges
+// the same totals. quotaKeyPrefix starts them; the scope (an agent or the
+// tenant) ends them.
+const quotaKeyPrefix = "quota:"
+
+// tenantQuotaScope names the counters of the tenant as a whole
+const tenantQuotaScope = "tenant"
+
+func agentQuotaScope(agentID string) string {
+	return "agent:" + agentID
+}
+
+func quotaKey(counter, scope string) string {
+	return quotaKeyPrefix + counter + ":" + scope
+}
+
+// quotaCharge is what a write adds to a scope's usage; removals are
+// negative
+type quotaCharge struct {
+	memories int
+	tokens   int
+	types    map[MemoryType]int
+}
+
+// chargeOf is the usage of a memory, counted sign times
+func chargeOf(memory *Memory, sign int) quotaCharge {
+	return quotaCharge{
+		memories: sign,
+		tokens:   sign * estimateTokens(memory.Content),
+		types:    map[MemoryType]int{memory.Type: sign},
+	}
+}
+
+// plus adds two charges
+func (c quotaCharge) plus(other quotaCharge) quotaCharge {
+	sum := quotaCharge{memories: c.memories + other.memories, tokens: c.tokens + other.tokens, types: make(map[MemoryType]int)}
+	for _, charge := range []quotaCharge{c, other} {
+		for t, n := range charge.types {
+			sum.types[t] += n
+		}
+	}
+	return sum
+}
+
+// negated undoes a charge
+func (c quotaCharge) negated() quotaCharge {
+	negated := quotaCharge{memories: -c.memories, tokens: -c.tokens, types: make(map[MemoryType]int, len(c.types))}
+	for t, n := range c.types {
+		negated.types[t] = -n
+	}
+	return negated
+}
+
+// QuotaEnforcer wraps a MemoryBackend and rejects writes that would exceed
+// the agent or tenant quota. Usage is counted in state: a write reserves
+// its charge first and gives it back when it is over a limit or fails, so
+// concurrent writers on any instance cannot overshoot a limit together.
+type QuotaEnforcer struct {
+	MemoryBackend
+	state  StateBackend
+	mu     sync.Mutex
+	policy QuotaPolicy
+	agents func(ctx context.Context) ([]string, error)
+}
+
+// NewQuotaEnforcer creates an enforcer with no limits that counts usage in
+// state
+func NewQuotaEnforcer(backend MemoryBackend, state StateBackend) *QuotaEnforcer {
+	return &QuotaEnforcer{MemoryBackend: backend, state: state}
+}
+
+// SetPolicy replaces the quotas
+func (e *QuotaEnforcer) SetPolicy(policy QuotaPolicy) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.policy = policy
+}
+
+// Policy returns the current quotas
+func (e *QuotaEnforcer) Policy() QuotaPolicy {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.policy
+}
+
+// checkSize rejects a single memory that no quota could hold
+func (e *QuotaEnforcer) checkSize(memory *Memory, policy QuotaPolicy) error {
+	tokens := estimateTokens(memory.Content)
+	for _, q := range []struct {
+		scope string
+		quota Quota
+	}{{"agent", policy.Agent}, {"tenant", policy.Tenant}} {
+		if q.quota.MaxEmbeddingDims > 0 && len(memory.Embeddings) > q.quota.MaxEmbeddingDims {
+			return &QuotaError{Scope: q.scope, Limit: "embedding dimensions", Used: len(memory.Embeddings), Max: q.quota.MaxEmbeddingDims, err: ErrTooLarge}
+		}
+		if q.quota.MaxTokensPerMemory > 0 && tokens > q.quota.MaxTokensPerMemory {
+			return &QuotaError{Scope: q.scope, Limit: "tokens per memory", Used: tokens, Max: q.quota.MaxTokensPerMemory, err: ErrTooLarge}
+		}
+		if q.quota.MaxTokens > 0 && tokens > q.quota.MaxTokens {
+			return &QuotaError{Scope: q.scope, Limit: "tokens", Used: tokens, Max: q.quota.MaxTokens, err: ErrTooLarge}
+		}
+	}
+	return nil
+}
+
+// tenantUsage sums the stats of every agent in the tenant, to seed the
+// tenant's counters
+func (e *QuotaEnforcer) tenantUsage(ctx context.Context) (*MemoryStats, error) {
+	total := &MemoryStats{ByType: make(map[string]int)}
+	if e.agents == nil {
+		return total, nil
+	}
+
+	agentIDs, err := e.agents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, agentID := range agentIDs {
+		stats, err := e.MemoryBackend.GetMemoryStats(ctx, agentID)
+		if err != nil {
+			return nil, err
+		}
+		total.TotalMemories += stats.TotalMemories
+		total.TotalTokens += stats.TotalTokens
+		for t, n := range stats.ByType {
+			total.ByType[t] += n
+		}
+	}
+	return total, nil
+}
+
+// seed starts a scope's counters from the backend's stats unless another
+// writer already has. Each counter is only set if it does not exist yet,
+// and the memories counter, which marks the scope as seeded, is set last.
+func (e *QuotaEnforcer) seed(ctx context.Context, scope, agentID string) error {
+	marker := quotaKey("memories", scope)
+	if _, err := e.state.Get(ctx, marker); err == nil || !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	var stats *MemoryStats
+	var err error
+	if scope == tenantQuotaScope {
+		stats, err = e.tenantUsage(ctx)
+	} else {
+		stats, err = e.MemoryBackend.GetMemoryStats(ctx, agentID)
+	}
+	if err != nil {
+		return err
+	}
+
+	counters := [][2]string{}
+	for t, n := range stats.ByType {
+		counters = append(counters, [2]string{quotaKey("type:"+t, scope), strconv.Itoa(n)})
+	}
+	counters = append(counters,
+		[2]string{quotaKey("tokens", scope), strconv.Itoa(stats.TotalTokens)},
+		[2]string{marker, strconv.Itoa(stats.TotalMemories)},
+	)
+	for _, counter := range counters {
+		if _, err := e.state.CompareAndSwap(ctx, counter[0], "", counter[1], 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply adds a charge to a scope's counters and returns the new totals.
+// If a counter cannot be written, the counters already changed are put
+// back.
+func (e *QuotaEnforcer) apply(ctx context.Context, scope string, charge quotaCharge) (quotaCharge, error) {
+	counters := []string{"memories", "tokens"}
+	deltas := []int{charge.memories, charge.tokens}
+	for t, n := range charge.types {
+		if n != 0 {
+			counters = append(counters, "type:"+string(t))
+			deltas = append(deltas, n)
+		}
+	}
+
+	values := make([]int, len(counters))
+	for i, counter := range counters {
+		n, err := e.state.IncrBy(ctx, quotaKey(counter, scope), int64(deltas[i]))
+		if err != nil {
+			for j := range counters[:i] {
+				e.state.IncrBy(context.WithoutCancel(ctx), quotaKey(counters[j], scope), int64(-deltas[j]))
+			}
+			return quotaCharge{}, err
+		}
+		values[i] = int(n)
+	}
+
+	totals := quotaCharge{memories: values[0], tokens: values[1], types: make(map[MemoryType]int)}
+	for i, counter := range counters[2:] {
+		totals.types[MemoryType(strings.TrimPrefix(counter, "type:"))] = values[i+2]
+	}
+	return totals, nil
+}
+
+// exceeded names the first limit that totals are over because of charge.
+// Limits the charge does not add to are not checked.
+func exceeded(scope string, quota Quota, totals, charge quotaCharge) error {
+	if quota.MaxMemories > 0 && charge.memories > 0 && totals.memories > quota.MaxMemories {
+		return &QuotaError{Scope: scope, Limit: "memories", Used: totals.memories - charge.memories, Max: quota.MaxMemories, err: ErrQuotaExceeded}
+	}
+	for t, n := range charge.types {
+		if max := quota.MaxMemoriesPerType[t]; max > 0 && n > 0 && totals.types[t] > max {
+			return &QuotaError{Scope: scope, Limit: string(t) + " memories", Used: totals.types[t] - n, Max: max, err: ErrQuotaExceeded}
+		}
+	}
+	if quota.MaxTokens > 0 && charge.tokens > 0 && totals.tokens > quota.MaxTokens {
+		return &QuotaError{Scope: scope, Limit: "tokens", Used: totals.tokens - charge.tokens, Max: quota.MaxTokens, err: ErrQuotaExceeded}
+	}
+	return nil
+}
+
+// reserve charges a write to the agent's and the tenant's counters. If
+// either goes over its quota, or a counter cannot be written, the charge
+// is given back and the write must not happen.
+func (e *QuotaEnforcer) reserve(ctx context.Context, agentID string, charge quotaCharge, policy QuotaPolicy) error {
+	scopes := []struct {
+		name  string
+		scope string
+		quota Quota
+	}{{"agent", agentQuotaScope(agentID), policy.Agent}, {"tenant", tenantQuotaScope, policy.Tenant}}
+
+	for i, s := range scopes {
+		err := e.seed(ctx, s.scope, agentID)
+		if err == nil {
+			var totals quotaCharge
+			if totals, err = e.apply(ctx, s.scope, charge); err == nil {
+				if err = exceeded(s.name, s.quota, totals, charge); err != nil {
+					e.apply(context.WithoutCancel(ctx), s.scope, charge.negated())
+				}
+			}
+		}
+		if err != nil {
+			for _, charged := range scopes[:i] {
+				e.apply(context.WithoutCancel(ctx), charged.scope, charge.negated())
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// release gives back a charge that was reserved for a write that failed,
+// or takes off the usage of a removed memory. The counters have been
+// seeded already.
+func (e *QuotaEnforcer) release(ctx context.Context, agentID string, charge quotaCharge) error {
+	ctx = context.WithoutCancel(ctx)
+	for _, scope := range []string{agentQuotaScope(agentID), tenantQuotaScope} {
+		if _, err := e.apply(ctx, scope, charge.negated()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// admit checks a new memory against the quotas and reserves its usage
+func (e *QuotaEnforcer) admit(ctx context.Context, memory *Memory, policy QuotaPolicy) error {
+	if err := e.checkSize(memory, policy); err != nil {
+		return err
+	}
+	return e.reserve(ctx, memory.AgentID, chargeOf(memory, 1), policy)
+}
+
+// StoreMemory stores a memory if it fits within the agent and tenant quotas
+func (e *QuotaEnforcer) StoreMemory(ctx context.Context, memory *Memory) error {
+	if err := e.admit(ctx, memory, e.Policy()); err != nil {
+		return err
+	}
+	if err := e.MemoryBackend.StoreMemory(ctx, memory); err != nil {
+		e.release(ctx, memory.AgentID, chargeOf(memory, 1))
+		return err
+	}
+	return nil
+}
+
+// StoreMemories stores the memories of a batch that fit within the quotas.
+// Memories earlier in the batch count against the limits of later ones.
+func (e *QuotaEnforcer) StoreMemories(ctx context.Context, memories []*Memory, opts BatchOptions) []error {
+	policy := e.Policy()
+	errs := make([]error, len(memories))
+	reserved := make([]bool, len(memories))
+	for i, memory := range memories {
+		errs[i] = e.admit(ctx, memory, policy)
+		reserved[i] = errs[i] == nil
+	}
+
+	errs = forwardBatch(ctx, e.MemoryBackend, memories, errs, opts)
+	for i, memory := range memories {
+		if reserved[i] && errs[i] != nil {
+			e.release(ctx, memory.AgentID, chargeOf(memory, 1))
+		}
+	}
+	return errs
+}
+
+// UpdateMemory applies the per-memory size limits to the updated memory
+// and charges the difference from the stored one
+func (e *QuotaEnforcer) UpdateMemory(ctx context.Context, memory *Memory) error {
+	policy := e.Policy()
+	if err := e.checkSize(memory, policy); err != nil {
+		return err
+	}
+	current, err := e.MemoryBackend.GetMemory(ctx, memory.AgentID, memory.Type, memory.ID)
+	if err != nil {
+		return err
+	}
+
+	delta := chargeOf(memory, 1).plus(chargeOf(current, -1))
+	if err := e.reserve(ctx, memory.AgentID, delta, policy); err != nil {
+		return err
+	}
+	if err := e.MemoryBackend.UpdateMemory(ctx, memory); err != nil {
+		e.release(ctx, memory.AgentID, delta)
+		return err
+	}
+	return nil
+}
+
+// DeleteMemory deletes a memory and takes it off the usage counters. The
+// counters are seeded before the delete, so the stats they start from
+// still include the memory.
+func (e *QuotaEnforcer) DeleteMemory(ctx context.Context, agentID string, memoryType MemoryType, id string) error {
+	current, err := e.MemoryBackend.GetMemory(ctx, agentID, memoryType, id)
+	if err != nil {
+		return err
+	}
+	for _, scope := range []string{agentQuotaScope(agentID), tenantQuotaScope} {
+		if err := e.seed(ctx, scope, agentID); err != nil {
+			return err
+		}
+	}
+	if err := e.MemoryBackend.DeleteMemory(ctx, agentID, memoryType, id); err != nil {
+		return err
+	}
+	return e.release(ctx, agentID, chargeOf(current, 1))
+}
+
+// usage reads a scope's counters, seeding them first, as the totals of a
+// MemoryStats
+func (e *QuotaEnforcer) usage(ctx context.Context, scope, agentID string) (*MemoryStats, error) {
+	if err := e.seed(ctx, scope, agentID); err != nil {
+		return nil, err
+	}
+	read := func(counter string) (int, error) {
+		value, err := e.state.Get(ctx, quotaKey(counter, scope))
+		if errors.Is(err, ErrNotFound) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		return strconv.Atoi(value)
+	}
+
+	stats := &MemoryStats{AgentID: agentID, ByType: make(map[string]int)}
+	var err error
+	if stats.TotalMemories, err = read("memories"); err != nil {
+		return nil, err
+	}
+	if stats.TotalTokens, err = read("tokens"); err != nil {
+		return nil, err
+	}
+	for _, t := range quotaMemoryTypes {
+		n, err := read("type:" + string(t))
+		if err != nil {
+			return nil, err
+		}
+		if n != 0 {
+			stats.ByType[string(t)] = n
+		}
+	}
+	return stats, nil
+}
+
+// GetMemoryStats reports the agent's usage against each agent limit. The
+// totals come from the counters the quotas are enforced with.
+func (e *QuotaEnforcer) GetMemoryStats(ctx context.Context, agentID string) (*MemoryStats, error) {
+	stats, err := e.MemoryBackend.GetMemoryStats(ctx, agentID)
+	if err != nil {
+		return nil, err
+	}
+	usage, err := e.usage(ctx, agentQuotaScope(agentID), agentID)
+	if err != nil {
+		return nil, err
+	}
+	copied := *stats
+	copied.TotalMemories, copied.TotalTokens, copied.ByType = usage.TotalMemories, usage.TotalTokens, usage.ByType
+	copied.Quota = quotaUsage(e.Policy().Agent, usage)
+	return &copied, nil
+}
+
+// TenantUsage reports the tenant's counted totals
+func (e *QuotaEnforcer) TenantUsage(ctx context.Context) (*MemoryStats, error) {
+	return e.usage(ctx, tenantQuotaScope, "")
+}
+
+// quotaMemoryTypes are the memory types counted per type
+var quotaMemoryTypes = []MemoryType{MemoryTypeEpisodic, MemoryTypeSemantic, MemoryTypeSkill, MemoryTypeWorking, MemoryTypeShared}
+
+// quotaUsage lists usage against each counted limit set in the quota.
+// Per-memory size limits have no running usage and are not listed.
+func quotaUsage(quota Quota, stats *MemoryStats) []QuotaUsage {
+	usage := []QuotaUsage{}
+	if quota.MaxMemories > 0 {
+		usage = append(usage, QuotaUsage{Limit: "memories", Used: stats.TotalMemories, Max: quota.MaxMemories})
+	}
+	if quota.MaxTokens > 0 {
+		usage = append(usage, QuotaUsage{Limit: "tokens", Used: stats.TotalTokens, Max: quota.MaxTokens})
+	}
+	for _, t := range quotaMemoryTypes {
+		if max := quota.MaxMemoriesPerType[t]; max > 0 {
+			usage = append(usage, QuotaUsage{Limit: string(t) + " memories", Used: stats.ByType[string(t)], Max: max})
+		}
+	}
+	return usage
+}
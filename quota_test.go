@@ -0,0 +1,135 @@
+package memoryos
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func newTestQuotas(b *testBackends, policy QuotaPolicy) *QuotaEnforcer {
+	quotas := NewQuotaEnforcer(coreMemory{b.memory}, b.state)
+	quotas.SetPolicy(policy)
+	return quotas
+}
+
+func TestQuotaCountsAcrossInstances(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	policy := QuotaPolicy{Agent: Quota{MaxMemories: 3}}
+	one, two := newTestQuotas(b, policy), newTestQuotas(b, policy)
+
+	// Memories stored before the counters existed are counted when they
+	// are seeded
+	b.memory.StoreMemory(ctx, &Memory{AgentID: "a", Type: MemoryTypeSemantic, Content: "old"})
+
+	if err := one.StoreMemory(ctx, &Memory{AgentID: "a", Type: MemoryTypeSemantic, Content: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := two.StoreMemory(ctx, &Memory{AgentID: "a", Type: MemoryTypeSemantic, Content: "y"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := one.StoreMemory(ctx, &Memory{AgentID: "a", Type: MemoryTypeSemantic, Content: "z"}); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("fourth memory: %v", err)
+	}
+
+	// A delete frees a slot for every instance
+	memories, _ := b.memory.SearchMemories(ctx, "a", "", 0)
+	if err := one.DeleteMemory(ctx, "a", memories[0].Type, memories[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := two.StoreMemory(ctx, &Memory{AgentID: "a", Type: MemoryTypeSemantic, Content: "z"}); err != nil {
+		t.Fatalf("store after delete: %v", err)
+	}
+	if n, _ := b.state.Get(ctx, quotaKey("memories", agentQuotaScope("a"))); n != "3" {
+		t.Fatalf("agent counter = %s", n)
+	}
+}
+
+func TestQuotaChargesUpdates(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	quotas := newTestQuotas(b, QuotaPolicy{Tenant: Quota{MaxTokens: 10}})
+
+	memory := &Memory{AgentID: "a", Type: MemoryTypeSemantic, Content: strings.Repeat("x", 16)}
+	for _, m := range []*Memory{memory, {AgentID: "b", Type: MemoryTypeSemantic, Content: strings.Repeat("y", 16)}} {
+		if err := quotas.StoreMemory(ctx, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 4 + 4 tokens stored; growing one memory to 8 takes the tenant to 12
+	grown := *memory
+	grown.Content = strings.Repeat("x", 32)
+	if err := quotas.UpdateMemory(ctx, &grown); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("update past the token quota: %v", err)
+	}
+	shrunk := *memory
+	shrunk.Content = "x"
+	if err := quotas.UpdateMemory(ctx, &shrunk); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := b.state.Get(ctx, quotaKey("tokens", tenantQuotaScope)); n != "5" {
+		t.Fatalf("tenant tokens = %s", n)
+	}
+}
+
+func TestQuotaReleasesFailedWrites(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	quotas := newTestQuotas(b, QuotaPolicy{Agent: Quota{MaxMemories: 1}})
+
+	b.memory.fail = errors.New("disk full")
+	if err := quotas.StoreMemory(ctx, &Memory{AgentID: "a", Type: MemoryTypeSemantic, Content: "x"}); err == nil {
+		t.Fatal("store succeeded with the backend down")
+	}
+	b.memory.fail = nil
+	if err := quotas.StoreMemory(ctx, &Memory{AgentID: "a", Type: MemoryTypeSemantic, Content: "x"}); err != nil {
+		t.Fatalf("failed store kept its reservation: %v", err)
+	}
+
+	errs := quotas.StoreMemories(ctx, []*Memory{
+		{AgentID: "b", Type: MemoryTypeSemantic, Content: "1"},
+		{AgentID: "a", Type: MemoryTypeSemantic, Content: "2"},
+	}, BatchOptions{Atomic: true})
+	if !errors.Is(errs[1], ErrQuotaExceeded) || !errors.Is(errs[0], ErrBatchAborted) {
+		t.Fatalf("errs = %v", errs)
+	}
+	if n, _ := b.state.Get(ctx, quotaKey("memories", agentQuotaScope("b"))); n != "0" {
+		t.Fatalf("aborted memory still counted: %s", n)
+	}
+}
+
+func TestQuotaStatsMatchEnforcement(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	s := newTestServer(t, b)
+	s.SetQuotas(QuotaPolicy{Agent: Quota{MaxMemories: 2}, Tenant: Quota{MaxMemories: 10}})
+	registerTestAgent(t, s, "a")
+	s.agents.Register(ctx, &Agent{ID: "a"})
+
+	s.memoryos.StoreMemory(ctx, &Memory{AgentID: "a", Type: MemoryTypeSemantic, Content: "counted"})
+	// The counters, not the backend, are what stats report
+	s.state.Delete(ctx, quotaKey("tokens", agentQuotaScope("a")))
+	s.state.IncrBy(ctx, quotaKey("tokens", agentQuotaScope("a")), 7)
+
+	stats, err := s.memoryos.GetMemoryStats(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.TotalMemories != 1 || stats.TotalTokens != 7 || stats.ByType[string(MemoryTypeSemantic)] != 1 || stats.Quota[0].Used != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	tenant, err := s.tenantStats(ctx)
+	if err != nil || tenant.TotalMemories != 1 || tenant.Quota[0].Used != 1 {
+		t.Fatalf("tenant stats = %+v, %v", tenant, err)
+	}
+
+	// A purge goes through the enforcer and gives the usage back
+	if _, err := s.agents.Deregister(ctx, "a", true); err != nil {
+		t.Fatal(err)
+	}
+	if tenant, _ := s.tenantStats(ctx); tenant.TotalMemories != 0 {
+		t.Fatalf("tenant stats after purge = %+v", tenant)
+	}
+}
@@ -0,0 +1,295 @@
+package memoryos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// EndpointClass groups endpoints that share a rate limit
+type EndpointClass string
+
+const (
+	ClassRead         EndpointClass = "read"
+	ClassWrite        EndpointClass = "write"
+	ClassSearch       EndpointClass = "search"
+	ClassContext      EndpointClass = "context"
+	ClassConsolidate  EndpointClass = "consolidate"
+	ClassVectorSearch EndpointClass = "vector_search"
+)
+
+// ErrRateLimited is returned when a caller has used up its request budget
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// limiterIdleTTL is how long an unused per-caller limiter is kept
+const limiterIdleTTL = 10 * time.Minute
+
+// RateLimit is a token bucket: RPS tokens are added per second up to
+// Burst. A zero RPS means unlimited.
+type RateLimit struct {
+	RPS   float64 `json:"rps"`
+	Burst int     `json:"burst"`
+}
+
+// RateLimitConfig holds the limit of each endpoint class
+type RateLimitConfig map[EndpointClass]RateLimit
+
+// DefaultRateLimits are applied per caller unless configured otherwise.
+// Consolidation and vector search are far more expensive than plain reads
+// and get much smaller buckets.
+func DefaultRateLimits() RateLimitConfig {
+	return RateLimitConfig{
+		ClassRead:         {RPS: 50, Burst: 100},
+		ClassWrite:        {RPS: 20, Burst: 40},
+		ClassSearch:       {RPS: 10, Burst: 20},
+		ClassContext:      {RPS: 5, Burst: 10},
+		ClassConsolidate:  {RPS: 0.2, Burst: 1},
+		ClassVectorSearch: {RPS: 2, Burst: 5},
+	}
+}
+
+// routeClasses assigns routes that are not plain reads and writes to their
+// class, by the pattern the request was routed to. A key of the form
+// "METHOD pattern" applies to that method only. No route is charged to
+// ClassConsolidate or ClassVectorSearch yet; consolidation and vector
+// search endpoints are listed here when they are added.
+var routeClasses = map[string]EndpointClass{
+	"/memory/search":   ClassSearch,
+	"/team/search":     ClassSearch,
+	"/context":         ClassContext,
+	"/team/context":    ClassContext,
+	"/memory/transfer": ClassWrite,
+	"/v1/transfers":    ClassWrite,
+
+	"GET /v1/agents/{agent}/memories": ClassSearch,
+	"/v1/agents/{agent}/context":      ClassContext,
+	"/v1/teams/{team}/memories":       ClassSearch,
+	"/v1/teams/{team}/context":        ClassContext,
+}
+
+// classify returns the endpoint class of a request routed to pattern.
+// Only the pattern is looked at, never the path, so path segments a
+// client chooses, such as IDs, cannot change the class.
+func classify(method, pattern string) EndpointClass {
+	if class, ok := routeClasses[method+" "+pattern]; ok {
+		return class
+	}
+	if class, ok := routeClasses[pattern]; ok {
+		return class
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return ClassRead
+	default:
+		return ClassWrite
+	}
+}
+
+type callerLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// RateLimiter keeps a token bucket per caller and endpoint class. Callers
+// are told apart by their authenticated identity (API key or JWT subject),
+// and unauthenticated callers by address. The address is the connection's
+// remote address, so clients behind one proxy or NAT share a bucket,
+// unless the connection comes from a trusted proxy: then it is the client
+// address that proxy put in X-Forwarded-For.
+type RateLimiter struct {
+	mu        sync.Mutex
+	config    RateLimitConfig
+	proxies   []*net.IPNet
+	limiters  map[string]*callerLimiter
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewRateLimiter creates a rate limiter with the given limits
+func NewRateLimiter(config RateLimitConfig) *RateLimiter {
+	return &RateLimiter{
+		config:   config,
+		limiters: make(map[string]*callerLimiter),
+		now:      time.Now,
+	}
+}
+
+// SetConfig replaces the limits; existing buckets are rebuilt on next use
+func (l *RateLimiter) SetConfig(config RateLimitConfig) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.config = config
+	l.limiters = make(map[string]*callerLimiter)
+}
+
+// SetTrustedProxies sets the proxies, as CIDRs or single IPs, whose
+// X-Forwarded-For header names the client of an unauthenticated request
+func (l *RateLimiter) SetTrustedProxies(proxies []string) error {
+	nets := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return errorf(ErrInvalid, "invalid proxy address: %q", proxy)
+			}
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			proxy = fmt.Sprintf("%s/%d", proxy, bits)
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return errorf(ErrInvalid, "invalid proxy network: %q", proxy)
+		}
+		nets = append(nets, ipNet)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.proxies = nets
+	return nil
+}
+
+// trusted reports whether addr is one of the trusted proxies. Callers hold
+// l.mu.
+func (l *RateLimiter) trusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, proxy := range l.proxies {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAddr is the address an unauthenticated request is charged to.
+// X-Forwarded-For is read from the right, past every trusted proxy, so a
+// client cannot choose its address by sending the header itself.
+func (l *RateLimiter) clientAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.trusted(host) {
+		return host
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		host = hop
+		if !l.trusted(hop) {
+			break
+		}
+	}
+	return host
+}
+
+type clientAddrKey struct{}
+
+// withClientAddr records the address an unauthenticated request is charged
+// to, for callerKey
+func (l *RateLimiter) withClientAddr(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), clientAddrKey{}, l.clientAddr(r)))
+}
+
+// callerKey identifies who a request is charged to, and whose idempotency
+// keys it shares. The X-Agent-ID and X-Tenant-ID headers are not used:
+// without credentials a client could rotate them to get a fresh bucket on
+// every request.
+func callerKey(r *http.Request) string {
+	if id, ok := IdentityFromContext(r.Context()); ok {
+		return id.TenantID + "|" + id.Method + ":" + id.Subject
+	}
+	if addr, ok := r.Context().Value(clientAddrKey{}).(string); ok {
+		return "|addr:" + addr
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "|addr:" + host
+}
+
+func (l *RateLimiter) limiter(key string, class EndpointClass) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.lastSweep) > time.Minute {
+		for k, c := range l.limiters {
+			if now.Sub(c.lastSeen) > limiterIdleTTL {
+				delete(l.limiters, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	k := key + "|" + string(class)
+	c, ok := l.limiters[k]
+	if !ok {
+		limit := rate.Inf
+		cfg := l.config[class]
+		if cfg.RPS > 0 {
+			limit = rate.Limit(cfg.RPS)
+		}
+		burst := cfg.Burst
+		if burst < 1 {
+			burst = 1
+		}
+		c = &callerLimiter{limiter: rate.NewLimiter(limit, burst)}
+		l.limiters[k] = c
+	}
+	c.lastSeen = now
+	return c.limiter
+}
+
+// Allow takes a token for the request's caller and class. When the bucket
+// is empty it returns how long to wait before retrying.
+func (l *RateLimiter) Allow(r *http.Request, class EndpointClass) (bool, time.Duration) {
+	reservation := l.limiter(callerKey(l.withClientAddr(r)), class).Reserve()
+	if !reservation.OK() {
+		return false, time.Second
+	}
+	if delay := reservation.Delay(); delay > 0 {
+		reservation.Cancel()
+		return false, delay
+	}
+	return true, 0
+}
+
+// Middleware answers requests over their limit with 429 and Retry-After.
+// pattern returns the route pattern a request will be served by, which
+// decides its class.
+func (l *RateLimiter) Middleware(pattern func(r *http.Request) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = l.withClientAddr(r)
+		class := classify(r.Method, pattern(r))
+		if ok, wait := l.Allow(r, class); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, fmt.Errorf("%w for %s requests", ErrRateLimited, class), http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
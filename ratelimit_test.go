@@ -0,0 +1,96 @@
+package memoryos
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClassifyByRoutePattern(t *testing.T) {
+	s := newTestServer(t, newTestBackends())
+	cases := []struct {
+		method, target string
+		want           EndpointClass
+	}{
+		{http.MethodGet, "/v1/agents/a/memories", ClassSearch},
+		{http.MethodPost, "/v1/agents/a/memories", ClassWrite},
+		{http.MethodGet, "/v1/teams/t/context", ClassContext},
+		{http.MethodGet, "/context?agent_id=a", ClassContext},
+		{http.MethodPost, "/memory/transfer", ClassWrite},
+		// IDs that look like a classified route do not change the class
+		{http.MethodGet, "/v1/agents/a/memories/memories", ClassRead},
+		{http.MethodGet, "/v1/agents/a/skills/context", ClassRead},
+		{http.MethodGet, "/v1/agents/context/stats", ClassRead},
+		{http.MethodGet, "/nowhere/memories", ClassRead},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(c.method, c.target, nil)
+		if got := classify(r.Method, s.routePattern(r)); got != c.want {
+			t.Errorf("%s %s: class %q, want %q", c.method, c.target, got, c.want)
+		}
+	}
+}
+
+func TestAnonymousCallersKeyedByAddress(t *testing.T) {
+	limiter := NewRateLimiter(RateLimitConfig{ClassWrite: {RPS: 0.001, Burst: 1}})
+	request := func(agentID, tenantID string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/memory", nil)
+		r = r.WithContext(context.WithValue(r.Context(), agentHeaderKey{}, true))
+		r.RemoteAddr = "10.0.0.1:1234"
+		r.Header.Set(AgentIDHeader, agentID)
+		r.Header.Set(TenantHeader, tenantID)
+		return r
+	}
+
+	if ok, _ := limiter.Allow(request("a", "t1"), ClassWrite); !ok {
+		t.Fatal("first request limited")
+	}
+	// Rotating the agent and tenant headers must not reset the bucket
+	if ok, _ := limiter.Allow(request("b", "t2"), ClassWrite); ok {
+		t.Fatal("rotated headers got a fresh bucket")
+	}
+
+	other := request("a", "t1")
+	other.RemoteAddr = "10.0.0.2:1234"
+	if ok, _ := limiter.Allow(other, ClassWrite); !ok {
+		t.Fatal("another address shares the bucket")
+	}
+}
+
+func TestTrustedProxiesForwardTheClientAddress(t *testing.T) {
+	limiter := NewRateLimiter(RateLimitConfig{ClassWrite: {RPS: 0.001, Burst: 1}})
+	if err := limiter.SetTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := limiter.SetTrustedProxies([]string{"not-an-ip"}); err == nil {
+		t.Fatal("invalid proxy accepted")
+	}
+	request := func(remote, forwarded string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/memory", nil)
+		r.RemoteAddr = remote + ":1234"
+		if forwarded != "" {
+			r.Header.Set("X-Forwarded-For", forwarded)
+		}
+		return r
+	}
+
+	if ok, _ := limiter.Allow(request("10.0.0.1", "203.0.113.7"), ClassWrite); !ok {
+		t.Fatal("first request limited")
+	}
+	// The same client through another trusted hop shares the bucket, and
+	// an address it prepends itself is ignored
+	if ok, _ := limiter.Allow(request("10.0.0.2", "1.2.3.4, 203.0.113.7, 192.168.1.1"), ClassWrite); ok {
+		t.Fatal("forwarded client got a fresh bucket")
+	}
+	if ok, _ := limiter.Allow(request("10.0.0.1", "203.0.113.8"), ClassWrite); !ok {
+		t.Fatal("another forwarded client shares the bucket")
+	}
+	// An untrusted peer cannot pick its address
+	if ok, _ := limiter.Allow(request("198.51.100.1", "203.0.113.9"), ClassWrite); !ok {
+		t.Fatal("untrusted peer limited")
+	}
+	if ok, _ := limiter.Allow(request("198.51.100.1", "203.0.113.10"), ClassWrite); ok {
+		t.Fatal("untrusted peer chose a fresh bucket with X-Forwarded-For")
+	}
+}
@@ -0,0 +1,163 @@
+package memoryos
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Router dispatches requests on path patterns such as
+// /v1/agents/{agent}/memories/{id} and on method. Literal segments take
+// precedence over parameters, so /v1/x/batch wins over /v1/x/{id}.
+type Router struct {
+	routes []*route
+}
+
+type route struct {
+	pattern  string
+	segments []string
+	literals int
+	handlers map[string]http.Handler
+}
+
+type pathParamsKey struct{}
+
+// NewRouter creates an empty router
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Handle registers a handler for a method and path pattern. A segment
+// written as {name} matches any single path segment, which handlers read
+// with PathParam.
+func (rt *Router) Handle(method, pattern string, handler http.Handler) {
+	for _, existing := range rt.routes {
+		if existing.pattern == pattern {
+			existing.handlers[method] = handler
+			return
+		}
+	}
+
+	segments := splitPath(pattern)
+	literals := 0
+	for _, segment := range segments {
+		if !isPathParam(segment) {
+			literals++
+		}
+	}
+	rt.routes = append(rt.routes, &route{
+		pattern:  pattern,
+		segments: segments,
+		literals: literals,
+		handlers: map[string]http.Handler{method: handler},
+	})
+	sort.SliceStable(rt.routes, func(i, j int) bool {
+		return rt.routes[i].literals > rt.routes[j].literals
+	})
+}
+
+// HandleFunc registers a handler function for a method and path pattern
+func (rt *Router) HandleFunc(method, pattern string, handler http.HandlerFunc) {
+	rt.Handle(method, pattern, handler)
+}
+
+// ServeHTTP routes the request, answering 404 when no pattern matches and
+// 405 with an Allow header when the path matches but the method does not
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := splitPath(r.URL.EscapedPath())
+
+	var allowed *route
+	for _, route := range rt.routes {
+		params, ok := route.match(segments)
+		if !ok {
+			continue
+		}
+		handler, ok := route.handlers[r.Method]
+		if !ok && r.Method == http.MethodHead {
+			handler, ok = route.handlers[http.MethodGet]
+		}
+		if !ok {
+			if allowed == nil {
+				allowed = route
+			}
+			continue
+		}
+		ctx := context.WithValue(r.Context(), pathParamsKey{}, params)
+		handler.ServeHTTP(w, r.WithContext(ctx))
+		return
+	}
+
+	if allowed != nil {
+		methods := make([]string, 0, len(allowed.handlers))
+		for method := range allowed.handlers {
+			methods = append(methods, method)
+		}
+		sort.Strings(methods)
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	httpError(w, "no route for "+r.URL.Path, http.StatusNotFound)
+}
+
+// Pattern returns the pattern ServeHTTP would route the request to, or ""
+// when it would answer 404 or 405
+func (rt *Router) Pattern(r *http.Request) string {
+	segments := splitPath(r.URL.EscapedPath())
+	for _, route := range rt.routes {
+		if _, ok := route.match(segments); !ok {
+			continue
+		}
+		if _, ok := route.handlers[r.Method]; ok {
+			return route.pattern
+		}
+		if _, ok := route.handlers[http.MethodGet]; ok && r.Method == http.MethodHead {
+			return route.pattern
+		}
+	}
+	return ""
+}
+
+// match compares escaped path segments against the pattern and returns
+// the unescaped parameter values
+func (rt *route) match(segments []string) (map[string]string, bool) {
+	if len(segments) != len(rt.segments) {
+		return nil, false
+	}
+	params := make(map[string]string)
+	for i, segment := range rt.segments {
+		if isPathParam(segment) {
+			value, err := url.PathUnescape(segments[i])
+			if err != nil || value == "" {
+				return nil, false
+			}
+			params[segment[1:len(segment)-1]] = value
+			continue
+		}
+		if segment != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// PathParam returns a path parameter matched by the router, or "" when the
+// request was not routed through a pattern with that parameter
+func PathParam(r *http.Request, name string) string {
+	params, _ := r.Context().Value(pathParamsKey{}).(map[string]string)
+	return params[name]
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+func isPathParam(segment string) bool {
+	return len(segment) > 2 && segment[0] == '{' && segment[len(segment)-1] == '}'
+}
@@ -0,0 +1,294 @@
+package memoryos
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// Scope is the visibility level of shared memory
+type Scope string
+
+const (
+	ScopeTeam         Scope = "team"
+	ScopeOrganization Scope = "organization"
+	ScopeGlobal       Scope = "global"
+)
+
+// orgNamespacePrefix and globalNamespace are the reserved shared memory
+// namespaces for organization and global scope; team scope uses the team ID.
+// Team IDs may not contain ':' or be "global", so no team namespace can
+// reach into organization, global or internal namespaces, which all
+// contain a ':' or are "global".
+const (
+	orgNamespacePrefix = "org:"
+	globalNamespace    = "global"
+)
+
+// ValidateTeamID rejects team IDs whose namespace would collide with a
+// reserved namespace
+func ValidateTeamID(teamID string) error {
+	if teamID == "" || teamID == globalNamespace || strings.ContainsAny(teamID, ":/") {
+		return errorf(ErrInvalid, "invalid team id %q: must not be %q or contain ':' or '/'", teamID, globalNamespace)
+	}
+	return nil
+}
+
+// ValidateOrganizationID rejects organization IDs containing separators
+func ValidateOrganizationID(orgID string) error {
+	if orgID == "" || strings.ContainsAny(orgID, ":/") {
+		return errorf(ErrInvalid, "invalid organization id %q: must not contain ':' or '/'", orgID)
+	}
+	return nil
+}
+
+// ScopeRef addresses one shared memory namespace
+type ScopeRef struct {
+	Scope Scope  `json:"scope"`
+	ID    string `json:"id,omitempty"`
+}
+
+// TeamScope addresses a team's shared memory
+func TeamScope(teamID string) ScopeRef {
+	return ScopeRef{Scope: ScopeTeam, ID: teamID}
+}
+
+// OrganizationScope addresses an organization's shared memory
+func OrganizationScope(orgID string) ScopeRef {
+	return ScopeRef{Scope: ScopeOrganization, ID: orgID}
+}
+
+// GlobalScope addresses shared memory visible to every agent
+func GlobalScope() ScopeRef {
+	return ScopeRef{Scope: ScopeGlobal}
+}
+
+// ParseScope builds a ScopeRef from a scope name and ID. An empty scope
+// name means team scope.
+func ParseScope(scope, id string) (ScopeRef, error) {
+	switch strings.ToLower(scope) {
+	case "", string(ScopeTeam):
+		if id == "" {
+			return ScopeRef{}, errorf(ErrInvalid, "team scope requires an id")
+		}
+		if err := ValidateTeamID(id); err != nil {
+			return ScopeRef{}, err
+		}
+		return TeamScope(id), nil
+	case "org", string(ScopeOrganization):
+		if id == "" {
+			return ScopeRef{}, errorf(ErrInvalid, "organization scope requires an id")
+		}
+		if err := ValidateOrganizationID(id); err != nil {
+			return ScopeRef{}, err
+		}
+		return OrganizationScope(id), nil
+	case string(ScopeGlobal):
+		return GlobalScope(), nil
+	default:
+		return ScopeRef{}, errorf(ErrInvalid, "unknown scope: %s", scope)
+	}
+}
+
+// Namespace is the key under which the scope's values are stored
+func (r ScopeRef) Namespace() string {
+	switch r.Scope {
+	case ScopeOrganization:
+		return orgNamespacePrefix + r.ID
+	case ScopeGlobal:
+		return globalNamespace
+	default:
+		return r.ID
+	}
+}
+
+// ScopeFromNamespace reverses Namespace
+func ScopeFromNamespace(namespace string) ScopeRef {
+	switch {
+	case namespace == globalNamespace:
+		return GlobalScope()
+	case strings.HasPrefix(namespace, orgNamespacePrefix):
+		return OrganizationScope(strings.TrimPrefix(namespace, orgNamespacePrefix))
+	default:
+		return TeamScope(namespace)
+	}
+}
+
+func (r ScopeRef) String() string {
+	if r.ID == "" {
+		return string(r.Scope)
+	}
+	return string(r.Scope) + "/" + r.ID
+}
+
+// ScopeDirectory records which organization each team belongs to and the
+// default permission agents get in each scope when a key's ACL does not
+// name them. Both are kept in the StateBackend, so every server instance
+// sees the same organizations and defaults and they survive restarts.
+type ScopeDirectory struct {
+	state StateBackend
+}
+
+// defaultScopePermissions apply to scopes whose default was never changed:
+// team members may write, organization members and all agents may only read
+var defaultScopePermissions = map[Scope]string{
+	ScopeTeam:         PermissionWrite,
+	ScopeOrganization: PermissionRead,
+	ScopeGlobal:       PermissionRead,
+}
+
+// NewScopeDirectory creates a directory on a state backend
+func NewScopeDirectory(state StateBackend) *ScopeDirectory {
+	return &ScopeDirectory{state: state}
+}
+
+// State keys of a team's organization, an organization's teams and a
+// scope's default permission
+func teamOrganizationKey(teamID string) string {
+	return "team-org:" + teamID
+}
+
+func organizationTeamsKey(orgID string) string {
+	return "org-teams:" + orgID
+}
+
+func scopeDefaultKey(scope Scope) string {
+	return "scope-default:" + string(scope)
+}
+
+// SetTeamOrganization places a team in an organization; an empty orgID
+// removes it
+func (d *ScopeDirectory) SetTeamOrganization(ctx context.Context, teamID, orgID string) error {
+	for attempt := 0; attempt < sharedWriteAttempts; attempt++ {
+		previous, err := d.state.Get(ctx, teamOrganizationKey(teamID))
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+
+		var ok bool
+		if orgID == "" {
+			if previous == "" {
+				return nil
+			}
+			ok, err = d.state.CompareAndDelete(ctx, teamOrganizationKey(teamID), previous)
+		} else {
+			ok, err = d.state.CompareAndSwap(ctx, teamOrganizationKey(teamID), previous, orgID, 0)
+		}
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		if previous != "" && previous != orgID {
+			if err := d.state.RemoveMember(ctx, organizationTeamsKey(previous), teamID); err != nil {
+				return err
+			}
+		}
+		if orgID != "" {
+			return d.state.AddMember(ctx, organizationTeamsKey(orgID), teamID)
+		}
+		return nil
+	}
+	return errorf(ErrConflict, "organization of team %s keeps changing", teamID)
+}
+
+// OrganizationOf returns the organization a team belongs to
+func (d *ScopeDirectory) OrganizationOf(ctx context.Context, teamID string) (string, bool, error) {
+	orgID, err := d.state.Get(ctx, teamOrganizationKey(teamID))
+	if errors.Is(err, ErrNotFound) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return orgID, true, nil
+}
+
+// TeamsIn lists the teams that belong to an organization
+func (d *ScopeDirectory) TeamsIn(ctx context.Context, orgID string) ([]string, error) {
+	teams, err := d.state.Members(ctx, organizationTeamsKey(orgID))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(teams)
+	return teams, nil
+}
+
+// SetDefault changes the default permission for a scope
+func (d *ScopeDirectory) SetDefault(ctx context.Context, scope Scope, permission string) error {
+	if _, ok := defaultScopePermissions[scope]; !ok {
+		return errorf(ErrInvalid, "unknown scope: %s", scope)
+	}
+	switch permission {
+	case PermissionRead, PermissionWrite, PermissionAdmin, PermissionNone:
+	default:
+		return errorf(ErrInvalid, "unknown permission: %s", permission)
+	}
+
+	for attempt := 0; attempt < sharedWriteAttempts; attempt++ {
+		current, err := d.state.Get(ctx, scopeDefaultKey(scope))
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		ok, err := d.state.CompareAndSwap(ctx, scopeDefaultKey(scope), current, permission, 0)
+		if err != nil || ok {
+			return err
+		}
+	}
+	return errorf(ErrConflict, "default of scope %s keeps changing", scope)
+}
+
+// Default returns the default permission for a scope
+func (d *ScopeDirectory) Default(ctx context.Context, scope Scope) (string, error) {
+	perm, err := d.state.Get(ctx, scopeDefaultKey(scope))
+	if errors.Is(err, ErrNotFound) {
+		if perm, ok := defaultScopePermissions[scope]; ok {
+			return perm, nil
+		}
+		return PermissionNone, nil
+	}
+	return perm, err
+}
+
+// Chain returns the scopes a read falls back through: team, then its
+// organization, then global
+func (d *ScopeDirectory) Chain(ctx context.Context, ref ScopeRef) ([]ScopeRef, error) {
+	chain := []ScopeRef{ref}
+	if ref.Scope == ScopeTeam {
+		orgID, ok, err := d.OrganizationOf(ctx, ref.ID)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			chain = append(chain, OrganizationScope(orgID))
+		}
+	}
+	if ref.Scope != ScopeGlobal {
+		chain = append(chain, GlobalScope())
+	}
+	return chain, nil
+}
+
+// Resolve reads a key from the first scope in the chain that has it. Only
+// a missing key falls through to the next scope; any other failure is
+// returned as is.
+func (s *SharedStore) Resolve(ctx context.Context, chain []ScopeRef, key string) (*SharedMemory, ScopeRef, error) {
+	var lastErr error
+	for _, ref := range chain {
+		entry, err := s.Get(ctx, ref.Namespace(), key)
+		if err == nil {
+			return entry, ref, nil
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return nil, ScopeRef{}, err
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = errorf(ErrNotFound, "no scopes to resolve %s", key)
+	}
+	return nil, ScopeRef{}, lastErr
+}
@@ -0,0 +1,133 @@
+package memoryos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTeamIDsCannotReachReservedNamespaces(t *testing.T) {
+	s := newTestServer(t, newTestBackends())
+	registerTestAgent(t, s, "a")
+
+	for _, id := range []string{"org:acme", "global", "memoryos:agents", "a/b"} {
+		body := `{"id": "` + id + `", "name": "x"}`
+		if rec := serve(t, s, http.MethodPost, "/team", "a", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("POST /team %s: status %d", id, rec.Code)
+		}
+		if rec := serve(t, s, http.MethodPost, "/v1/teams", "a", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("POST /v1/teams %s: status %d", id, rec.Code)
+		}
+		if _, err := ParseScope("team", id); !errors.Is(err, ErrInvalid) {
+			t.Errorf("team scope %s: %v", id, err)
+		}
+	}
+
+	if err := s.roster.Create(context.Background(), &Team{ID: "global"}, "a"); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("roster for team global: %v", err)
+	}
+	if rec := serve(t, s, http.MethodPost, "/team", "a", `{"id": "acme", "name": "x"}`); rec.Code != http.StatusOK {
+		t.Fatalf("valid team: status %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestScopeNamespacesAreDistinct(t *testing.T) {
+	namespaces := map[string]ScopeRef{}
+	for _, ref := range []ScopeRef{TeamScope("acme"), OrganizationScope("acme"), GlobalScope()} {
+		ns := ref.Namespace()
+		if other, ok := namespaces[ns]; ok {
+			t.Fatalf("%s and %s share namespace %s", ref, other, ns)
+		}
+		namespaces[ns] = ref
+		if back := ScopeFromNamespace(ns); back != ref {
+			t.Fatalf("%s round-trips to %s", ref, back)
+		}
+	}
+}
+
+func TestResolveStopsOnBackendError(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	store := newTestSharedStore(b)
+	store.Create(ctx, "global", "k", json.RawMessage(`"global"`), "a")
+
+	down := errors.New("connection refused")
+	b.shared.setFail(down)
+	chain := []ScopeRef{TeamScope("team"), GlobalScope()}
+	if _, _, err := store.Resolve(ctx, chain, "k"); !errors.Is(err, down) {
+		t.Fatalf("resolve fell through a failing scope: %v", err)
+	}
+
+	b.shared.setFail(nil)
+	entry, ref, err := store.Resolve(ctx, chain, "k")
+	if err != nil || ref.Scope != ScopeGlobal || entry.Content != `"global"` {
+		t.Fatalf("resolve = %v %v %v", entry, ref, err)
+	}
+}
+
+func TestScopeDirectoryIsSharedAcrossInstances(t *testing.T) {
+	ctx := context.Background()
+	state := NewLocalState()
+	one, two := NewScopeDirectory(state), NewScopeDirectory(state)
+
+	if err := one.SetTeamOrganization(ctx, "red", "acme"); err != nil {
+		t.Fatal(err)
+	}
+	one.SetTeamOrganization(ctx, "blue", "acme")
+	if err := one.SetTeamOrganization(ctx, "blue", "other"); err != nil {
+		t.Fatal(err)
+	}
+	if teams, _ := two.TeamsIn(ctx, "acme"); !reflect.DeepEqual(teams, []string{"red"}) {
+		t.Fatalf("acme teams = %v", teams)
+	}
+	chain, err := two.Chain(ctx, TeamScope("red"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(chain, []ScopeRef{TeamScope("red"), OrganizationScope("acme"), GlobalScope()}) {
+		t.Fatalf("chain = %v", chain)
+	}
+	one.SetTeamOrganization(ctx, "red", "")
+	if _, ok, _ := two.OrganizationOf(ctx, "red"); ok {
+		t.Fatal("team still in an organization after removal")
+	}
+
+	if err := one.SetDefault(ctx, ScopeGlobal, PermissionNone); err != nil {
+		t.Fatal(err)
+	}
+	if perm, _ := two.Default(ctx, ScopeGlobal); perm != PermissionNone {
+		t.Fatalf("global default = %s", perm)
+	}
+	if perm, _ := two.Default(ctx, ScopeTeam); perm != PermissionWrite {
+		t.Fatalf("team default = %s", perm)
+	}
+	if err := one.SetDefault(ctx, ScopeTeam, "everything"); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("unknown permission: %v", err)
+	}
+}
+
+func TestScopeDefaultRouteRequiresAdmin(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	s := newTestServer(t, b)
+	registerTestAgent(t, s, "a")
+	s.manager.RegisterAgent(ctx, &Agent{ID: "root", Name: "root", Role: RoleAdmin, Permissions: []string{PermissionWrite}})
+
+	if rec := serve(t, s, http.MethodPut, "/v1/scopes/global/default?permission=write", "a", ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("non-admin changed a default: %d %s", rec.Code, rec.Body)
+	}
+	if rec := serve(t, s, http.MethodPut, "/v1/scopes/global/default?permission=write", "root", ""); rec.Code != http.StatusOK {
+		t.Fatalf("admin change: %d %s", rec.Code, rec.Body)
+	}
+	rec := serve(t, s, http.MethodGet, "/scope/default?scope=global", "root", "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"permission":"write"`) {
+		t.Fatalf("read default: %d %s", rec.Code, rec.Body)
+	}
+	if rec := serve(t, s, http.MethodGet, "/v1/scopes/galaxy/default", "root", ""); rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown scope: %d %s", rec.Code, rec.Body)
+	}
+}
@@ -3,45 +3,429 @@ package memoryos
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 // Server represents the MemoryOS HTTP server
 type Server struct {
-	memoryos *MemoryOS
-	manager  *SharedMemoryManager
-	addr     string
+	memoryos    MemoryBackend
+	manager     SharedBackend
+	skillIndex  SkillBackend
+	state       StateBackend
+	skills      *SkillRegistry
+	shared      *SharedStore
+	locks       *LockManager
+	authz       *Authorizer
+	scopes      *ScopeDirectory
+	roster      *TeamRoster
+	agents      *AgentDirectory
+	transfer    *MemoryTransfer
+	views       *TeamMemoryView
+	contexts    *ContextBuilder
+	quotas      *QuotaEnforcer
+	validator   *MemoryValidator
+	dedup       *Deduplicator
+	tenants     *TenantRegistry
+	auth        *Authenticator
+	limits      *RateLimiter
+	idempotency *IdempotencyStore
+	mux         *http.ServeMux
+	v1          *Router
+	timeouts    Timeouts
+	mu          sync.Mutex
+	httpServer  *http.Server
+	closing     chan struct{}
+	closeOnce   sync.Once
+	tenantID    string
+	addr        string
 }
 
-// NewServer creates a new MemoryOS server
-func NewServer(memoryos *MemoryOS, addr string) *Server {
+// NewServer creates a new MemoryOS server. Its state is kept on the Redis
+// server named by config, the one memoryos was opened with.
+func NewServer(memoryos *MemoryOS, config *MemoryOSConfig, addr string) *Server {
+	storage := &tenantStorage{
+		memory: coreMemory{memoryos},
+		shared: coreShared{NewSharedMemoryManager(memoryos)},
+		skills: coreSkills{NewSkillIndex(memoryos)},
+		state:  newConfigState(config),
+	}
+	s := newTenantServer(storage, DefaultTenantID)
+	s.auth = newAuthenticator()
+	s.tenants = NewTenantRegistry(s, storage)
+	s.limits = NewRateLimiter(DefaultRateLimits())
+	s.idempotency = NewIdempotencyStore(storage.state, DefaultIdempotencyWindow)
+	s.mux = http.NewServeMux()
+	s.timeouts = DefaultTimeouts()
+	s.addr = addr
+	s.routes()
+	return s
+}
+
+// Timeouts bound how long the server waits on a client. WriteTimeout does
+// not apply to event streams.
+type Timeouts struct {
+	ReadHeader time.Duration
+	Read       time.Duration
+	Write      time.Duration
+	Idle       time.Duration
+}
+
+// DefaultTimeouts returns the timeouts a new server starts with
+func DefaultTimeouts() Timeouts {
+	return Timeouts{
+		ReadHeader: 10 * time.Second,
+		Read:       30 * time.Second,
+		Write:      60 * time.Second,
+		Idle:       120 * time.Second,
+	}
+}
+
+// SetTimeouts changes the timeouts used by Start
+func (s *Server) SetTimeouts(timeouts Timeouts) {
+	s.timeouts = timeouts
+}
+
+// newServer wires the server's components on top of a storage backend and
+// the state shared by every server instance. Tenant servers are built the
+// same way on tenant-prefixed backends.
+func newServer(backend MemoryBackend, manager SharedBackend, skillIndex SkillBackend, state StateBackend) *Server {
+	quotas := NewQuotaEnforcer(backend, state)
+	dedup := NewDeduplicator(quotas, state)
+	validator := NewMemoryValidator(dedup, DefaultValidationLimits())
+	memoryos := MemoryBackend(validator)
+	locks := NewLockManager(state)
+	shared := NewSharedStore(manager, state, locks, NewChangeFeed(state, DefaultFeedRetention))
+	scopes := NewScopeDirectory(state)
+	roster := NewTeamRoster(manager, state, shared)
+	skills := NewSkillRegistry(skillIndex, state)
+	agents := NewAgentDirectory(manager, state, memoryos, roster, skills, locks)
+	authz := NewAuthorizer(manager, shared, scopes, roster, agents)
+	quotas.agents = agents.IDs
 	return &Server{
-		memoryos: memoryos,
-		manager:  NewSharedMemoryManager(memoryos),
-		addr:     addr,
+		memoryos:   memoryos,
+		manager:    manager,
+		skillIndex: skillIndex,
+		state:      state,
+		skills:     skills,
+		shared:     shared,
+		locks:      locks,
+		authz:      authz,
+		scopes:     scopes,
+		roster:     roster,
+		agents:     agents,
+		transfer:   NewMemoryTransfer(memoryos, shared),
+		views:      NewTeamMemoryView(memoryos, roster, shared, authz),
+		contexts:   NewContextBuilder(memoryos),
+		quotas:     quotas,
+		validator:  validator,
+		dedup:      dedup,
+		closing:    make(chan struct{}),
+	}
+}
+
+// AddTenant registers an isolated tenant and the API keys that select it.
+// Only the keys' hashes are kept.
+func (s *Server) AddTenant(tenantID string, apiKeys ...string) error {
+	hashes := make([]string, len(apiKeys))
+	for i, key := range apiKeys {
+		hashes[i] = HashAPIKey(key)
+	}
+	return s.tenants.Add(tenantID, hashes...)
+}
+
+// SetAuth requires every request to authenticate with an API key or JWT.
+// Tenant keys added before or after remain valid.
+func (s *Server) SetAuth(cfg *AuthConfig) error {
+	return s.auth.configure(cfg)
+}
+
+// SetRateLimits replaces the per-caller rate limits of each endpoint class
+func (s *Server) SetRateLimits(config RateLimitConfig) {
+	s.limits.SetConfig(config)
+}
+
+// SetIdempotencyWindow sets how long Idempotency-Key responses are
+// remembered; zero turns idempotency keys off
+func (s *Server) SetIdempotencyWindow(window time.Duration) {
+	s.idempotency.SetWindow(window)
+}
+
+// SetValidationLimits sets the memory validation limits of every tenant
+func (s *Server) SetValidationLimits(limits ValidationLimits) {
+	s.tenants.SetValidationLimits(limits)
+}
+
+// SetDedup sets the deduplication policies of every tenant
+func (s *Server) SetDedup(config DedupConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	s.tenants.SetDedup(config)
+	return nil
+}
+
+// SetQuotas sets the per-agent and per-tenant quotas of the default tenant
+func (s *Server) SetQuotas(policy QuotaPolicy) {
+	s.quotas.SetPolicy(policy)
+}
+
+// routes registers every endpoint on the server's own mux
+func (s *Server) routes() {
+	s.mux.HandleFunc("/health", s.tenantRoute((*Server).handleHealth))
+	s.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		httpError(w, "no route for "+r.URL.Path, http.StatusNotFound)
+	})
+
+	s.v1 = NewRouter()
+	s.v1Routes(s.v1)
+	s.mux.Handle("/v1/", s.v1)
+
+	// The unversioned query-parameter routes predate /v1 and are kept for
+	// existing clients
+	legacy := func(pattern string, handler routeHandler) {
+		s.mux.Handle(pattern, deprecated(s.tenantRoute(handler)))
+	}
+	legacy("/memory", (*Server).handleMemory)
+	legacy("/memory/search", (*Server).handleSearch)
+	legacy("/memory/transfer", (*Server).handleTransfer)
+	legacy("/context", (*Server).handleContext)
+	legacy("/agent", (*Server).handleAgent)
+	legacy("/agent/heartbeat", (*Server).handleAgentHeartbeat)
+	legacy("/team", (*Server).handleTeam)
+	legacy("/team/members", (*Server).handleTeamMembers)
+	legacy("/team/search", (*Server).handleTeamSearch)
+	legacy("/team/context", (*Server).handleTeamContext)
+	legacy("/shared", (*Server).handleShared)
+	legacy("/shared/value", (*Server).handleSharedValue)
+	legacy("/shared/lock", (*Server).handleSharedLock)
+	legacy("/shared/acl", (*Server).handleSharedACL)
+	legacy("/shared/events", (*Server).handleSharedEvents)
+	legacy("/shared/history", (*Server).handleSharedHistory)
+	legacy("/shared/restore", (*Server).handleSharedRestore)
+	legacy("/shared/crdt", (*Server).handleSharedCRDT)
+	legacy("/scope/default", (*Server).handleScopeDefault)
+	legacy("/skill", (*Server).handleSkill)
+	legacy("/skill/versions", (*Server).handleSkillVersions)
+	legacy("/skill/rollback", (*Server).handleSkillRollback)
+	legacy("/skill/tool", (*Server).handleSkillTool)
+	legacy("/stats", (*Server).handleStats)
+}
+
+// Handler returns the server's HTTP handler with authentication, rate
+// limiting and idempotency keys applied. It can be served by any http.Server or httptest.Server.
+func (s *Server) Handler() http.Handler {
+	// Rate limits run after authentication so callers are charged by identity
+	handler := s.auth.Middleware(s.limits.Middleware(s.routePattern, s.idempotency.Middleware(s.mux)))
+	return withRequestID(handler)
+}
+
+// routePattern returns the pattern a request is routed to, looking into
+// the /v1 router for versioned routes
+func (s *Server) routePattern(r *http.Request) string {
+	_, pattern := s.mux.Handler(r)
+	if pattern == "/v1/" {
+		return s.v1.Pattern(r)
+	}
+	return pattern
+}
+
+// configureFromEnv applies the MEMORYOS_* environment configuration
+func (s *Server) configureFromEnv() error {
+	if spec := os.Getenv("MEMORYOS_TENANTS"); spec != "" {
+		if err := s.tenants.Load(spec); err != nil {
+			return err
+		}
+	}
+	if spec := os.Getenv("MEMORYOS_QUOTAS"); spec != "" {
+		var policy QuotaPolicy
+		if err := json.Unmarshal([]byte(spec), &policy); err != nil {
+			return fmt.Errorf("MEMORYOS_QUOTAS: %w", err)
+		}
+		for _, tenantID := range append([]string{""}, s.tenants.Tenants()...) {
+			s.tenants.SetQuotas(tenantID, policy)
+		}
+	}
+
+	if spec := os.Getenv("MEMORYOS_VALIDATION"); spec != "" {
+		limits := DefaultValidationLimits()
+		if err := json.Unmarshal([]byte(spec), &limits); err != nil {
+			return fmt.Errorf("MEMORYOS_VALIDATION: %w", err)
+		}
+		s.SetValidationLimits(limits)
+	}
+
+	if spec := os.Getenv("MEMORYOS_DEDUP"); spec != "" {
+		var config DedupConfig
+		if err := json.Unmarshal([]byte(spec), &config); err != nil {
+			return fmt.Errorf("MEMORYOS_DEDUP: %w", err)
+		}
+		if err := s.SetDedup(config); err != nil {
+			return fmt.Errorf("MEMORYOS_DEDUP: %w", err)
+		}
+	}
+
+	if path := os.Getenv("MEMORYOS_AUTH_CONFIG"); path != "" {
+		cfg, err := LoadAuthConfig(path)
+		if err != nil {
+			return err
+		}
+		if err := s.SetAuth(cfg); err != nil {
+			return err
+		}
+	}
+
+	if spec := os.Getenv("MEMORYOS_RATE_LIMITS"); spec != "" {
+		config := DefaultRateLimits()
+		if err := json.Unmarshal([]byte(spec), &config); err != nil {
+			return fmt.Errorf("MEMORYOS_RATE_LIMITS: %w", err)
+		}
+		s.SetRateLimits(config)
+	}
+
+	if spec := os.Getenv("MEMORYOS_TRUSTED_PROXIES"); spec != "" {
+		if err := s.limits.SetTrustedProxies(strings.Split(spec, ",")); err != nil {
+			return fmt.Errorf("MEMORYOS_TRUSTED_PROXIES: %w", err)
+		}
 	}
+
+	if spec := os.Getenv("MEMORYOS_IDEMPOTENCY_WINDOW"); spec != "" {
+		window, err := time.ParseDuration(spec)
+		if err != nil {
+			return fmt.Errorf("MEMORYOS_IDEMPOTENCY_WINDOW: %w", err)
+		}
+		s.SetIdempotencyWindow(window)
+	}
+	return nil
 }
 
-// Start starts the HTTP server
+// Start starts the HTTP server and blocks until it stops. After Shutdown
+// it returns nil.
 func (s *Server) Start() error {
-	http.HandleFunc("/health", s.handleHealth)
-	http.HandleFunc("/memory", s.handleMemory)
-	http.HandleFunc("/memory/search", s.handleSearch)
-	http.HandleFunc("/context", s.handleContext)
-	http.HandleFunc("/agent", s.handleAgent)
-	http.HandleFunc("/team", s.handleTeam)
-	http.HandleFunc("/shared", s.handleShared)
-	http.HandleFunc("/skill", s.handleSkill)
-	http.HandleFunc("/stats", s.handleStats)
+	if err := s.configureFromEnv(); err != nil {
+		return err
+	}
+	if !s.auth.required {
+		log.Printf("Warning: authentication is not configured; callers are identified by %s", AgentIDHeader)
+	}
+
+	s.mu.Lock()
+	s.httpServer = &http.Server{
+		Addr:              s.addr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: s.timeouts.ReadHeader,
+		ReadTimeout:       s.timeouts.Read,
+		WriteTimeout:      s.timeouts.Write,
+		IdleTimeout:       s.timeouts.Idle,
+	}
+	httpServer := s.httpServer
+	s.mu.Unlock()
 
 	log.Printf("MemoryOS server starting on %s", s.addr)
-	return http.ListenAndServe(s.addr, nil)
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops accepting connections, ends open event streams and waits
+// for in-flight requests to finish or ctx to expire
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.closeOnce.Do(func() { close(s.closing) })
+
+	s.mu.Lock()
+	httpServer := s.httpServer
+	s.mu.Unlock()
+
+	if httpServer == nil {
+		return nil
+	}
+	return httpServer.Shutdown(ctx)
+}
+
+// tenantRoute dispatches a request to the server of the tenant it belongs to
+func (s *Server) tenantRoute(handler routeHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tenant, err := s.tenants.Resolve(r)
+		if err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+		handler(tenant, w, r)
+	}
+}
+
+// deprecated marks responses of a pre-/v1 route with a Deprecation header
+// and a link to its successor
+func deprecated(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", `</v1/>; rel="successor-version"`)
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// ========== AUTHORIZATION ==========
+
+// methodAction maps an HTTP method onto the action it performs
+func methodAction(method string) Action {
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		return ActionRead
+	case http.MethodDelete:
+		return ActionDelete
+	default:
+		return ActionWrite
+	}
+}
+
+// authorizeMemory checks the caller may act on ownerID's memories and
+// writes a 403 when it may not
+func (s *Server) authorizeMemory(w http.ResponseWriter, r *http.Request, ownerID string, action Action) bool {
+	if err := s.authz.AuthorizeMemory(r.Context(), CallerID(r), ownerID, action); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// authorizeShared resolves the shared memory scope addressed by the request
+// and checks the caller may act on the key within it. It returns the scope
+// and the caller's agent ID, writing a 400 or 403 when the check fails.
+func (s *Server) authorizeShared(w http.ResponseWriter, r *http.Request, key string, action Action) (ScopeRef, string, bool) {
+	ref, err := sharedScope(r)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return ScopeRef{}, "", false
+	}
+
+	callerID := CallerID(r)
+	if err := s.authz.AuthorizeShared(r.Context(), callerID, ref, key, action); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return ScopeRef{}, "", false
+	}
+	return ref, callerID, true
+}
+
+// sharedScope reads the scope and scope_id query parameters. team_id is
+// accepted as shorthand for team scope.
+func sharedScope(r *http.Request) (ScopeRef, error) {
+	query := r.URL.Query()
+	id := query.Get("scope_id")
+	if id == "" {
+		id = query.Get("team_id")
+	}
+	return ParseScope(query.Get("scope"), id)
 }
 
 // ========== HEALTH ENDPOINT ==========
@@ -50,9 +434,19 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	health, err := s.manager.GetSystemHealth(ctx)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	agents, err := s.agents.Summary(ctx)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
 		return
 	}
+	if health == nil {
+		health = make(map[string]interface{})
+	}
+	health["agents"] = agents
 
 	json.NewEncoder(w).Encode(health)
 }
@@ -72,36 +466,54 @@ func (s *Server) handleMemory(w http.ResponseWriter, r *http.Request) {
 	case http.MethodPut:
 		s.updateMemory(w, r, ctx)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
-func (s *Server) storeMemory(w http.ResponseWriter, r *http.Request, ctx context.Context) {
-	var req struct {
-		AgentID   string                 `json:"agent_id"`
-		Type      string                 `json:"type"`
-		Content   string                 `json:"content"`
-		Metadata  map[string]interface{} `json:"metadata"`
-		Tags      []string              `json:"tags"`
-		Importance float64              `json:"importance"`
-	}
-
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
+// storeMemoryRequest is the body of a memory store request
+type storeMemoryRequest struct {
+	AgentID    string                 `json:"agent_id"`
+	Type       string                 `json:"type"`
+	Content    string                 `json:"content"`
+	Metadata   map[string]interface{} `json:"metadata"`
+	Tags       []string               `json:"tags"`
+	Importance float64                `json:"importance"`
+	Embeddings []float64              `json:"embeddings"`
+}
 
-	memory := &Memory{
+// Memory builds the memory to store
+func (req *storeMemoryRequest) Memory() *Memory {
+	return &Memory{
 		AgentID:    req.AgentID,
 		Type:       MemoryType(req.Type),
 		Content:    req.Content,
 		Metadata:   req.Metadata,
 		Tags:       req.Tags,
 		Importance: req.Importance,
+		Embeddings: req.Embeddings,
+	}
+}
+
+func (s *Server) storeMemory(w http.ResponseWriter, r *http.Request, ctx context.Context) {
+	var req storeMemoryRequest
+
+	if err := decodeRequest(r, &req); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	memory := req.Memory()
+	if err := s.validator.Validate(memory, false); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if !s.authorizeMemory(w, r, req.AgentID, ActionWrite) {
+		return
 	}
 
 	if err := s.memoryos.StoreMemory(ctx, memory); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -114,13 +526,17 @@ func (s *Server) getMemory(w http.ResponseWriter, r *http.Request, ctx context.C
 	memoryID := r.URL.Query().Get("id")
 
 	if agentID == "" || memoryID == "" {
-		http.Error(w, "agent_id and id required", http.StatusBadRequest)
+		httpError(w, "agent_id and id required", http.StatusBadRequest)
+		return
+	}
+
+	if !s.authorizeMemory(w, r, agentID, ActionRead) {
 		return
 	}
 
 	memory, err := s.memoryos.GetMemory(ctx, agentID, MemoryType(memoryType), memoryID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		writeError(w, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -132,8 +548,12 @@ func (s *Server) deleteMemory(w http.ResponseWriter, r *http.Request, ctx contex
 	memoryType := r.URL.Query().Get("type")
 	memoryID := r.URL.Query().Get("id")
 
+	if !s.authorizeMemory(w, r, agentID, ActionDelete) {
+		return
+	}
+
 	if err := s.memoryos.DeleteMemory(ctx, agentID, MemoryType(memoryType), memoryID); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -142,13 +562,21 @@ func (s *Server) deleteMemory(w http.ResponseWriter, r *http.Request, ctx contex
 
 func (s *Server) updateMemory(w http.ResponseWriter, r *http.Request, ctx context.Context) {
 	var memory Memory
-	if err := json.NewDecoder(r.Body).Decode(&memory); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := decodeRequest(r, &memory); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	if err := s.validator.Validate(&memory, true); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if !s.authorizeMemory(w, r, memory.AgentID, ActionWrite) {
 		return
 	}
 
 	if err := s.memoryos.UpdateMemory(ctx, &memory); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -167,15 +595,71 @@ func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 		fmt.Sscanf(limitStr, "%d", &limit)
 	}
 
+	if !s.authorizeMemory(w, r, agentID, ActionRead) {
+		return
+	}
+
 	memories, err := s.memoryos.SearchMemories(ctx, agentID, query, limit)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, err, http.StatusInternalServerError)
 		return
 	}
 
 	json.NewEncoder(w).Encode(memories)
 }
 
+// handleTransfer copies or moves memories matching a MemoryQuery to another
+// agent or into team shared memory
+func (s *Server) handleTransfer(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodPost {
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req TransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	action := ActionRead
+	if req.Mode == TransferMove {
+		action = ActionDelete
+	}
+	if !s.authorizeMemory(w, r, req.Query.AgentID, action) {
+		return
+	}
+	if req.ToTeamID != "" {
+		if err := s.authz.AuthorizeShared(ctx, CallerID(r), TeamScope(req.ToTeamID), "", ActionWrite); err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+	} else {
+		if _, err := s.manager.GetAgent(ctx, req.ToAgentID); err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+		if !s.authorizeMemory(w, r, req.ToAgentID, ActionWrite) {
+			return
+		}
+	}
+
+	// A partial failure reports what was transferred in the error details
+	result, err := s.transfer.Transfer(ctx, req)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(result)
+}
+
 // ========== CONTEXT ENDPOINT ==========
 
 func (s *Server) handleContext(w http.ResponseWriter, r *http.Request) {
@@ -187,13 +671,47 @@ func (s *Server) handleContext(w http.ResponseWriter, r *http.Request) {
 		fmt.Sscanf(tokensStr, "%d", &maxTokens)
 	}
 
-	context, err := s.memoryos.GetContextWindow(ctx, agentID, maxTokens)
+	if !s.authorizeMemory(w, r, agentID, ActionRead) {
+		return
+	}
+
+	if r.URL.Query().Get("stream") == "true" || strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		s.streamContext(w, r, agentID, maxTokens)
+		return
+	}
+
+	// The plain response is built the same way as the stream, so both
+	// return the same context
+	compressed, err := s.contexts.Build(ctx, agentID, maxTokens, func(string, interface{}) error { return nil })
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, err, http.StatusInternalServerError)
 		return
 	}
 
-	json.NewEncoder(w).Encode(map[string]string{"context": context})
+	json.NewEncoder(w).Encode(map[string]string{"context": compressed.Summary})
+}
+
+// streamContext builds the agent's context over Server-Sent Events: a
+// memory event as each memory is selected, a progress event after each
+// compression step and a final summary event with the CompressedContext.
+// A failure once the stream has started is sent as an error event.
+func (s *Server) streamContext(w http.ResponseWriter, r *http.Request, agentID string, maxTokens int) {
+	stream, err := newSSEWriter(w)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	compressed, err := s.contexts.Build(r.Context(), agentID, maxTokens, func(event string, data interface{}) error {
+		return stream.Event("", event, data)
+	})
+	if err != nil {
+		_, body := apiError(err, http.StatusInternalServerError)
+		body.RequestID = w.Header().Get(RequestIDHeader)
+		stream.Event("", ContextEventError, body)
+		return
+	}
+	stream.Event("", ContextEventSummary, compressed)
 }
 
 // ========== AGENT ENDPOINTS ==========
@@ -205,157 +723,1019 @@ func (s *Server) handleAgent(w http.ResponseWriter, r *http.Request) {
 	case http.MethodPost:
 		s.registerAgent(w, r, ctx)
 	case http.MethodGet:
+		if err := s.authz.AuthorizeAgent(ctx, CallerID(r), ActionRead); err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+		if r.URL.Query().Get("id") == "" {
+			s.listAgents(w, r, ctx)
+			return
+		}
 		s.getAgent(w, r, ctx)
+	case http.MethodDelete:
+		s.deregisterAgent(w, r, ctx)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
 func (s *Server) registerAgent(w http.ResponseWriter, r *http.Request, ctx context.Context) {
 	var agent Agent
 	if err := json.NewDecoder(r.Body).Decode(&agent); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	if err := s.admitAgent(r, &agent); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.manager.RegisterAgent(ctx, &agent); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.agents.Register(ctx, &agent); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(agent)
+}
+
+// admitAgent checks a registration. An ID held by a registered agent is a
+// conflict. Only admins may set an agent's role or permissions; an agent
+// registered without them gets DefaultAgentPermissions.
+func (s *Server) admitAgent(r *http.Request, agent *Agent) error {
+	ctx := r.Context()
+	if agent.ID != "" {
+		if _, err := s.manager.GetAgent(ctx, agent.ID); err == nil {
+			deregistered, err := s.agents.IsDeregistered(ctx, agent.ID)
+			if err != nil {
+				return err
+			}
+			if !deregistered {
+				return errorf(ErrConflict, "agent already registered: %s", agent.ID)
+			}
+		}
+	}
+
+	if agent.Role == "" && len(agent.Permissions) == 0 {
+		agent.Permissions = append([]string(nil), DefaultAgentPermissions...)
+		return nil
+	}
+	return s.authz.AuthorizeAdmin(ctx, CallerID(r), ActionWrite)
+}
+
+func (s *Server) getAgent(w http.ResponseWriter, r *http.Request, ctx context.Context) {
+	agentID := r.URL.Query().Get("id")
+
+	agent, err := s.manager.GetAgent(ctx, agentID)
+	if err != nil {
+		httpError(w, fmt.Sprintf("agent not registered: %s", agentID), http.StatusNotFound)
+		return
+	}
+	deregistered, err := s.agents.IsDeregistered(ctx, agentID)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+	if deregistered {
+		httpError(w, fmt.Sprintf("agent not registered: %s", agentID), http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(agent)
+}
+
+// listAgents lists registered agents, filtered by status (online or
+// offline), role and a q search over agent ID and name
+func (s *Server) listAgents(w http.ResponseWriter, r *http.Request, ctx context.Context) {
+	filter := AgentFilter{
+		Status: AgentStatus(r.URL.Query().Get("status")),
+		Role:   r.URL.Query().Get("role"),
+		Query:  r.URL.Query().Get("q"),
+	}
+	if filter.Status != "" && filter.Status != AgentOnline && filter.Status != AgentOffline {
+		httpError(w, fmt.Sprintf("unknown status: %s", filter.Status), http.StatusBadRequest)
+		return
+	}
+
+	agents, err := s.agents.List(ctx, filter)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(agents)
+}
+
+// deregisterAgent removes an agent; purge=true also deletes its memories,
+// skills and locks
+func (s *Server) deregisterAgent(w http.ResponseWriter, r *http.Request, ctx context.Context) {
+	agentID := r.URL.Query().Get("id")
+	purge := r.URL.Query().Get("purge") == "true"
+
+	if !s.authorizeMemory(w, r, agentID, ActionDelete) {
+		return
+	}
+
+	result, err := s.agents.Deregister(ctx, agentID, purge)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(result)
+}
+
+func (s *Server) handleAgentHeartbeat(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodPost {
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	agentID := r.URL.Query().Get("id")
+	if !s.authorizeMemory(w, r, agentID, ActionWrite) {
+		return
+	}
+
+	record, err := s.agents.Heartbeat(ctx, agentID)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(record)
+}
+
+// ========== TEAM ENDPOINTS ==========
+
+func (s *Server) handleTeam(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	switch r.Method {
+	case http.MethodPost:
+		s.createTeam(w, r, ctx)
+	case http.MethodGet:
+		s.getTeam(w, r, ctx)
+	case http.MethodPut:
+		s.setTeamOrganization(w, r, ctx)
+	case http.MethodDelete:
+		s.deleteTeam(w, r, ctx)
+	default:
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) createTeam(w http.ResponseWriter, r *http.Request, ctx context.Context) {
+	var team Team
+	if err := json.NewDecoder(r.Body).Decode(&team); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	if err := s.admitTeam(r, &team); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.manager.CreateTeam(ctx, &team); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.roster.Create(ctx, &team, CallerID(r)); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(team)
+}
+
+// admitTeam checks a team creation: the caller must be a registered agent,
+// who becomes the team's owner, and the team ID must be free
+func (s *Server) admitTeam(r *http.Request, team *Team) error {
+	ctx := r.Context()
+	if err := s.authz.AuthorizeAgent(ctx, CallerID(r), ActionWrite); err != nil {
+		return err
+	}
+	if team.ID == "" {
+		return nil
+	}
+	if err := ValidateTeamID(team.ID); err != nil {
+		return err
+	}
+	if _, err := s.roster.Members(ctx, team.ID); err == nil {
+		return errorf(ErrConflict, "team already exists: %s", team.ID)
+	}
+	return nil
+}
+
+func (s *Server) getTeam(w http.ResponseWriter, r *http.Request, ctx context.Context) {
+	teamID := r.URL.Query().Get("id")
+
+	if err := s.authz.AuthorizeShared(ctx, CallerID(r), TeamScope(teamID), "", ActionRead); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	team, err := s.manager.GetTeam(ctx, teamID)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	members, err := s.roster.Members(ctx, teamID)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+	team.Members = make([]string, 0, len(members))
+	for _, member := range members {
+		team.Members = append(team.Members, member.AgentID)
+	}
+
+	json.NewEncoder(w).Encode(team)
+}
+
+func (s *Server) deleteTeam(w http.ResponseWriter, r *http.Request, ctx context.Context) {
+	teamID := r.URL.Query().Get("id")
+
+	if err := s.authz.AuthorizeTeamOwner(ctx, CallerID(r), teamID, ActionDelete); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.roster.DeleteTeam(ctx, teamID); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
+}
+
+// handleTeamSearch searches every member's memories and the team's shared
+// memory, attributing each result to its source agent
+func (s *Server) handleTeamSearch(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	teamID := r.URL.Query().Get("team_id")
+	query := r.URL.Query().Get("q")
+	limit := 10
+
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		fmt.Sscanf(limitStr, "%d", &limit)
+	}
+
+	if err := s.authz.AuthorizeShared(ctx, CallerID(r), TeamScope(teamID), "", ActionRead); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	results, err := s.views.Search(ctx, teamID, CallerID(r), query, limit)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(results)
+}
+
+// handleTeamContext builds a context window across the whole team
+func (s *Server) handleTeamContext(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	teamID := r.URL.Query().Get("team_id")
+	maxTokens := 4000
+
+	if tokensStr := r.URL.Query().Get("max_tokens"); tokensStr != "" {
+		fmt.Sscanf(tokensStr, "%d", &maxTokens)
+	}
+
+	if err := s.authz.AuthorizeShared(ctx, CallerID(r), TeamScope(teamID), "", ActionRead); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	context, err := s.views.ContextWindow(ctx, teamID, CallerID(r), maxTokens)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"context": context})
+}
+
+// handleTeamMembers manages team membership. GET lists a team's members,
+// or the teams of an agent when agent_id is given instead of team_id.
+func (s *Server) handleTeamMembers(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	teamID := r.URL.Query().Get("team_id")
+	memberID := r.URL.Query().Get("member_id")
+
+	switch r.Method {
+	case http.MethodGet:
+		if teamID == "" {
+			agentID := r.URL.Query().Get("agent_id")
+			if !s.authorizeMemory(w, r, agentID, ActionRead) {
+				return
+			}
+			teams, err := s.roster.TeamsFor(ctx, agentID)
+			if err != nil {
+				writeError(w, err, http.StatusInternalServerError)
+				return
+			}
+			json.NewEncoder(w).Encode(teams)
+			return
+		}
+
+		if err := s.authz.AuthorizeShared(ctx, CallerID(r), TeamScope(teamID), "", ActionRead); err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+		members, err := s.roster.Members(ctx, teamID)
+		if err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(members)
+	case http.MethodPost, http.MethodPut:
+		role, err := ParseTeamRole(r.URL.Query().Get("role"))
+		if err != nil {
+			writeError(w, err, http.StatusBadRequest)
+			return
+		}
+		if err := s.authz.AuthorizeTeamOwner(ctx, CallerID(r), teamID, ActionWrite); err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+		member, err := s.roster.AddMember(ctx, teamID, memberID, role)
+		if err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(member)
+	case http.MethodDelete:
+		// Members may always leave a team; removing others takes an owner
+		if memberID != CallerID(r) {
+			if err := s.authz.AuthorizeTeamOwner(ctx, CallerID(r), teamID, ActionDelete); err != nil {
+				writeError(w, err, http.StatusInternalServerError)
+				return
+			}
+		}
+		if err := s.roster.RemoveMember(ctx, teamID, memberID); err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"status": "removed"})
+	default:
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// setTeamOrganization places a team in an organization so its shared
+// memory reads fall back to the organization scope
+func (s *Server) setTeamOrganization(w http.ResponseWriter, r *http.Request, ctx context.Context) {
+	teamID := r.URL.Query().Get("id")
+	orgID := r.URL.Query().Get("organization_id")
+	if orgID != "" {
+		if err := ValidateOrganizationID(orgID); err != nil {
+			writeError(w, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if _, err := s.manager.GetTeam(ctx, teamID); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+	if err := s.authz.AuthorizeAdmin(ctx, CallerID(r), ActionWrite); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.scopes.SetTeamOrganization(ctx, teamID, orgID); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"team_id": teamID, "organization_id": orgID})
+}
+
+// handleScopeDefault reads or, with PUT, changes the permission agents get
+// in a scope when a key's ACL does not name them. Only admins may do
+// either.
+func (s *Server) handleScopeDefault(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	scope := Scope(r.URL.Query().Get("scope"))
+	if scope == "org" {
+		scope = ScopeOrganization
+	}
+
+	action := ActionRead
+	if r.Method != http.MethodGet {
+		action = ActionWrite
+	}
+	if err := s.authz.AuthorizeAdmin(ctx, CallerID(r), action); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+	if _, ok := defaultScopePermissions[scope]; !ok {
+		writeError(w, errorf(ErrInvalid, "unknown scope: %s", scope), http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		if err := s.scopes.SetDefault(ctx, scope, r.URL.Query().Get("permission")); err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+	default:
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	permission, err := s.scopes.Default(ctx, scope)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"scope": string(scope), "permission": permission})
+}
+
+// ========== SHARED MEMORY ENDPOINTS ==========
+
+func (s *Server) handleShared(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	key := r.URL.Query().Get("key")
+
+	ref, agentID, ok := s.authorizeShared(w, r, key, methodAction(r.Method))
+	if !ok {
+		return
+	}
+	namespace := ref.Namespace()
+
+	switch r.Method {
+	case http.MethodPost:
+		value, err := decodeSharedValue(r)
+		if err != nil {
+			writeError(w, err, http.StatusBadRequest)
+			return
+		}
+		entry, err := s.shared.Create(ctx, namespace, key, value, agentID)
+		if err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("ETag", versionETag(entry.Version))
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "created", "version": entry.Version})
+	case http.MethodGet:
+		chain := []ScopeRef{ref}
+		if r.URL.Query().Get("inherit") != "false" {
+			full, err := s.scopes.Chain(ctx, ref)
+			if err != nil {
+				writeError(w, err, http.StatusInternalServerError)
+				return
+			}
+			chain = s.readableScopes(r, key, full)
+		}
+		entry, from, err := s.shared.Resolve(ctx, chain, key)
+		if err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("ETag", versionETag(entry.Version))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"value":      entry.Value(),
+			"scope":      from,
+			"version":    entry.Version,
+			"locked":     entry.Locked,
+			"lock_owner": entry.LockOwner,
+		})
+	case http.MethodDelete:
+		cond, err := sharedPrecondition(r)
+		if err != nil {
+			writeError(w, err, http.StatusBadRequest)
+			return
+		}
+		if err := s.shared.Delete(ctx, namespace, key, agentID, cond); err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
+	default:
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleSharedValue(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	key := r.URL.Query().Get("key")
+
+	ref, agentID, ok := s.authorizeShared(w, r, key, methodAction(r.Method))
+	if !ok {
+		return
+	}
+	namespace := ref.Namespace()
+
+	cond, err := sharedPrecondition(r)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		value, err := decodeSharedValue(r)
+		if err != nil {
+			writeError(w, err, http.StatusBadRequest)
+			return
+		}
+		entry, err := s.shared.Update(ctx, namespace, key, value, agentID, cond)
+		if err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("ETag", versionETag(entry.Version))
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "updated", "version": entry.Version})
+	case http.MethodPatch:
+		var op SharedOp
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/merge-patch+json") {
+			op.Op = SharedOpMerge
+			if err := json.NewDecoder(r.Body).Decode(&op.Value); err != nil {
+				writeError(w, err, http.StatusBadRequest)
+				return
+			}
+		} else if err := json.NewDecoder(r.Body).Decode(&op); err != nil {
+			writeError(w, err, http.StatusBadRequest)
+			return
+		}
+		entry, err := s.shared.Apply(ctx, namespace, key, agentID, op, cond)
+		if err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("ETag", versionETag(entry.Version))
+		json.NewEncoder(w).Encode(map[string]interface{}{"value": entry.Value(), "version": entry.Version})
+	default:
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleSharedLock(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+
+	ref, agentID, ok := s.authorizeShared(w, r, key, methodAction(r.Method))
+	if !ok {
+		return
+	}
+	namespace := ref.Namespace()
+
+	var ttl time.Duration
+	if ttlStr := r.URL.Query().Get("ttl"); ttlStr != "" {
+		parsed, err := time.ParseDuration(ttlStr)
+		if err != nil {
+			writeError(w, err, http.StatusBadRequest)
+			return
+		}
+		ttl = parsed
+	}
+
+	var token uint64
+	if tokenStr := r.URL.Query().Get("token"); tokenStr != "" {
+		parsed, err := strconv.ParseUint(tokenStr, 10, 64)
+		if err != nil {
+			writeError(w, errorf(ErrInvalid, "invalid token: %s", tokenStr), http.StatusBadRequest)
+			return
+		}
+		token = parsed
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		lease, err := s.locks.Acquire(r.Context(), namespace, key, agentID, ttl)
+		if err != nil {
+			writeError(w, err, http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(lease)
+	case http.MethodPut:
+		lease, err := s.locks.Renew(r.Context(), namespace, key, agentID, token, ttl)
+		if err != nil {
+			writeError(w, err, http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(lease)
+	case http.MethodDelete:
+		if err := s.locks.Release(r.Context(), namespace, key, agentID, token); err != nil {
+			writeError(w, err, http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"status": "released"})
+	case http.MethodGet:
+		lease, err := s.locks.Holder(r.Context(), namespace, key)
+		if err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+		if lease == nil {
+			httpError(w, "not locked", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(lease)
+	default:
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleSharedACL(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+
+	ref, callerID, ok := s.authorizeShared(w, r, key, ActionRead)
+	if !ok {
+		return
+	}
+	namespace := ref.Namespace()
+
+	switch r.Method {
+	case http.MethodPut:
+		if err := s.authz.AuthorizeSharedAdmin(r.Context(), callerID, ref, key, ActionWrite); err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+		var acl map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&acl); err != nil {
+			writeError(w, err, http.StatusBadRequest)
+			return
+		}
+		entry, err := s.shared.SetACL(r.Context(), namespace, key, acl)
+		if err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(entry.ACL)
+	case http.MethodGet:
+		entry, err := s.shared.Get(r.Context(), namespace, key)
+		if err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(entry.ACL)
+	default:
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleSharedHistory(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+
+	if r.Method != http.MethodGet {
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ref, _, ok := s.authorizeShared(w, r, key, ActionRead)
+	if !ok {
+		return
+	}
+	namespace := ref.Namespace()
+
+	query := r.URL.Query()
+	switch {
+	case query.Get("from") != "" || query.Get("to") != "":
+		from, err := queryVersion(r, "from")
+		if err != nil {
+			writeError(w, err, http.StatusBadRequest)
+			return
+		}
+		to, err := queryVersion(r, "to")
+		if err != nil {
+			writeError(w, err, http.StatusBadRequest)
+			return
+		}
+		diff, err := s.shared.DiffVersions(r.Context(), namespace, key, from, to)
+		if err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(diff)
+	case query.Get("version") != "":
+		version, err := queryVersion(r, "version")
+		if err != nil {
+			writeError(w, err, http.StatusBadRequest)
+			return
+		}
+		entry, err := s.shared.At(r.Context(), namespace, key, version)
+		if err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(entry)
+	case query.Get("at") != "":
+		at, err := time.Parse(time.RFC3339, query.Get("at"))
+		if err != nil {
+			writeError(w, err, http.StatusBadRequest)
+			return
+		}
+		entry, err := s.shared.AsOf(r.Context(), namespace, key, at)
+		if err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(entry)
+	default:
+		history, err := s.shared.History(r.Context(), namespace, key)
+		if err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(history)
+	}
+}
+
+// queryVersion parses a version number from the query; an absent one is 0
+func queryVersion(r *http.Request, name string) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, nil
+	}
+	version, err := strconv.Atoi(value)
+	if err != nil || version < 0 {
+		return 0, errorf(ErrInvalid, "invalid %s: %s", name, value)
+	}
+	return version, nil
+}
+
+func (s *Server) handleSharedRestore(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+
+	if r.Method != http.MethodPost {
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ref, agentID, ok := s.authorizeShared(w, r, key, ActionWrite)
+	if !ok {
+		return
+	}
+	namespace := ref.Namespace()
+
+	version, err := queryVersion(r, "version")
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
 		return
 	}
-
-	if err := s.manager.RegisterAgent(ctx, &agent); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if version < 1 {
+		httpError(w, "version required", http.StatusBadRequest)
 		return
 	}
 
-	json.NewEncoder(w).Encode(agent)
-}
-
-func (s *Server) getAgent(w http.ResponseWriter, r *http.Request, ctx context.Context) {
-	agentID := r.URL.Query().Get("id")
-
-	agent, err := s.manager.GetAgent(ctx, agentID)
+	cond, err := sharedPrecondition(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		writeError(w, err, http.StatusBadRequest)
 		return
 	}
 
-	json.NewEncoder(w).Encode(agent)
+	entry, err := s.shared.Restore(r.Context(), namespace, key, version, agentID, cond)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", versionETag(entry.Version))
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "restored", "value": entry.Value(), "version": entry.Version})
 }
 
-// ========== TEAM ENDPOINTS ==========
-
-func (s *Server) handleTeam(w http.ResponseWriter, r *http.Request) {
+// handleSharedCRDT syncs a CRDT-mode shared key: POST merges the caller's
+// replica state and returns the merged state, GET returns the current one
+func (s *Server) handleSharedCRDT(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
+	key := r.URL.Query().Get("key")
+
+	ref, agentID, ok := s.authorizeShared(w, r, key, methodAction(r.Method))
+	if !ok {
+		return
+	}
+	namespace := ref.Namespace()
+
+	var entry *SharedMemory
+	var state CRDTState
+	var err error
 
 	switch r.Method {
 	case http.MethodPost:
-		s.createTeam(w, r, ctx)
+		var incoming CRDTState
+		if err := json.NewDecoder(r.Body).Decode(&incoming); err != nil {
+			writeError(w, err, http.StatusBadRequest)
+			return
+		}
+		entry, state, err = s.shared.MergeCRDT(ctx, namespace, key, agentID, incoming)
 	case http.MethodGet:
-		s.getTeam(w, r, ctx)
+		entry, state, err = s.shared.CRDT(ctx, namespace, key)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
 	}
+
+	w.Header().Set("ETag", versionETag(entry.Version))
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"type":    state.Type,
+		"state":   state.State,
+		"value":   entry.Value(),
+		"version": entry.Version,
+	})
 }
 
-func (s *Server) createTeam(w http.ResponseWriter, r *http.Request, ctx context.Context) {
-	var team Team
-	if err := json.NewDecoder(r.Body).Decode(&team); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+// handleSharedEvents streams a team's shared memory changes over
+// Server-Sent Events, or over a WebSocket when the client asks to upgrade.
+// Clients resume with ?after=<offset> or the Last-Event-ID header.
+func (s *Server) handleSharedEvents(w http.ResponseWriter, r *http.Request) {
+	ref, callerID, ok := s.authorizeShared(w, r, "", ActionRead)
+	if !ok {
 		return
 	}
+	namespace := ref.Namespace()
 
-	if err := s.manager.CreateTeam(ctx, &team); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	var after uint64
+	for _, cursor := range []string{r.Header.Get("Last-Event-ID"), r.URL.Query().Get("after")} {
+		if cursor == "" {
+			continue
+		}
+		parsed, err := strconv.ParseUint(cursor, 10, 64)
+		if err != nil {
+			writeError(w, errorf(ErrInvalid, "invalid offset: %s", cursor), http.StatusBadRequest)
+			return
+		}
+		after = parsed
+	}
+
+	sub, backlog, err := s.shared.feed.Subscribe(r.Context(), namespace, after)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
 		return
 	}
+	defer sub.Close()
 
-	json.NewEncoder(w).Encode(team)
-}
+	// Membership lets the caller subscribe; each event is still subject to
+	// the ACL of its key
+	visible := func(event ChangeEvent) bool {
+		return s.authz.AuthorizeShared(r.Context(), callerID, ref, event.Key, ActionRead) == nil
+	}
 
-func (s *Server) getTeam(w http.ResponseWriter, r *http.Request, ctx context.Context) {
-	teamID := r.URL.Query().Get("id")
+	if isWebSocketUpgrade(r) {
+		s.streamEventsWebSocket(w, r, sub, backlog, visible)
+		return
+	}
 
-	team, err := s.manager.GetTeam(ctx, teamID)
+	stream, err := newSSEWriter(w)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		writeError(w, err, http.StatusInternalServerError)
 		return
 	}
 
-	json.NewEncoder(w).Encode(team)
-}
+	for _, event := range backlog {
+		if !visible(event) {
+			continue
+		}
+		if err := stream.Event(fmt.Sprint(event.Offset), string(event.Type), event); err != nil {
+			return
+		}
+	}
 
-// ========== SHARED MEMORY ENDPOINTS ==========
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
 
-func (s *Server) handleShared(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
+	for {
+		select {
+		case event, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if !visible(event) {
+				continue
+			}
+			if err := stream.Event(fmt.Sprint(event.Offset), string(event.Type), event); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if err := stream.Comment("keep-alive"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-s.closing:
+			return
+		}
+	}
+}
 
-	path := r.URL.Path
-	if strings.Contains(path, "/shared/value") {
-		s.handleSharedValue(w, r, ctx)
+func (s *Server) streamEventsWebSocket(w http.ResponseWriter, r *http.Request, sub *Subscription, backlog []ChangeEvent, visible func(ChangeEvent) bool) {
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
 		return
 	}
+	defer conn.Close()
 
-	teamID := r.URL.Query().Get("team_id")
-	key := r.URL.Query().Get("key")
+	done := make(chan struct{})
+	go conn.readLoop(done)
 
-	switch r.Method {
-	case http.MethodPost:
-		value := r.URL.Query().Get("value")
-		if err := s.manager.CreateSharedValue(ctx, teamID, key, value); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+	for _, event := range backlog {
+		if !visible(event) {
+			continue
 		}
-		json.NewEncoder(w).Encode(map[string]string{"status": "created"})
-	case http.MethodGet:
-		value, err := s.manager.GetSharedValue(ctx, teamID, key)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusNotFound)
+		if err := conn.WriteJSON(event); err != nil {
 			return
 		}
-		json.NewEncoder(w).Encode(map[string]string{"value": value})
-	case http.MethodDelete:
-		if err := s.manager.DeleteSharedValue(ctx, teamID, key); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+
+	for {
+		select {
+		case event, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if !visible(event) {
+				continue
+			}
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		case <-done:
+			return
+		case <-s.closing:
 			return
 		}
-		json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
-	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
-func (s *Server) handleSharedValue(w http.ResponseWriter, r *http.Request, ctx context.Context) {
-	teamID := r.URL.Query().Get("team_id")
-	key := r.URL.Query().Get("key")
+// readableScopes drops the scopes in a fallback chain the caller may not read
+func (s *Server) readableScopes(r *http.Request, key string, chain []ScopeRef) []ScopeRef {
+	readable := make([]ScopeRef, 0, len(chain))
+	for _, ref := range chain {
+		if s.authz.AuthorizeShared(r.Context(), CallerID(r), ref, key, ActionRead) == nil {
+			readable = append(readable, ref)
+		}
+	}
+	return readable
+}
 
-	switch r.Method {
-	case http.MethodPut:
-		var req struct {
-			Value string `json:"value"`
+// decodeSharedValue reads a JSON value from a {"value": ...} request body.
+// Requests without a body fall back to the deprecated value query parameter.
+func decodeSharedValue(r *http.Request) (json.RawMessage, error) {
+	var req struct {
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if err == io.EOF && r.URL.Query().Has("value") {
+			return normalizeSharedValue(r.URL.Query().Get("value")), nil
 		}
-		json.NewDecoder(r.Body).Decode(&req)
-		if err := s.manager.UpdateSharedValue(ctx, teamID, key, req.Value); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+		return nil, err
+	}
+	if len(req.Value) == 0 {
+		return nil, errorf(ErrInvalid, "value required")
+	}
+	return req.Value, nil
+}
+
+// sharedPrecondition reads the If-Match and X-Fencing-Token headers
+func sharedPrecondition(r *http.Request) (SharedPrecondition, error) {
+	version, err := ParseIfMatch(r.Header.Get("If-Match"))
+	if err != nil {
+		return SharedPrecondition{}, err
+	}
+
+	cond := SharedPrecondition{Version: version}
+	if token := r.Header.Get("X-Fencing-Token"); token != "" {
+		if _, err := fmt.Sscanf(token, "%d", &cond.FencingToken); err != nil {
+			return SharedPrecondition{}, fmt.Errorf("invalid X-Fencing-Token header: %s", token)
 		}
-		json.NewEncoder(w).Encode(map[string]string{"status": "updated"})
-	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
+	return cond, nil
 }
 
 // ========== SKILL ENDPOINTS ==========
 
 func (s *Server) handleSkill(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	skillIndex := NewSkillIndex(s.memoryos)
+	skillIndex := s.skillIndex
+
+	if !s.authorizeMemory(w, r, r.URL.Query().Get("agent_id"), methodAction(r.Method)) {
+		return
+	}
 
 	switch r.Method {
 	case http.MethodPost:
 		var skill Skill
 		if err := json.NewDecoder(r.Body).Decode(&skill); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeError(w, err, http.StatusBadRequest)
 			return
 		}
 		skill.ID = uuid.New().String()
-		if err := skillIndex.RegisterSkill(ctx, r.URL.Query().Get("agent_id"), &skill); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		if err := s.skills.Register(ctx, r.URL.Query().Get("agent_id"), &skill); err != nil {
+			writeError(w, err, http.StatusInternalServerError)
 			return
 		}
 		json.NewEncoder(w).Encode(map[string]string{"id": skill.ID})
@@ -366,21 +1746,130 @@ func (s *Server) handleSkill(w http.ResponseWriter, r *http.Request) {
 		if skillName != "" {
 			skill, err := skillIndex.GetSkill(ctx, agentID, skillName)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusNotFound)
+				writeError(w, err, http.StatusInternalServerError)
 				return
 			}
 			json.NewEncoder(w).Encode(skill)
 		} else {
 			skills, err := skillIndex.GetSkillsByCategory(ctx, agentID, r.URL.Query().Get("category"))
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				writeError(w, err, http.StatusInternalServerError)
 				return
 			}
 			json.NewEncoder(w).Encode(skills)
 		}
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleSkillVersions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	agentID := r.URL.Query().Get("agent_id")
+
+	if !s.authorizeMemory(w, r, agentID, methodAction(r.Method)) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		var req struct {
+			SkillMemory
+			Note string `json:"note"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, err, http.StatusBadRequest)
+			return
+		}
+		version, err := s.skills.Publish(ctx, agentID, &req.SkillMemory, req.Note)
+		if err != nil {
+			writeError(w, err, http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(version)
+	case http.MethodGet:
+		name := r.URL.Query().Get("name")
+		from := r.URL.Query().Get("from")
+		to := r.URL.Query().Get("to")
+
+		if from != "" || to != "" {
+			fromVersion, err := queryVersion(r, "from")
+			if err != nil {
+				writeError(w, err, http.StatusBadRequest)
+				return
+			}
+			toVersion, err := queryVersion(r, "to")
+			if err != nil {
+				writeError(w, err, http.StatusBadRequest)
+				return
+			}
+			diff, err := s.skills.Diff(ctx, agentID, name, fromVersion, toVersion)
+			if err != nil {
+				writeError(w, err, http.StatusInternalServerError)
+				return
+			}
+			json.NewEncoder(w).Encode(diff)
+			return
+		}
+
+		versions, err := s.skills.Versions(ctx, agentID, name)
+		if err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(versions)
+	default:
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleSkillRollback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	version, err := queryVersion(r, "version")
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	if version < 1 {
+		httpError(w, "version required", http.StatusBadRequest)
+		return
+	}
+
+	if !s.authorizeMemory(w, r, r.URL.Query().Get("agent_id"), ActionWrite) {
+		return
+	}
+
+	rolledBack, err := s.skills.Rollback(r.Context(), r.URL.Query().Get("agent_id"), r.URL.Query().Get("name"), version)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(rolledBack)
+}
+
+func (s *Server) handleSkillTool(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeMemory(w, r, r.URL.Query().Get("agent_id"), ActionRead) {
+		return
+	}
+
+	version, err := queryVersion(r, "version")
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	tool, err := s.skills.ToolDefinition(r.Context(), r.URL.Query().Get("agent_id"), r.URL.Query().Get("name"), version)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
 	}
+
+	json.NewEncoder(w).Encode(tool)
 }
 
 // ========== STATS ENDPOINT ==========
@@ -389,9 +1878,28 @@ func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	agentID := r.URL.Query().Get("agent_id")
 
+	// Without an agent, report the whole tenant
+	if agentID == "" {
+		if err := s.authz.AuthorizeAdmin(ctx, CallerID(r), ActionRead); err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+		stats, err := s.tenantStats(ctx)
+		if err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(stats)
+		return
+	}
+
+	if !s.authorizeMemory(w, r, agentID, ActionRead) {
+		return
+	}
+
 	stats, err := s.memoryos.GetMemoryStats(ctx, agentID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -400,17 +1908,27 @@ func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
 
 // ========== CLI STRUCTS ==========
 
-// CLI represents the MemoryOS CLI
+// CLI represents the MemoryOS CLI. It works on the default tenant through
+// the same components as the server, so CLI writes are validated,
+// deduplicated, counted against quotas and recorded in the shared history
+// like server requests that belong to no other tenant.
 type CLI struct {
-	memoryos *MemoryOS
-	manager  *SharedMemoryManager
+	server *Server
+	stdin  io.Reader
 }
 
-// NewCLI creates a new CLI
-func NewCLI(memoryos *MemoryOS) *CLI {
+// NewCLI creates a new CLI on the Redis server named by config, the one
+// memoryos was opened with
+func NewCLI(memoryos *MemoryOS, config *MemoryOSConfig) *CLI {
+	storage := &tenantStorage{
+		memory: coreMemory{memoryos},
+		shared: coreShared{NewSharedMemoryManager(memoryos)},
+		skills: coreSkills{NewSkillIndex(memoryos)},
+		state:  newConfigState(config),
+	}
 	return &CLI{
-		memoryos: memoryos,
-		manager:  NewSharedMemoryManager(memoryos),
+		server: newTenantServer(storage, DefaultTenantID),
+		stdin:  os.Stdin,
 	}
 }
 
@@ -442,6 +1960,8 @@ func (c *CLI) Run(args []string) error {
 		return c.cmdShared(ctx, args[2:])
 	case "skill":
 		return c.cmdSkill(ctx, args[2:])
+	case "hash-key":
+		return c.cmdHashKey(args[2:])
 	case "help":
 		return c.printHelp()
 	default:
@@ -460,7 +1980,7 @@ func (c *CLI) cmdStore(ctx context.Context, args []string) error {
 		Content: strings.Join(args[2:], " "),
 	}
 
-	return c.memoryos.StoreMemory(ctx, memory)
+	return c.server.memoryos.StoreMemory(ctx, memory)
 }
 
 func (c *CLI) cmdGet(ctx context.Context, args []string) error {
@@ -468,7 +1988,7 @@ func (c *CLI) cmdGet(ctx context.Context, args []string) error {
 		return fmt.Errorf("usage: get <agent_id> <type> <id>")
 	}
 
-	memory, err := c.memoryos.GetMemory(ctx, args[0], MemoryType(args[1]), args[2])
+	memory, err := c.server.memoryos.GetMemory(ctx, args[0], MemoryType(args[1]), args[2])
 	if err != nil {
 		return err
 	}
@@ -483,7 +2003,7 @@ func (c *CLI) cmdSearch(ctx context.Context, args []string) error {
 		return fmt.Errorf("usage: search <agent_id> <query>")
 	}
 
-	memories, err := c.memoryos.SearchMemories(ctx, args[0], args[1], 10)
+	memories, err := c.server.memoryos.SearchMemories(ctx, args[0], args[1], 10)
 	if err != nil {
 		return err
 	}
@@ -498,7 +2018,7 @@ func (c *CLI) cmdContext(ctx context.Context, args []string) error {
 		return fmt.Errorf("usage: context <agent_id>")
 	}
 
-	context, err := c.memoryos.GetContextWindow(ctx, args[0], 4000)
+	context, err := c.server.memoryos.GetContextWindow(ctx, args[0], 4000)
 	if err != nil {
 		return err
 	}
@@ -512,7 +2032,7 @@ func (c *CLI) cmdStats(ctx context.Context, args []string) error {
 		return fmt.Errorf("usage: stats <agent_id>")
 	}
 
-	stats, err := c.memoryos.GetMemoryStats(ctx, args[0])
+	stats, err := c.server.memoryos.GetMemoryStats(ctx, args[0])
 	if err != nil {
 		return err
 	}
@@ -524,7 +2044,25 @@ func (c *CLI) cmdStats(ctx context.Context, args []string) error {
 
 func (c *CLI) cmdAgent(ctx context.Context, args []string) error {
 	if len(args) < 1 {
-		return fmt.Errorf("usage: agent <name> [role]")
+		return fmt.Errorf("usage: agent <name> [role] | agent ls|rm ...")
+	}
+
+	switch args[0] {
+	case "ls":
+		filter := AgentFilter{}
+		if len(args) > 1 {
+			filter.Status = AgentStatus(args[1])
+		}
+		agents, err := c.server.agents.List(ctx, filter)
+		if err != nil {
+			return err
+		}
+		for _, agent := range agents {
+			fmt.Printf("%s\t%s\t%s\t%s\tlast seen %s\n", agent.AgentID, agent.Name, agent.Role, agent.Status, agent.LastSeen.Format(time.RFC3339))
+		}
+		return nil
+	case "rm":
+		return c.cmdAgentRemove(ctx, args[1:])
 	}
 
 	role := "worker"
@@ -539,12 +2077,97 @@ func (c *CLI) cmdAgent(ctx context.Context, args []string) error {
 		Metadata: make(map[string]interface{}),
 	}
 
-	return c.manager.RegisterAgent(ctx, agent)
+	if err := c.server.manager.RegisterAgent(ctx, agent); err != nil {
+		return err
+	}
+	return c.server.agents.Register(ctx, agent)
+}
+
+func (c *CLI) cmdAgentRemove(ctx context.Context, args []string) error {
+	var agentID string
+	purge, confirmed := false, false
+	for _, arg := range args {
+		switch arg {
+		case "--purge":
+			purge = true
+		case "--yes", "-y":
+			confirmed = true
+		default:
+			agentID = arg
+		}
+	}
+	if agentID == "" {
+		return fmt.Errorf("usage: agent rm <agent_id> [--purge] [--yes]")
+	}
+
+	if !confirmed {
+		prompt := fmt.Sprintf("Deregister agent %s", agentID)
+		if purge {
+			prompt += " and delete all of its memories, skills and locks"
+		}
+		fmt.Printf("%s? [y/N] ", prompt)
+
+		var answer string
+		fmt.Fscanln(c.stdin, &answer)
+		if answer = strings.ToLower(answer); answer != "y" && answer != "yes" {
+			return fmt.Errorf("aborted")
+		}
+	}
+
+	result, err := c.server.agents.Deregister(ctx, agentID, purge)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Deregistered %s (memories deleted: %d, skills deleted: %d, locks released: %d)\n",
+		result.AgentID, result.MemoriesDeleted, result.SkillsDeleted, result.LocksReleased)
+	return nil
 }
 
 func (c *CLI) cmdTeam(ctx context.Context, args []string) error {
 	if len(args) < 1 {
-		return fmt.Errorf("usage: team <name>")
+		return fmt.Errorf("usage: team <name> <owner_agent_id> | team add|remove|ls ...")
+	}
+
+	switch args[0] {
+	case "add":
+		if len(args) < 3 {
+			return fmt.Errorf("usage: team add <team_id> <agent_id> [owner|writer|reader]")
+		}
+		roleName := ""
+		if len(args) > 3 {
+			roleName = args[3]
+		}
+		role, err := ParseTeamRole(roleName)
+		if err != nil {
+			return err
+		}
+		_, err = c.server.roster.AddMember(ctx, args[1], args[2], role)
+		return err
+	case "remove":
+		if len(args) < 3 {
+			return fmt.Errorf("usage: team remove <team_id> <agent_id>")
+		}
+		return c.server.roster.RemoveMember(ctx, args[1], args[2])
+	case "ls":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: team ls <agent_id>")
+		}
+		teams, err := c.server.roster.TeamsFor(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		for _, teamID := range teams {
+			fmt.Println(teamID)
+		}
+		return nil
+	}
+
+	if len(args) < 2 {
+		return fmt.Errorf("usage: team <name> <owner_agent_id>")
+	}
+	owner := args[1]
+	if err := c.server.authz.AuthorizeAgent(ctx, owner, ActionWrite); err != nil {
+		return err
 	}
 
 	team := &Team{
@@ -553,15 +2176,43 @@ func (c *CLI) cmdTeam(ctx context.Context, args []string) error {
 		Members:     []string{},
 	}
 
-	return c.manager.CreateTeam(ctx, team)
+	if err := c.server.manager.CreateTeam(ctx, team); err != nil {
+		return err
+	}
+	if err := c.server.roster.Create(ctx, team, owner); err != nil {
+		return err
+	}
+	fmt.Println(team.ID)
+	return nil
 }
 
 func (c *CLI) cmdShared(ctx context.Context, args []string) error {
-	if len(args) < 3 {
-		return fmt.Errorf("usage: shared <team_id> <key> <value>")
+	usage := fmt.Errorf("usage: shared [--scope team|org|global] [<scope_id>] <key> <value>")
+
+	scope := string(ScopeTeam)
+	if len(args) > 1 && args[0] == "--scope" {
+		scope, args = args[1], args[2:]
+	}
+
+	var id string
+	if ref, _ := ParseScope(scope, ""); ref.Scope != ScopeGlobal {
+		if len(args) < 1 {
+			return usage
+		}
+		id, args = args[0], args[1:]
+	}
+	if len(args) < 2 {
+		return usage
+	}
+
+	ref, err := ParseScope(scope, id)
+	if err != nil {
+		return err
 	}
 
-	return c.manager.CreateSharedValue(ctx, args[0], args[1], args[2])
+	value := strings.Join(args[1:], " ")
+	_, err = c.server.shared.Create(ctx, ref.Namespace(), args[0], normalizeSharedValue(value), "")
+	return err
 }
 
 func (c *CLI) cmdSkill(ctx context.Context, args []string) error {
@@ -575,8 +2226,16 @@ func (c *CLI) cmdSkill(ctx context.Context, args []string) error {
 		Mastery:     0.0,
 	}
 
-	skillIndex := NewSkillIndex(c.memoryos)
-	return skillIndex.RegisterSkill(ctx, args[0], skill)
+	return c.server.skills.Register(ctx, args[0], skill)
+}
+
+func (c *CLI) cmdHashKey(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: hash-key <api_key>")
+	}
+
+	fmt.Println(HashAPIKey(args[0]))
+	return nil
 }
 
 func (c *CLI) printHelp() error {
@@ -593,9 +2252,17 @@ Commands:
   context <agent_id>                   Get context window
   stats <agent_id>                     Get memory statistics
   agent <name> [role]                  Register an agent
-  team <name>                          Create a team
+  agent ls [online|offline]            List registered agents
+  agent rm <agent_id> [--purge]        Deregister an agent (--purge deletes its memories, skills and locks)
+  team <name> <owner_agent_id>         Create a team owned by an agent
+  team add <team_id> <agent_id> [role] Add a team member (owner, writer, reader)
+  team remove <team_id> <agent_id>     Remove a team member
+  team ls <agent_id>                   List an agent's teams
   shared <team_id> <key> <value>       Create shared value
+  shared --scope org <org_id> <key> <value>
+  shared --scope global <key> <value>  Create org or global shared value
   skill <agent_id> <name> <desc>       Register a skill
+  hash-key <api_key>                   Print the hash to put in the auth config
   help                                  Show this help
 
 Types:
@@ -625,17 +2292,18 @@ func Main() {
 
 	// For demo purposes, create an in-memory version
 	// In production, connect to actual Redis
-	memoryos, err := NewMemoryOS(&MemoryOSConfig{
+	config := &MemoryOSConfig{
 		RedisAddr: "localhost:6379",
 		MaxTokens: 4000,
-	})
+	}
+	memoryos, err := NewMemoryOS(config)
 	if err != nil {
 		log.Printf("Warning: Could not connect to Redis: %v", err)
 		log.Println("Running in demo mode (no persistence)")
 		return
 	}
 
-	cli := NewCLI(memoryos)
+	cli := NewCLI(memoryos, config)
 	if err := cli.Run(os.Args); err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,569 @@
+package memoryos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrVersionConflict is returned when a shared value write carries an
+// expected version that no longer matches the stored one
+var ErrVersionConflict = newError(ErrConflict, "version conflict")
+
+// AnyVersion disables the version check on a shared value write
+const AnyVersion = 0
+
+// DefaultModifyAttempts is how often Modify retries a conflicting write
+const DefaultModifyAttempts = 5
+
+// SharedPrecondition guards a shared value write
+type SharedPrecondition struct {
+	Version      int    // expected current version, AnyVersion to skip the check
+	FencingToken uint64 // lease token presented by the writer, 0 if none
+}
+
+// sharedWriteAttempts bounds how often a write that carries no version
+// precondition is retried when another writer changes the key first
+const sharedWriteAttempts = 16
+
+// SharedStore adds compare-and-set semantics to shared values. Each key's
+// value is kept in the StateBackend together with its Version and ACL, so
+// every server instance checks the same version and a write only lands if
+// the record is unchanged since it was read. The record is the only copy of
+// the value: the shared memory manager is only read to adopt values written
+// there before the store kept a record of the key. Writes to keys leased
+// through the LockManager are restricted to the lease owner. Every successful write is
+// published to the change feed and recorded in the key's bounded version
+// history, which is kept in the StateBackend as well.
+type SharedStore struct {
+	manager      SharedBackend
+	state        StateBackend
+	locks        *LockManager
+	feed         *ChangeFeed
+	historyLimit int
+}
+
+// NewSharedStore creates a new shared store
+func NewSharedStore(manager SharedBackend, state StateBackend, locks *LockManager, feed *ChangeFeed) *SharedStore {
+	return &SharedStore{
+		manager:      manager,
+		state:        state,
+		locks:        locks,
+		feed:         feed,
+		historyLimit: DefaultHistoryLimit,
+	}
+}
+
+// sharedKey identifies a key within a shared memory namespace. Team scope
+// uses the team ID as its namespace; see ScopeRef.Namespace.
+func sharedKey(teamID, key string) string {
+	return teamID + ":" + key
+}
+
+// State keys of a shared key's record and of a namespace's key index
+func sharedRecordKey(namespace, key string) string {
+	return "shared:" + sharedKey(namespace, key)
+}
+
+func sharedIndexKey(namespace string) string {
+	return "shared-keys:" + namespace
+}
+
+// sharedRecord is the stored form of a shared key. A deleted key leaves a
+// tombstone with its last version so that numbering continues past it. A
+// key in CRDT mode records its CRDT type and state; Value then holds the
+// CRDT's resolved value, which is what readers see. Fence is the highest
+// fencing token a write has presented; a write with a lower token is
+// rejected, and since the token is part of the record a takeover between
+// the check and the compare-and-set makes the swap fail.
+type sharedRecord struct {
+	Version   int               `json:"version"`
+	Value     json.RawMessage   `json:"value,omitempty"`
+	CRDT      CRDTType          `json:"crdt,omitempty"`
+	State     json.RawMessage   `json:"state,omitempty"`
+	AgentID   string            `json:"agent_id,omitempty"`
+	ACL       map[string]string `json:"acl,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+	Deleted   bool              `json:"deleted,omitempty"`
+	Fence     uint64            `json:"fence,omitempty"`
+}
+
+// entry returns the record as the SharedMemory handed to callers
+func (r *sharedRecord) entry(namespace, key string) *SharedMemory {
+	ref := ScopeFromNamespace(namespace)
+	acl := make(map[string]string, len(r.ACL))
+	for agentID, perm := range r.ACL {
+		acl[agentID] = perm
+	}
+	return &SharedMemory{
+		Memory: Memory{
+			ID:        sharedKey(namespace, key),
+			Type:      MemoryTypeShared,
+			AgentID:   r.AgentID,
+			Content:   string(r.Value),
+			Metadata:  map[string]interface{}{"scope_id": ref.ID, "key": key},
+			CreatedAt: r.CreatedAt,
+			UpdatedAt: r.UpdatedAt,
+		},
+		Scope:   string(ref.Scope),
+		ACL:     acl,
+		Version: r.Version,
+		CRDT:    r.CRDT,
+	}
+}
+
+// Value returns the shared value as JSON
+func (m *SharedMemory) Value() json.RawMessage {
+	return json.RawMessage(m.Content)
+}
+
+// Get returns the shared value for a team key along with its version
+func (s *SharedStore) Get(ctx context.Context, teamID, key string) (*SharedMemory, error) {
+	record, _, err := s.load(ctx, teamID, key)
+	if err != nil {
+		return nil, err
+	}
+	entry := record.entry(teamID, key)
+	lease, err := s.locks.Holder(ctx, teamID, key)
+	if err != nil {
+		return nil, err
+	}
+	if lease != nil {
+		entry.Locked, entry.LockOwner = true, lease.Owner
+	}
+	return entry, nil
+}
+
+// read returns a key's stored record, or nil if it has none, along with the
+// raw form that a compare-and-set must match
+func (s *SharedStore) read(ctx context.Context, teamID, key string) (*sharedRecord, string, error) {
+	raw, err := s.state.Get(ctx, sharedRecordKey(teamID, key))
+	if errors.Is(err, ErrNotFound) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	var record sharedRecord
+	if err := json.Unmarshal([]byte(raw), &record); err != nil {
+		return nil, "", fmt.Errorf("shared record %s: %w", key, err)
+	}
+	return &record, raw, nil
+}
+
+// swap writes record if the stored record is still old ("" when there was
+// none) and returns the new raw form
+func (s *SharedStore) swap(ctx context.Context, teamID, key, old string, record *sharedRecord) (string, bool, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return "", false, err
+	}
+	swapped, err := s.state.CompareAndSwap(ctx, sharedRecordKey(teamID, key), old, string(data), 0)
+	return string(data), swapped, err
+}
+
+// load returns the record of a live key and its raw form. A value the
+// manager holds for a key the store has never recorded, written before the
+// store tracked it, is adopted as the first version. Missing and deleted
+// keys are ErrNotFound.
+func (s *SharedStore) load(ctx context.Context, teamID, key string) (*sharedRecord, string, error) {
+	for attempt := 0; attempt < sharedWriteAttempts; attempt++ {
+		record, raw, err := s.read(ctx, teamID, key)
+		if err != nil {
+			return nil, "", err
+		}
+		if record != nil && record.Deleted {
+			return nil, "", errorf(ErrNotFound, "shared key not found: %s", key)
+		}
+		if record != nil {
+			return record, raw, nil
+		}
+
+		value, err := s.manager.GetSharedValue(ctx, teamID, key)
+		if err != nil {
+			return nil, "", err
+		}
+
+		now := time.Now()
+		adopted := &sharedRecord{Version: 1, Value: normalizeSharedValue(value), CreatedAt: now, UpdatedAt: now}
+		written, ok, err := s.swap(ctx, teamID, key, "", adopted)
+		if err != nil {
+			return nil, "", err
+		}
+		if !ok {
+			// Another writer recorded the key first
+			continue
+		}
+		if err := s.state.AddMember(ctx, sharedIndexKey(teamID), key); err != nil {
+			return nil, "", err
+		}
+		if err := s.appendHistory(ctx, teamID, key, SharedVersion{
+			Version:   adopted.Version,
+			Value:     adopted.Value,
+			Change:    ChangeCreate,
+			Timestamp: adopted.UpdatedAt,
+		}); err != nil {
+			return nil, "", err
+		}
+		return adopted, written, nil
+	}
+	return nil, "", fmt.Errorf("%w: %s keeps changing", ErrVersionConflict, key)
+}
+
+// Create stores a new shared value. A key that already exists, whether the
+// store has recorded it or it is still waiting to be adopted from the
+// manager, is ErrConflict.
+func (s *SharedStore) Create(ctx context.Context, teamID, key string, value json.RawMessage, agentID string) (*SharedMemory, error) {
+	return s.create(ctx, teamID, key, agentID, &sharedRecord{Value: value})
+}
+
+// create stores a new key from the value, and CRDT type and state if any,
+// of init
+func (s *SharedStore) create(ctx context.Context, teamID, key, agentID string, init *sharedRecord) (*SharedMemory, error) {
+	value := init.Value
+	if !json.Valid(value) {
+		return nil, fmt.Errorf("%w: value must be JSON", ErrInvalidValue)
+	}
+	if err := s.locks.CheckWrite(ctx, teamID, key, agentID, 0); err != nil {
+		return nil, err
+	}
+
+	previous, old, err := s.read(ctx, teamID, key)
+	if err != nil {
+		return nil, err
+	}
+	if previous != nil && !previous.Deleted {
+		return nil, errorf(ErrConflict, "shared key already exists: %s", key)
+	}
+	if previous == nil {
+		// A value the manager holds without a record still exists
+		_, err := s.manager.GetSharedValue(ctx, teamID, key)
+		if err == nil {
+			return nil, errorf(ErrConflict, "shared key already exists: %s", key)
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	record := &sharedRecord{Version: 1, Value: value, CRDT: init.CRDT, State: init.State, AgentID: agentID, CreatedAt: now, UpdatedAt: now}
+	if previous != nil {
+		// Keep numbering and fencing past a deleted incarnation of the key
+		record.Version = previous.Version + 1
+		record.Fence = previous.Fence
+	}
+	_, ok, err := s.swap(ctx, teamID, key, old, record)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errorf(ErrConflict, "shared key already exists: %s", key)
+	}
+	if err := s.state.AddMember(ctx, sharedIndexKey(teamID), key); err != nil {
+		return nil, err
+	}
+
+	if err := s.record(ctx, ChangeCreate, teamID, key, record.Version, agentID, record.Value); err != nil {
+		return nil, err
+	}
+	return record.entry(teamID, key), nil
+}
+
+// errUnchanged tells write that a change leaves the value as it is
+var errUnchanged = errors.New("unchanged")
+
+// write lets change set the new value of an existing key on a copy of its
+// record and stores it through a compare-and-set. When another writer
+// changes the key first the precondition is checked again and change reruns
+// on the new record, so a write with an expected version fails instead of
+// overwriting the change.
+func (s *SharedStore) write(ctx context.Context, teamID, key, agentID string, cond SharedPrecondition, change func(updated *sharedRecord) error) (*SharedMemory, error) {
+	for attempt := 0; attempt < sharedWriteAttempts; attempt++ {
+		record, old, err := s.load(ctx, teamID, key)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.check(ctx, record, teamID, key, agentID, cond); err != nil {
+			return nil, err
+		}
+
+		updated := *record
+		err = change(&updated)
+		if errors.Is(err, errUnchanged) {
+			return record.entry(teamID, key), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		value := updated.Value
+		if !json.Valid(value) {
+			return nil, fmt.Errorf("%w: value must be JSON", ErrInvalidValue)
+		}
+
+		updated.AgentID = agentID
+		updated.UpdatedAt = time.Now()
+		updated.Version = record.Version + 1
+		updated.Fence = fence(record, cond)
+		_, ok, err := s.swap(ctx, teamID, key, old, &updated)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+
+		if err := s.record(ctx, ChangeUpdate, teamID, key, updated.Version, agentID, updated.Value); err != nil {
+			return nil, err
+		}
+		return updated.entry(teamID, key), nil
+	}
+	return nil, fmt.Errorf("%w: %s keeps changing", ErrVersionConflict, key)
+}
+
+// Update overwrites a shared value if the precondition still holds
+func (s *SharedStore) Update(ctx context.Context, teamID, key string, value json.RawMessage, agentID string, cond SharedPrecondition) (*SharedMemory, error) {
+	return s.write(ctx, teamID, key, agentID, cond, func(updated *sharedRecord) error {
+		if updated.CRDT != "" {
+			return fmt.Errorf("%w: %s is in CRDT mode and only accepts merges", ErrInvalidValue, key)
+		}
+		updated.Value = value
+		return nil
+	})
+}
+
+// Apply runs a server-side operation against a shared value atomically.
+// Operations on a missing key start from null and create it, unless the
+// precondition expects a specific version.
+func (s *SharedStore) Apply(ctx context.Context, teamID, key, agentID string, op SharedOp, cond SharedPrecondition) (*SharedMemory, error) {
+	for attempt := 0; ; attempt++ {
+		entry, err := s.write(ctx, teamID, key, agentID, cond, func(updated *sharedRecord) error {
+			if updated.CRDT != "" {
+				return fmt.Errorf("%w: %s is in CRDT mode and only accepts merges", ErrInvalidValue, key)
+			}
+			value, err := op.Apply(updated.Value)
+			updated.Value = value
+			return err
+		})
+		if !errors.Is(err, ErrNotFound) || cond.Version != AnyVersion {
+			return entry, err
+		}
+
+		value, err := op.Apply(nil)
+		if err != nil {
+			return nil, err
+		}
+		entry, err = s.Create(ctx, teamID, key, value, agentID)
+		if !errors.Is(err, ErrConflict) || attempt == sharedWriteAttempts {
+			return entry, err
+		}
+		// Another writer created the key first; apply the op to its value
+	}
+}
+
+// Delete removes a shared value if the precondition still holds
+func (s *SharedStore) Delete(ctx context.Context, teamID, key, agentID string, cond SharedPrecondition) error {
+	return s.remove(ctx, teamID, key, agentID, &cond)
+}
+
+// remove deletes a key, leaving a tombstone that carries its version on.
+// A nil cond skips the lease and version checks.
+func (s *SharedStore) remove(ctx context.Context, teamID, key, agentID string, cond *SharedPrecondition) error {
+	for attempt := 0; attempt < sharedWriteAttempts; attempt++ {
+		record, old, err := s.load(ctx, teamID, key)
+		if err != nil {
+			return err
+		}
+		if cond != nil {
+			if err := s.check(ctx, record, teamID, key, agentID, *cond); err != nil {
+				return err
+			}
+		}
+
+		tombstone := &sharedRecord{Version: record.Version + 1, AgentID: agentID, CreatedAt: record.CreatedAt, UpdatedAt: time.Now(), Deleted: true, Fence: record.Fence}
+		if cond != nil {
+			tombstone.Fence = fence(record, *cond)
+		}
+		_, ok, err := s.swap(ctx, teamID, key, old, tombstone)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if err := s.state.RemoveMember(ctx, sharedIndexKey(teamID), key); err != nil {
+			return err
+		}
+
+		return s.record(ctx, ChangeDelete, teamID, key, tombstone.Version, agentID, nil)
+	}
+	return fmt.Errorf("%w: %s keeps changing", ErrVersionConflict, key)
+}
+
+// Keys lists the keys of a namespace
+func (s *SharedStore) Keys(ctx context.Context, namespace string) ([]string, error) {
+	keys, err := s.state.Members(ctx, sharedIndexKey(namespace))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// DeleteNamespace deletes every key of a namespace, leased or not, and
+// drops their history
+func (s *SharedStore) DeleteNamespace(ctx context.Context, namespace string) error {
+	keys, err := s.Keys(ctx, namespace)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := s.remove(ctx, namespace, key, "", nil); err != nil && !errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("delete %s: %w", key, err)
+		}
+		if err := s.state.Delete(ctx, sharedHistoryKey(namespace, key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// record publishes a change and appends it to the key's history. The
+// change has already been written, so a feed or history failure is
+// reported without undoing it.
+func (s *SharedStore) record(ctx context.Context, change ChangeType, teamID, key string, version int, agentID string, value json.RawMessage) error {
+	now := time.Now()
+	if _, err := s.feed.Publish(ctx, ChangeEvent{
+		TeamID:    teamID,
+		Key:       key,
+		Type:      change,
+		Version:   version,
+		AgentID:   agentID,
+		Value:     value,
+		Timestamp: now,
+	}); err != nil {
+		return fmt.Errorf("publish change of %s: %w", key, err)
+	}
+	if err := s.appendHistory(ctx, teamID, key, SharedVersion{
+		Version:   version,
+		Value:     value,
+		AgentID:   agentID,
+		Change:    change,
+		Timestamp: now,
+	}); err != nil {
+		return fmt.Errorf("record history of %s: %w", key, err)
+	}
+	return nil
+}
+
+// SetACL replaces the per-agent permissions on a shared key. The version
+// is left as it is since the value does not change.
+func (s *SharedStore) SetACL(ctx context.Context, teamID, key string, acl map[string]string) (*SharedMemory, error) {
+	for attempt := 0; attempt < sharedWriteAttempts; attempt++ {
+		record, old, err := s.load(ctx, teamID, key)
+		if err != nil {
+			return nil, err
+		}
+
+		updated := *record
+		updated.ACL = make(map[string]string, len(acl))
+		for agentID, perm := range acl {
+			updated.ACL[agentID] = perm
+		}
+		_, ok, err := s.swap(ctx, teamID, key, old, &updated)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return updated.entry(teamID, key), nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s keeps changing", ErrVersionConflict, key)
+}
+
+// check verifies lease ownership, the fencing token against the highest
+// one the record has accepted, and the expected version
+func (s *SharedStore) check(ctx context.Context, record *sharedRecord, teamID, key, agentID string, cond SharedPrecondition) error {
+	if err := s.locks.CheckWrite(ctx, teamID, key, agentID, cond.FencingToken); err != nil {
+		return err
+	}
+	if cond.FencingToken != 0 && cond.FencingToken < record.Fence {
+		return fmt.Errorf("%w: stale fencing token %d for %s", ErrLocked, cond.FencingToken, key)
+	}
+	if cond.Version != AnyVersion && cond.Version != record.Version {
+		return fmt.Errorf("%w: expected version %d, have %d", ErrVersionConflict, cond.Version, record.Version)
+	}
+	return nil
+}
+
+// fence returns the highest fencing token a record holds once a write
+// under cond is accepted
+func fence(record *sharedRecord, cond SharedPrecondition) uint64 {
+	if cond.FencingToken > record.Fence {
+		return cond.FencingToken
+	}
+	return record.Fence
+}
+
+// Modify performs a read-modify-write of a shared value, retrying up to
+// attempts times when another agent wins the race
+func (s *SharedStore) Modify(ctx context.Context, teamID, key, agentID string, attempts int, fn func(current json.RawMessage) (json.RawMessage, error)) (*SharedMemory, error) {
+	if attempts <= 0 {
+		attempts = DefaultModifyAttempts
+	}
+
+	var lastErr error
+	for i := 0; i < attempts; i++ {
+		current, err := s.Get(ctx, teamID, key)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := fn(current.Value())
+		if err != nil {
+			return nil, err
+		}
+
+		updated, err := s.Update(ctx, teamID, key, value, agentID, SharedPrecondition{Version: current.Version})
+		if err == nil {
+			return updated, nil
+		}
+		if !errors.Is(err, ErrVersionConflict) {
+			return nil, err
+		}
+		lastErr = err
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, lastErr
+}
+
+// ParseIfMatch extracts the expected version from an If-Match header.
+// An empty header or "*" yields AnyVersion.
+func ParseIfMatch(header string) (int, error) {
+	header = strings.TrimSpace(header)
+	if header == "" || header == "*" {
+		return AnyVersion, nil
+	}
+
+	header = strings.TrimPrefix(header, "W/")
+	version, err := strconv.Atoi(strings.Trim(header, `"`))
+	if err != nil || version < 1 {
+		return 0, errorf(ErrInvalid, "invalid If-Match header: %s", header)
+	}
+	return version, nil
+}
+
+// versionETag formats a shared value version as an ETag
+func versionETag(version int) string {
+	return strconv.Quote(strconv.Itoa(version))
+}
@@ -0,0 +1,188 @@
+package memoryos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// DefaultHistoryLimit is how many versions are kept per shared key
+const DefaultHistoryLimit = 50
+
+// SharedVersion is one entry in a shared key's history. Deletions are kept
+// as versions with a nil value so a deleted key can still be restored.
+type SharedVersion struct {
+	Version   int             `json:"version"`
+	Value     json.RawMessage `json:"value,omitempty"`
+	AgentID   string          `json:"agent_id"`
+	Change    ChangeType      `json:"change"`
+	Timestamp time.Time       `json:"timestamp"`
+}
+
+// SharedValueChange is a single difference between two shared value versions
+type SharedValueChange struct {
+	Path string      `json:"path"`
+	From interface{} `json:"from,omitempty"`
+	To   interface{} `json:"to,omitempty"`
+}
+
+// SharedValueDiff lists the differences between two shared value versions
+type SharedValueDiff struct {
+	Key     string              `json:"key"`
+	From    int                 `json:"from"`
+	To      int                 `json:"to"`
+	Changes []SharedValueChange `json:"changes"`
+}
+
+func sharedHistoryKey(teamID, key string) string {
+	return "history:" + sharedKey(teamID, key)
+}
+
+// appendHistory records a version in the key's history list in the
+// StateBackend, which drops the oldest entries past the limit
+func (s *SharedStore) appendHistory(ctx context.Context, teamID, key string, version SharedVersion) error {
+	data, err := json.Marshal(version)
+	if err != nil {
+		return err
+	}
+	return s.state.PushCapped(ctx, sharedHistoryKey(teamID, key), string(data), s.historyLimit)
+}
+
+// History lists the retained versions of a shared key, oldest first. A
+// value the manager holds without a history is adopted first, so it shows
+// up as the key's first version.
+func (s *SharedStore) History(ctx context.Context, teamID, key string) ([]SharedVersion, error) {
+	if _, _, err := s.load(ctx, teamID, key); err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	entries, err := s.state.Range(ctx, sharedHistoryKey(teamID, key))
+	if err != nil {
+		return nil, err
+	}
+	history := make([]SharedVersion, 0, len(entries))
+	for _, entry := range entries {
+		var version SharedVersion
+		if err := json.Unmarshal([]byte(entry), &version); err != nil {
+			return nil, fmt.Errorf("history of %s: %w", key, err)
+		}
+		history = append(history, version)
+	}
+	if len(history) == 0 {
+		return nil, errorf(ErrNotFound, "no history for %s", key)
+	}
+	// Concurrent writers may append out of order
+	sort.SliceStable(history, func(i, j int) bool { return history[i].Version < history[j].Version })
+	return history, nil
+}
+
+// At returns a specific version of a shared key
+func (s *SharedStore) At(ctx context.Context, teamID, key string, version int) (*SharedVersion, error) {
+	history, err := s.History(ctx, teamID, key)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range history {
+		if history[i].Version == version {
+			return &history[i], nil
+		}
+	}
+	return nil, errorf(ErrNotFound, "version %d of %s is not retained", version, key)
+}
+
+// AsOf returns the version of a shared key that was current at time t
+func (s *SharedStore) AsOf(ctx context.Context, teamID, key string, t time.Time) (*SharedVersion, error) {
+	history, err := s.History(ctx, teamID, key)
+	if err != nil {
+		return nil, err
+	}
+
+	i := sort.Search(len(history), func(i int) bool {
+		return history[i].Timestamp.After(t)
+	})
+	if i == 0 {
+		return nil, errorf(ErrNotFound, "no retained version of %s at %s", key, t.Format(time.RFC3339))
+	}
+	return &history[i-1], nil
+}
+
+// DiffVersions compares two versions of a shared key
+func (s *SharedStore) DiffVersions(ctx context.Context, teamID, key string, from, to int) (*SharedValueDiff, error) {
+	a, err := s.At(ctx, teamID, key, from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := s.At(ctx, teamID, key, to)
+	if err != nil {
+		return nil, err
+	}
+
+	var before, after interface{}
+	if len(a.Value) > 0 {
+		json.Unmarshal(a.Value, &before)
+	}
+	if len(b.Value) > 0 {
+		json.Unmarshal(b.Value, &after)
+	}
+
+	diff := &SharedValueDiff{Key: key, From: from, To: to, Changes: []SharedValueChange{}}
+	diffJSON("", before, after, &diff.Changes)
+	return diff, nil
+}
+
+// diffJSON walks two decoded JSON documents, descending into objects and
+// reporting arrays and scalars as whole-value changes
+func diffJSON(path string, a, b interface{}, changes *[]SharedValueChange) {
+	objA, okA := a.(map[string]interface{})
+	objB, okB := b.(map[string]interface{})
+	if !okA || !okB {
+		if !reflect.DeepEqual(a, b) {
+			if path == "" {
+				path = "/"
+			}
+			*changes = append(*changes, SharedValueChange{Path: path, From: a, To: b})
+		}
+		return
+	}
+
+	keys := make([]string, 0, len(objA)+len(objB))
+	for k := range objA {
+		keys = append(keys, k)
+	}
+	for k := range objB {
+		if _, ok := objA[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		diffJSON(path+"/"+k, objA[k], objB[k], changes)
+	}
+}
+
+// Restore writes an earlier version's value back as a new version. A key
+// that has since been deleted is recreated.
+func (s *SharedStore) Restore(ctx context.Context, teamID, key string, version int, agentID string, cond SharedPrecondition) (*SharedMemory, error) {
+	old, err := s.At(ctx, teamID, key, version)
+	if err != nil {
+		return nil, err
+	}
+	if old.Value == nil {
+		return nil, fmt.Errorf("%w: version %d of %s is a deletion", ErrInvalidValue, version, key)
+	}
+
+	_, err = s.Get(ctx, teamID, key)
+	if errors.Is(err, ErrNotFound) {
+		return s.Create(ctx, teamID, key, old.Value, agentID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.Update(ctx, teamID, key, old.Value, agentID, cond)
+}
@@ -0,0 +1,66 @@
+package memoryos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestSharedHistoryIsSharedAndCapped(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	store := newTestSharedStore(b)
+	store.historyLimit = 3
+
+	store.Create(ctx, "team", "k", json.RawMessage(`0`), "a")
+	for i := 1; i <= 4; i++ {
+		if _, err := store.Update(ctx, "team", "k", json.RawMessage(fmt.Sprint(i)), "a", SharedPrecondition{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Another instance reads the same history
+	history, err := newTestSharedStore(b).History(ctx, "team", "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 3 || history[0].Version != 3 || history[2].Version != 5 {
+		t.Fatalf("history = %+v", history)
+	}
+	if _, err := store.At(ctx, "team", "k", 1); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("evicted version: %v", err)
+	}
+}
+
+func TestSharedHistoryAdoptsManagerValue(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	b.shared.CreateSharedValue(ctx, "team", "k", `{"from":"cli"}`)
+
+	history, err := newTestSharedStore(b).History(ctx, "team", "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].Version != 1 || string(history[0].Value) != `{"from":"cli"}` {
+		t.Fatalf("history = %+v", history)
+	}
+}
+
+func TestSharedRestoreDeletedKey(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	store := newTestSharedStore(b)
+
+	store.Create(ctx, "team", "k", json.RawMessage(`"first"`), "a")
+	store.Delete(ctx, "team", "k", "a", SharedPrecondition{})
+
+	entry, err := newTestSharedStore(b).Restore(ctx, "team", "k", 1, "a", SharedPrecondition{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Version != 3 || entry.Content != `"first"` {
+		t.Fatalf("restored version %d %s", entry.Version, entry.Content)
+	}
+}
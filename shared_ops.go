@@ -0,0 +1,132 @@
+package memoryos
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// ErrInvalidValue is returned for shared values or operations that are not
+// valid JSON or do not fit the stored value
+var ErrInvalidValue = newError(ErrInvalid, "invalid shared value")
+
+// SharedOpType names a server-side operation on a structured shared value
+type SharedOpType string
+
+const (
+	SharedOpMerge  SharedOpType = "merge"  // JSON merge patch (RFC 7386)
+	SharedOpIncr   SharedOpType = "incr"   // add a number to a counter
+	SharedOpAppend SharedOpType = "append" // append an element to a list
+	SharedOpRemove SharedOpType = "remove" // remove every equal element from a list
+	SharedOpAdd    SharedOpType = "add"    // add an element to a set-like list
+)
+
+// SharedOp is an atomic operation applied to a shared value on the server
+type SharedOp struct {
+	Op    SharedOpType    `json:"op"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Apply runs the operation against the current value and returns the
+// new one. A missing current value is treated as null.
+func (op SharedOp) Apply(current json.RawMessage) (json.RawMessage, error) {
+	var doc interface{}
+	if len(current) > 0 {
+		if err := json.Unmarshal(current, &doc); err != nil {
+			return nil, fmt.Errorf("%w: current value is not JSON: %v", ErrInvalidValue, err)
+		}
+	}
+
+	var arg interface{}
+	if len(op.Value) > 0 {
+		if err := json.Unmarshal(op.Value, &arg); err != nil {
+			return nil, fmt.Errorf("%w: %s value: %v", ErrInvalidValue, op.Op, err)
+		}
+	}
+
+	var result interface{}
+	switch op.Op {
+	case SharedOpMerge:
+		result = mergePatch(doc, arg)
+	case SharedOpIncr:
+		counter, ok := doc.(float64)
+		if doc != nil && !ok {
+			return nil, fmt.Errorf("%w: incr requires a numeric value", ErrInvalidValue)
+		}
+		delta := 1.0
+		if arg != nil {
+			if delta, ok = arg.(float64); !ok {
+				return nil, fmt.Errorf("%w: incr amount must be a number", ErrInvalidValue)
+			}
+		}
+		result = counter + delta
+	case SharedOpAppend, SharedOpRemove, SharedOpAdd:
+		list, ok := doc.([]interface{})
+		if doc != nil && !ok {
+			return nil, fmt.Errorf("%w: %s requires a list value", ErrInvalidValue, op.Op)
+		}
+		result = applyListOp(op.Op, list, arg)
+	default:
+		return nil, fmt.Errorf("%w: unknown op %q", ErrInvalidValue, op.Op)
+	}
+
+	return json.Marshal(result)
+}
+
+func applyListOp(op SharedOpType, list []interface{}, element interface{}) []interface{} {
+	if list == nil {
+		list = []interface{}{}
+	}
+
+	switch op {
+	case SharedOpAppend:
+		return append(list, element)
+	case SharedOpAdd:
+		for _, existing := range list {
+			if reflect.DeepEqual(existing, element) {
+				return list
+			}
+		}
+		return append(list, element)
+	default:
+		kept := list[:0]
+		for _, existing := range list {
+			if !reflect.DeepEqual(existing, element) {
+				kept = append(kept, existing)
+			}
+		}
+		return kept
+	}
+}
+
+// mergePatch applies an RFC 7386 JSON merge patch to target
+func mergePatch(target, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = make(map[string]interface{})
+	}
+
+	for key, value := range patchObj {
+		if value == nil {
+			delete(targetObj, key)
+			continue
+		}
+		targetObj[key] = mergePatch(targetObj[key], value)
+	}
+	return targetObj
+}
+
+// normalizeSharedValue makes sure a stored value is JSON. Values written
+// before shared values were structured are wrapped as JSON strings.
+func normalizeSharedValue(value string) json.RawMessage {
+	if json.Valid([]byte(value)) {
+		return json.RawMessage(value)
+	}
+	encoded, _ := json.Marshal(value)
+	return encoded
+}
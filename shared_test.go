@@ -0,0 +1,205 @@
+package memoryos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestSharedStore(b *testBackends) *SharedStore {
+	return NewSharedStore(coreShared{b.shared}, b.state, NewLockManager(b.state), NewChangeFeed(b.state, DefaultFeedRetention))
+}
+
+func TestSharedStoreVersionsAreShared(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	// Two server instances on the same backends
+	one, two := newTestSharedStore(b), newTestSharedStore(b)
+
+	if _, err := one.Create(ctx, "team", "k", json.RawMessage(`1`), "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := two.Update(ctx, "team", "k", json.RawMessage(`2`), "b", SharedPrecondition{Version: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// one must see version 2 rather than its own stale version 1
+	_, err := one.Update(ctx, "team", "k", json.RawMessage(`3`), "a", SharedPrecondition{Version: 1})
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("stale write: %v", err)
+	}
+	entry, err := one.Get(ctx, "team", "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Version != 2 || entry.Content != "2" {
+		t.Fatalf("got version %d %s", entry.Version, entry.Content)
+	}
+}
+
+func TestSharedStoreConcurrentCompareAndSet(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	store := newTestSharedStore(b)
+	if _, err := store.Create(ctx, "team", "k", json.RawMessage(`0`), "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	const writers = 8
+	var wg sync.WaitGroup
+	results := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := newTestSharedStore(b).Update(ctx, "team", "k", json.RawMessage(`1`), "a", SharedPrecondition{Version: 1})
+			results <- err
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	won := 0
+	for err := range results {
+		switch {
+		case err == nil:
+			won++
+		case !errors.Is(err, ErrVersionConflict):
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if won != 1 {
+		t.Fatalf("%d writers won the same version", won)
+	}
+}
+
+func TestSharedStoreCreateConflictsWithManagerValue(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	store := newTestSharedStore(b)
+
+	// Written to the manager directly, as the CLI does
+	b.shared.CreateSharedValue(ctx, "team", "k", `"cli"`)
+
+	_, err := store.Create(ctx, "team", "k", json.RawMessage(`"api"`), "a")
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("create over an existing value: %v", err)
+	}
+	if v, _ := b.shared.GetSharedValue(ctx, "team", "k"); v != `"cli"` {
+		t.Fatalf("value overwritten: %s", v)
+	}
+}
+
+func TestSharedStoreDeleteContinuesNumbering(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	store := newTestSharedStore(b)
+
+	store.Create(ctx, "team", "k", json.RawMessage(`1`), "a")
+	if err := store.Delete(ctx, "team", "k", "a", SharedPrecondition{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(ctx, "team", "k"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("get after delete: %v", err)
+	}
+
+	entry, err := newTestSharedStore(b).Create(ctx, "team", "k", json.RawMessage(`2`), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Version != 3 {
+		t.Fatalf("recreated at version %d", entry.Version)
+	}
+	if keys, _ := store.Keys(ctx, "team"); len(keys) != 1 || keys[0] != "k" {
+		t.Fatalf("keys = %v", keys)
+	}
+}
+
+func TestSharedStoreApplyDoesNotCreateOnBackendError(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	store := newTestSharedStore(b)
+
+	store.Create(ctx, "team", "n", json.RawMessage(`5`), "a")
+	down := errors.New("dial tcp 127.0.0.1:6379: connect: connection refused")
+	b.shared.setFail(down)
+	// A record the store has not seen yet has to be read from the manager
+	_, err := store.Apply(ctx, "team", "other", "a", SharedOp{Op: SharedOpIncr, Value: json.RawMessage(`1`)}, SharedPrecondition{})
+	if !errors.Is(err, down) {
+		t.Fatalf("apply during an outage: %v", err)
+	}
+	b.shared.setFail(nil)
+
+	if _, err := store.Get(ctx, "team", "other"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("apply created the key during an outage: %v", err)
+	}
+	entry, err := store.Apply(ctx, "team", "n", "a", SharedOp{Op: SharedOpIncr, Value: json.RawMessage(`1`)}, SharedPrecondition{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Content != "6" || entry.Version != 2 {
+		t.Fatalf("got version %d %s", entry.Version, entry.Content)
+	}
+}
+
+func TestSharedStoreKeepsValuesOutOfTheManager(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	store := newTestSharedStore(b)
+
+	store.Create(ctx, "team", "k", json.RawMessage(`1`), "a")
+	// Once recorded, a key no longer depends on the manager
+	b.shared.setFail(errors.New("connection refused"))
+	entry, err := store.Update(ctx, "team", "k", json.RawMessage(`2`), "a", SharedPrecondition{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Version != 2 || entry.Content != "2" {
+		t.Fatalf("got version %d %s", entry.Version, entry.Content)
+	}
+	if err := store.Delete(ctx, "team", "k", "a", SharedPrecondition{}); err != nil {
+		t.Fatal(err)
+	}
+	b.shared.setFail(nil)
+
+	if v, err := b.shared.GetSharedValue(ctx, "team", "k"); err == nil {
+		t.Fatalf("manager holds a copy of the value: %s", v)
+	}
+	if _, err := newTestSharedStore(b).Get(ctx, "team", "k"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("deleted key readable: %v", err)
+	}
+}
+
+func TestSharedStoreRejectsLowerFencingToken(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	// Two instances whose lease views disagree, as after a failover of the
+	// lock state: each believes its own writer holds the key
+	oldLocks, newLocks := NewLockManager(NewLocalState()), NewLockManager(NewLocalState())
+	oldStore := NewSharedStore(coreShared{b.shared}, b.state, oldLocks, NewChangeFeed(b.state, DefaultFeedRetention))
+	newStore := NewSharedStore(coreShared{b.shared}, b.state, newLocks, NewChangeFeed(b.state, DefaultFeedRetention))
+
+	oldStore.Create(ctx, "team", "k", json.RawMessage(`0`), "a")
+	stale, _ := oldLocks.Acquire(ctx, "team", "k", "a", time.Minute)
+	newLocks.state.IncrBy(ctx, leaseTokenKey, int64(stale.Token))
+	current, _ := newLocks.Acquire(ctx, "team", "k", "b", time.Minute)
+
+	if _, err := newStore.Update(ctx, "team", "k", json.RawMessage(`1`), "b", SharedPrecondition{Version: AnyVersion, FencingToken: current.Token}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := oldStore.Update(ctx, "team", "k", json.RawMessage(`2`), "a", SharedPrecondition{Version: AnyVersion, FencingToken: stale.Token})
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("write with token %d after %d: %v", stale.Token, current.Token, err)
+	}
+	if err := oldStore.Delete(ctx, "team", "k", "a", SharedPrecondition{Version: AnyVersion, FencingToken: stale.Token}); !errors.Is(err, ErrLocked) {
+		t.Fatalf("delete with a stale token: %v", err)
+	}
+
+	entry, _ := newStore.Get(ctx, "team", "k")
+	if entry.Content != "1" {
+		t.Fatalf("value = %s", entry.Content)
+	}
+}
@@ -0,0 +1,317 @@
+package memoryos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SkillVersion is an immutable snapshot of a skill definition
+type SkillVersion struct {
+	Version   int         `json:"version"`
+	AgentID   string      `json:"agent_id"`
+	Skill     SkillMemory `json:"skill"`
+	Note      string      `json:"note,omitempty"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// SkillChange describes a single field that differs between two versions
+type SkillChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// SkillDiff is the set of changes between two versions of a skill
+type SkillDiff struct {
+	SkillName string        `json:"skill_name"`
+	From      int           `json:"from"`
+	To        int           `json:"to"`
+	Changes   []SkillChange `json:"changes"`
+}
+
+// SkillRegistry keeps versioned skill definitions on top of the SkillIndex.
+// Every publish appends a new version; rollback republishes an old one.
+// The versions of a skill are kept as a list in the StateBackend, each
+// holding the full definition, so they outlive the process and are shared
+// by every server instance. Only the latest definition is registered with
+// the SkillBackend.
+type SkillRegistry struct {
+	index SkillBackend
+	state StateBackend
+}
+
+// NewSkillRegistry creates a new skill registry
+func NewSkillRegistry(index SkillBackend, state StateBackend) *SkillRegistry {
+	return &SkillRegistry{index: index, state: state}
+}
+
+func skillNamesKey(agentID string) string {
+	return "skill-names:" + agentID
+}
+
+func skillVersionsKey(agentID, name string) string {
+	return "skill-versions:" + agentID + ":" + name
+}
+
+func skillCounterKey(agentID, name string) string {
+	return "skill-version:" + agentID + ":" + name
+}
+
+// Publish records a new version of a skill and registers it with the index
+func (r *SkillRegistry) Publish(ctx context.Context, agentID string, skill *SkillMemory, note string) (*SkillVersion, error) {
+	if skill.SkillName == "" {
+		return nil, errorf(ErrInvalid, "skill_name required")
+	}
+	if skill.ID == "" {
+		skill.ID = uuid.New().String()
+	}
+
+	number, err := r.state.IncrBy(ctx, skillCounterKey(agentID, skill.SkillName), 1)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	snapshot := *skill
+	snapshot.AgentID = agentID
+	snapshot.Type = MemoryTypeSkill
+	snapshot.UpdatedAt = now
+	if snapshot.CreatedAt.IsZero() {
+		snapshot.CreatedAt = now
+	}
+	version := &SkillVersion{
+		Version:   int(number),
+		AgentID:   agentID,
+		Skill:     snapshot,
+		Note:      note,
+		CreatedAt: now,
+	}
+	data, err := json.Marshal(version)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.state.PushCapped(ctx, skillVersionsKey(agentID, skill.SkillName), string(data), 0); err != nil {
+		return nil, err
+	}
+	if err := r.Register(ctx, agentID, &Skill{
+		ID:          skill.ID,
+		Name:        skill.SkillName,
+		Description: skill.Content,
+		Category:    skill.Category,
+		Mastery:     skill.Mastery,
+	}); err != nil {
+		return nil, err
+	}
+	return version, nil
+}
+
+// Register registers a skill with the index without recording a version.
+// The registry remembers the name so that Forget can delete the skill.
+func (r *SkillRegistry) Register(ctx context.Context, agentID string, skill *Skill) error {
+	if err := r.state.AddMember(ctx, skillNamesKey(agentID), skill.Name); err != nil {
+		return err
+	}
+	return r.index.RegisterSkill(ctx, agentID, skill)
+}
+
+// Versions lists every recorded version of a skill, oldest first
+func (r *SkillRegistry) Versions(ctx context.Context, agentID, name string) ([]*SkillVersion, error) {
+	records, err := r.state.Range(ctx, skillVersionsKey(agentID, name))
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]*SkillVersion, 0, len(records))
+	for _, record := range records {
+		var version SkillVersion
+		if err := json.Unmarshal([]byte(record), &version); err != nil {
+			return nil, fmt.Errorf("skill %s: %w", name, err)
+		}
+		versions = append(versions, &version)
+	}
+	if len(versions) == 0 {
+		return nil, errorf(ErrNotFound, "skill not found: %s", name)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+	return versions, nil
+}
+
+// Version returns a specific version of a skill; 0 means the latest
+func (r *SkillRegistry) Version(ctx context.Context, agentID, name string, version int) (*SkillVersion, error) {
+	versions, err := r.Versions(ctx, agentID, name)
+	if err != nil {
+		return nil, err
+	}
+	if version == 0 {
+		return versions[len(versions)-1], nil
+	}
+	for _, v := range versions {
+		if v.Version == version {
+			return v, nil
+		}
+	}
+	return nil, errorf(ErrNotFound, "skill %s has no version %d", name, version)
+}
+
+// Diff compares two versions of a skill field by field
+func (r *SkillRegistry) Diff(ctx context.Context, agentID, name string, from, to int) (*SkillDiff, error) {
+	a, err := r.Version(ctx, agentID, name, from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := r.Version(ctx, agentID, name, to)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &SkillDiff{SkillName: name, From: a.Version, To: b.Version, Changes: []SkillChange{}}
+	fields := []struct {
+		name     string
+		from, to interface{}
+	}{
+		{"description", a.Skill.Content, b.Skill.Content},
+		{"category", a.Skill.Category, b.Skill.Category},
+		{"parameters", a.Skill.Parameters, b.Skill.Parameters},
+		{"returns", a.Skill.Returns, b.Skill.Returns},
+		{"examples", a.Skill.Examples, b.Skill.Examples},
+		{"prerequisites", a.Skill.Prerequisites, b.Skill.Prerequisites},
+		{"mastery", a.Skill.Mastery, b.Skill.Mastery},
+		{"tags", a.Skill.Tags, b.Skill.Tags},
+	}
+	for _, f := range fields {
+		if !reflect.DeepEqual(f.from, f.to) {
+			diff.Changes = append(diff.Changes, SkillChange{Field: f.name, From: f.from, To: f.to})
+		}
+	}
+	return diff, nil
+}
+
+// Rollback republishes the full definition of an earlier version of a
+// skill as the newest version
+func (r *SkillRegistry) Rollback(ctx context.Context, agentID, name string, version int) (*SkillVersion, error) {
+	old, err := r.Version(ctx, agentID, name, version)
+	if err != nil {
+		return nil, err
+	}
+	skill := old.Skill
+	return r.Publish(ctx, agentID, &skill, fmt.Sprintf("rollback to version %d", old.Version))
+}
+
+// Forget deletes every skill an agent registered, from the index and with
+// its version history, and returns how many the index still held. A name
+// is only dropped once its skill is gone, so a failed Forget can be
+// called again.
+func (r *SkillRegistry) Forget(ctx context.Context, agentID string) (int, error) {
+	names, err := r.state.Members(ctx, skillNamesKey(agentID))
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, name := range names {
+		err := r.index.DeleteSkill(ctx, agentID, name)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return deleted, err
+		}
+		if err == nil {
+			deleted++
+		}
+		if err := r.state.Delete(ctx, skillVersionsKey(agentID, name)); err != nil {
+			return deleted, err
+		}
+		if err := r.state.Delete(ctx, skillCounterKey(agentID, name)); err != nil {
+			return deleted, err
+		}
+		if err := r.state.RemoveMember(ctx, skillNamesKey(agentID), name); err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// ========== TOOL DEFINITIONS ==========
+
+// ToolDefinition is a JSON-Schema function definition that can be handed
+// to an LLM as a function-calling tool
+type ToolDefinition struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Parameters  map[string]interface{} `json:"parameters"`
+	Returns     map[string]interface{} `json:"returns,omitempty"`
+	Examples    []string               `json:"examples,omitempty"`
+}
+
+// ToolDefinition exports the latest (or given) version of a skill as a tool
+func (r *SkillRegistry) ToolDefinition(ctx context.Context, agentID, name string, version int) (*ToolDefinition, error) {
+	v, err := r.Version(ctx, agentID, name, version)
+	if err != nil {
+		return nil, err
+	}
+	return SkillToolDefinition(&v.Skill), nil
+}
+
+// SkillToolDefinition builds a tool definition from a skill's Parameters,
+// Returns and Examples. Parameters are written as "name", "name:type" or
+// "name?:type"; a trailing "?" on the name marks the parameter optional.
+func SkillToolDefinition(skill *SkillMemory) *ToolDefinition {
+	properties := make(map[string]interface{})
+	required := []string{}
+
+	for _, param := range skill.Parameters {
+		name, typ := param, "string"
+		if i := strings.Index(param, ":"); i >= 0 {
+			name, typ = strings.TrimSpace(param[:i]), jsonSchemaType(param[i+1:])
+		}
+		optional := strings.HasSuffix(name, "?")
+		name = strings.TrimSuffix(name, "?")
+		if name == "" {
+			continue
+		}
+		properties[name] = map[string]interface{}{"type": typ}
+		if !optional {
+			required = append(required, name)
+		}
+	}
+	sort.Strings(required)
+
+	tool := &ToolDefinition{
+		Name:        skill.SkillName,
+		Description: skill.Content,
+		Parameters: map[string]interface{}{
+			"type":       "object",
+			"properties": properties,
+			"required":   required,
+		},
+		Examples: skill.Examples,
+	}
+	if skill.Returns != "" {
+		tool.Returns = map[string]interface{}{"type": jsonSchemaType(skill.Returns)}
+	}
+	return tool
+}
+
+// jsonSchemaType maps loosely written parameter types onto JSON-Schema types
+func jsonSchemaType(typ string) string {
+	switch strings.ToLower(strings.TrimSpace(typ)) {
+	case "int", "integer", "int64":
+		return "integer"
+	case "float", "float64", "number", "double":
+		return "number"
+	case "bool", "boolean":
+		return "boolean"
+	case "array", "list", "[]string":
+		return "array"
+	case "object", "map", "dict":
+		return "object"
+	default:
+		return "string"
+	}
+}
@@ -0,0 +1,111 @@
+package memoryos
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSkillRegistryVersionsOutliveRegistry(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	registry := NewSkillRegistry(b.skills, b.state)
+
+	first := &SkillMemory{SkillName: "search", Category: "tools", Parameters: []string{"query"}, Returns: "array", Examples: []string{"search(go)"}, Mastery: 0.4}
+	first.Content = "search the web"
+	if _, err := registry.Publish(ctx, "a", first, "initial"); err != nil {
+		t.Fatal(err)
+	}
+	second := &SkillMemory{SkillName: "search", Category: "web", Parameters: []string{"query", "limit?:int"}, Mastery: 0.6}
+	second.Content = "search with a limit"
+	if _, err := registry.Publish(ctx, "a", second, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// A registry of another server instance sees the same versions
+	other := NewSkillRegistry(b.skills, b.state)
+	versions, err := other.Versions(ctx, "a", "search")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[0].Version != 1 || versions[1].Version != 2 {
+		t.Fatalf("versions = %+v", versions)
+	}
+
+	rolledBack, err := other.Rollback(ctx, "a", "search", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rolledBack.Version != 3 {
+		t.Fatalf("rollback published version %d", rolledBack.Version)
+	}
+	got := rolledBack.Skill
+	if got.Category != "tools" || got.Returns != "array" || !reflect.DeepEqual(got.Parameters, []string{"query"}) || !reflect.DeepEqual(got.Examples, []string{"search(go)"}) {
+		t.Fatalf("rollback lost part of the definition: %+v", got)
+	}
+
+	live, err := b.skills.GetSkill(ctx, "a", "search")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if live.Description != "search the web" || live.Category != "tools" || live.Mastery != 0.4 {
+		t.Fatalf("live skill = %+v", live)
+	}
+}
+
+func TestSkillRegistryForget(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	registry := NewSkillRegistry(b.skills, b.state)
+
+	registry.Publish(ctx, "a", &SkillMemory{SkillName: "one"}, "")
+	registry.Publish(ctx, "a", &SkillMemory{SkillName: "two"}, "")
+	registry.Publish(ctx, "b", &SkillMemory{SkillName: "one"}, "")
+	registry.Register(ctx, "a", &Skill{Name: "plain"})
+
+	forgotten, err := registry.Forget(ctx, "a")
+	if err != nil || forgotten != 3 {
+		t.Fatalf("forgot %d: %v", forgotten, err)
+	}
+	for _, name := range []string{"one", "two", "plain"} {
+		if _, err := b.skills.GetSkill(ctx, "a", name); err == nil {
+			t.Fatalf("skill %s is still indexed", name)
+		}
+	}
+	if _, err := b.skills.GetSkill(ctx, "b", "one"); err != nil {
+		t.Fatalf("other agent's skill: %v", err)
+	}
+	if _, err := registry.Versions(ctx, "a", "one"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("versions after forget: %v", err)
+	}
+	if _, err := registry.Versions(ctx, "b", "one"); err != nil {
+		t.Fatalf("other agent's versions: %v", err)
+	}
+
+	version, err := registry.Publish(ctx, "a", &SkillMemory{SkillName: "one"}, "")
+	if err != nil || version.Version != 1 {
+		t.Fatalf("republished as version %d: %v", version.Version, err)
+	}
+}
+
+func TestSkillVersionsStayOutOfTheIndex(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	registry := NewSkillRegistry(b.skills, b.state)
+
+	for i := 0; i < 3; i++ {
+		if _, err := registry.Publish(ctx, "a", &SkillMemory{SkillName: "search", Category: "tools"}, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	skills, err := b.skills.GetSkillsByCategory(ctx, "a", "tools")
+	if err != nil || len(skills) != 1 || skills[0].Name != "search" {
+		t.Fatalf("index holds %+v: %v", skills, err)
+	}
+
+	registry.Forget(ctx, "a")
+	if keys, _ := b.state.Keys(ctx, "skill-"); len(keys) != 0 {
+		t.Fatalf("forget left %v", keys)
+	}
+}
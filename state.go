@@ -0,0 +1,565 @@
+package memoryos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultRedisAddr is the Redis server used when the config names none
+const DefaultRedisAddr = "localhost:6379"
+
+// stateKeyPrefix starts every key the server keeps in its StateBackend
+const stateKeyPrefix = "memoryos:"
+
+// StateBackend keeps the records that every server instance and the CLI
+// must agree on, such as shared value versions. A missing key reads as
+// ErrNotFound. *RedisState implements it on Redis; *LocalState keeps the
+// records in process for tests and single-process tools.
+type StateBackend interface {
+	Get(ctx context.Context, key string) (string, error)
+	// CompareAndSwap sets key to value if it still holds old, where an
+	// empty old means the key must not exist. A positive ttl expires the
+	// key; otherwise it is kept until deleted.
+	CompareAndSwap(ctx context.Context, key, old, value string, ttl time.Duration) (bool, error)
+	// CompareAndDelete deletes key if it still holds old
+	CompareAndDelete(ctx context.Context, key, old string) (bool, error)
+	Delete(ctx context.Context, key string) error
+	IncrBy(ctx context.Context, key string, delta int64) (int64, error)
+	// PushCapped appends value to the list at key and drops the oldest
+	// entries past limit
+	PushCapped(ctx context.Context, key, value string, limit int) error
+	// Range returns the list at key, oldest first
+	Range(ctx context.Context, key string) ([]string, error)
+	AddMember(ctx context.Context, key, member string) error
+	RemoveMember(ctx context.Context, key, member string) error
+	// Members returns the set at key in no particular order
+	Members(ctx context.Context, key string) ([]string, error)
+	// Keys lists the keys that start with prefix
+	Keys(ctx context.Context, prefix string) ([]string, error)
+	// Append adds value to the stream at key under the next offset,
+	// counting from 1, and drops the oldest entries past limit. Offsets
+	// keep growing after entries are dropped.
+	Append(ctx context.Context, key, value string, limit int) (uint64, error)
+	// ReadAfter returns up to count entries of the stream at key that come
+	// after offset, oldest first
+	ReadAfter(ctx context.Context, key string, after uint64, count int) ([]StreamEntry, error)
+	// StreamBounds returns the oldest offset the stream at key still
+	// holds, 0 if it holds none, and the last offset it handed out
+	StreamBounds(ctx context.Context, key string) (first, last uint64, err error)
+}
+
+// StreamEntry is one entry of a stream in a StateBackend
+type StreamEntry struct {
+	Offset uint64
+	Value  string
+}
+
+// newConfigState connects the server's state to the Redis server the
+// MemoryOS core was opened on, under its own key prefix
+func newConfigState(config *MemoryOSConfig) StateBackend {
+	addr := DefaultRedisAddr
+	if config != nil && config.RedisAddr != "" {
+		addr = config.RedisAddr
+	}
+	return prefixState(NewRedisState(redis.NewClient(&redis.Options{Addr: addr})), stateKeyPrefix)
+}
+
+// ========== REDIS STATE ==========
+
+// RedisState is a StateBackend on Redis. Compare-and-set and
+// compare-and-delete run as Lua scripts, so they are atomic across every
+// client of the Redis server.
+type RedisState struct {
+	client redis.UniversalClient
+}
+
+// NewRedisState creates a state backend on a Redis client
+func NewRedisState(client redis.UniversalClient) *RedisState {
+	return &RedisState{client: client}
+}
+
+var compareAndSwapScript = redis.NewScript(`
+local current = redis.call("GET", KEYS[1])
+if (current or "") ~= ARGV[1] then
+	return 0
+end
+if tonumber(ARGV[3]) > 0 then
+	redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+else
+	redis.call("SET", KEYS[1], ARGV[2])
+end
+return 1
+`)
+
+var compareAndDeleteScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// appendScript numbers a stream entry from a counter kept beside the
+// stream, so offsets survive trimming and are the same on every client
+var appendScript = redis.NewScript(`
+local offset = redis.call("INCR", KEYS[2])
+if tonumber(ARGV[2]) > 0 then
+	redis.call("XADD", KEYS[1], "MAXLEN", ARGV[2], "0-" .. offset, "value", ARGV[1])
+else
+	redis.call("XADD", KEYS[1], "0-" .. offset, "value", ARGV[1])
+end
+return offset
+`)
+
+// streamOffsetKey holds the last offset handed out by the stream at key
+func streamOffsetKey(key string) string {
+	return key + ":offset"
+}
+
+func (s *RedisState) Get(ctx context.Context, key string) (string, error) {
+	value, err := s.client.Get(ctx, key).Result()
+	return value, storageError(err)
+}
+
+func (s *RedisState) CompareAndSwap(ctx context.Context, key, old, value string, ttl time.Duration) (bool, error) {
+	swapped, err := compareAndSwapScript.Run(ctx, s.client, []string{key}, old, value, ttl.Milliseconds()).Int()
+	return swapped == 1, err
+}
+
+func (s *RedisState) CompareAndDelete(ctx context.Context, key, old string) (bool, error) {
+	deleted, err := compareAndDeleteScript.Run(ctx, s.client, []string{key}, old).Int()
+	return deleted == 1, err
+}
+
+func (s *RedisState) Delete(ctx context.Context, key string) error {
+	return s.client.Del(ctx, key, streamOffsetKey(key)).Err()
+}
+
+func (s *RedisState) IncrBy(ctx context.Context, key string, delta int64) (int64, error) {
+	return s.client.IncrBy(ctx, key, delta).Result()
+}
+
+func (s *RedisState) PushCapped(ctx context.Context, key, value string, limit int) error {
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.RPush(ctx, key, value)
+		pipe.LTrim(ctx, key, int64(-limit), -1)
+		return nil
+	})
+	return err
+}
+
+func (s *RedisState) Range(ctx context.Context, key string) ([]string, error) {
+	return s.client.LRange(ctx, key, 0, -1).Result()
+}
+
+func (s *RedisState) AddMember(ctx context.Context, key, member string) error {
+	return s.client.SAdd(ctx, key, member).Err()
+}
+
+func (s *RedisState) RemoveMember(ctx context.Context, key, member string) error {
+	return s.client.SRem(ctx, key, member).Err()
+}
+
+func (s *RedisState) Members(ctx context.Context, key string) ([]string, error) {
+	return s.client.SMembers(ctx, key).Result()
+}
+
+func (s *RedisState) Keys(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	var cursor uint64
+	for {
+		batch, next, err := s.client.Scan(ctx, cursor, escapeGlob(prefix)+"*", 100).Result()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, batch...)
+		if cursor = next; cursor == 0 {
+			return keys, nil
+		}
+	}
+}
+
+// Streams are Redis streams whose entry IDs are "0-<offset>"
+func (s *RedisState) Append(ctx context.Context, key, value string, limit int) (uint64, error) {
+	offset, err := appendScript.Run(ctx, s.client, []string{key, streamOffsetKey(key)}, value, limit).Int64()
+	return uint64(offset), err
+}
+
+func (s *RedisState) ReadAfter(ctx context.Context, key string, after uint64, count int) ([]StreamEntry, error) {
+	messages, err := s.client.XRangeN(ctx, key, "0-"+strconv.FormatUint(after+1, 10), "+", int64(count)).Result()
+	if err != nil {
+		return nil, err
+	}
+	return streamEntries(messages)
+}
+
+func (s *RedisState) StreamBounds(ctx context.Context, key string) (uint64, uint64, error) {
+	oldest, err := s.client.XRangeN(ctx, key, "-", "+", 1).Result()
+	if err != nil {
+		return 0, 0, err
+	}
+	var first uint64
+	if len(oldest) > 0 {
+		entries, err := streamEntries(oldest)
+		if err != nil {
+			return 0, 0, err
+		}
+		first = entries[0].Offset
+	}
+
+	last, err := s.client.Get(ctx, streamOffsetKey(key)).Uint64()
+	if errors.Is(err, redis.Nil) {
+		return first, 0, nil
+	}
+	return first, last, err
+}
+
+func streamEntries(messages []redis.XMessage) ([]StreamEntry, error) {
+	entries := make([]StreamEntry, 0, len(messages))
+	for _, message := range messages {
+		offset, err := strconv.ParseUint(strings.TrimPrefix(message.ID, "0-"), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("stream entry %s: %w", message.ID, err)
+		}
+		value, _ := message.Values["value"].(string)
+		entries = append(entries, StreamEntry{Offset: offset, Value: value})
+	}
+	return entries, nil
+}
+
+// escapeGlob quotes the characters SCAN MATCH treats as a pattern
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// ========== PREFIXED STATE ==========
+
+// prefixedState keeps a server's or tenant's records under their own key
+// prefix in a shared StateBackend
+type prefixedState struct {
+	base   StateBackend
+	prefix string
+}
+
+// prefixState returns a view of state whose keys all start with prefix
+func prefixState(state StateBackend, prefix string) StateBackend {
+	return &prefixedState{base: state, prefix: prefix}
+}
+
+func (s *prefixedState) Get(ctx context.Context, key string) (string, error) {
+	return s.base.Get(ctx, s.prefix+key)
+}
+
+func (s *prefixedState) CompareAndSwap(ctx context.Context, key, old, value string, ttl time.Duration) (bool, error) {
+	return s.base.CompareAndSwap(ctx, s.prefix+key, old, value, ttl)
+}
+
+func (s *prefixedState) CompareAndDelete(ctx context.Context, key, old string) (bool, error) {
+	return s.base.CompareAndDelete(ctx, s.prefix+key, old)
+}
+
+func (s *prefixedState) Delete(ctx context.Context, key string) error {
+	return s.base.Delete(ctx, s.prefix+key)
+}
+
+func (s *prefixedState) IncrBy(ctx context.Context, key string, delta int64) (int64, error) {
+	return s.base.IncrBy(ctx, s.prefix+key, delta)
+}
+
+func (s *prefixedState) PushCapped(ctx context.Context, key, value string, limit int) error {
+	return s.base.PushCapped(ctx, s.prefix+key, value, limit)
+}
+
+func (s *prefixedState) Range(ctx context.Context, key string) ([]string, error) {
+	return s.base.Range(ctx, s.prefix+key)
+}
+
+func (s *prefixedState) AddMember(ctx context.Context, key, member string) error {
+	return s.base.AddMember(ctx, s.prefix+key, member)
+}
+
+func (s *prefixedState) RemoveMember(ctx context.Context, key, member string) error {
+	return s.base.RemoveMember(ctx, s.prefix+key, member)
+}
+
+func (s *prefixedState) Members(ctx context.Context, key string) ([]string, error) {
+	return s.base.Members(ctx, s.prefix+key)
+}
+
+func (s *prefixedState) Append(ctx context.Context, key, value string, limit int) (uint64, error) {
+	return s.base.Append(ctx, s.prefix+key, value, limit)
+}
+
+func (s *prefixedState) ReadAfter(ctx context.Context, key string, after uint64, count int) ([]StreamEntry, error) {
+	return s.base.ReadAfter(ctx, s.prefix+key, after, count)
+}
+
+func (s *prefixedState) StreamBounds(ctx context.Context, key string) (uint64, uint64, error) {
+	return s.base.StreamBounds(ctx, s.prefix+key)
+}
+
+func (s *prefixedState) Keys(ctx context.Context, prefix string) ([]string, error) {
+	keys, err := s.base.Keys(ctx, s.prefix+prefix)
+	if err != nil {
+		return nil, err
+	}
+	for i, key := range keys {
+		keys[i] = strings.TrimPrefix(key, s.prefix)
+	}
+	return keys, nil
+}
+
+// ========== LOCAL STATE ==========
+
+// LocalState is a StateBackend held in process memory. It gives the same
+// guarantees as RedisState to everything sharing the value, but nothing
+// outside the process sees it.
+type LocalState struct {
+	mu      sync.Mutex
+	values  map[string]localValue
+	lists   map[string][]string
+	sets    map[string]map[string]bool
+	streams map[string]*localStream
+	now     func() time.Time
+}
+
+type localStream struct {
+	entries []StreamEntry
+	last    uint64
+}
+
+type localValue struct {
+	value   string
+	expires time.Time
+}
+
+// NewLocalState creates an empty in-process state backend
+func NewLocalState() *LocalState {
+	return &LocalState{
+		values:  make(map[string]localValue),
+		lists:   make(map[string][]string),
+		sets:    make(map[string]map[string]bool),
+		streams: make(map[string]*localStream),
+		now:     time.Now,
+	}
+}
+
+// get returns a live value, dropping it if expired. Callers hold s.mu.
+func (s *LocalState) get(key string) (string, bool) {
+	v, ok := s.values[key]
+	if !ok {
+		return "", false
+	}
+	if !v.expires.IsZero() && !s.now().Before(v.expires) {
+		delete(s.values, key)
+		return "", false
+	}
+	return v.value, true
+}
+
+func (s *LocalState) Get(ctx context.Context, key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, ok := s.get(key)
+	if !ok {
+		return "", errorf(ErrNotFound, "state key not found: %s", key)
+	}
+	return value, nil
+}
+
+func (s *LocalState) CompareAndSwap(ctx context.Context, key, old, value string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if current, _ := s.get(key); current != old {
+		return false, nil
+	}
+	v := localValue{value: value}
+	if ttl > 0 {
+		v.expires = s.now().Add(ttl)
+	}
+	s.values[key] = v
+	return true, nil
+}
+
+func (s *LocalState) CompareAndDelete(ctx context.Context, key, old string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if current, ok := s.get(key); !ok || current != old {
+		return false, nil
+	}
+	delete(s.values, key)
+	return true, nil
+}
+
+func (s *LocalState) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.values, key)
+	delete(s.lists, key)
+	delete(s.sets, key)
+	delete(s.streams, key)
+	return nil
+}
+
+func (s *LocalState) IncrBy(ctx context.Context, key string, delta int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	if current, ok := s.get(key); ok {
+		var err error
+		if n, err = strconv.ParseInt(current, 10, 64); err != nil {
+			return 0, errorf(ErrInvalid, "state key %s does not hold a counter", key)
+		}
+	}
+	n += delta
+	v := s.values[key]
+	v.value = strconv.FormatInt(n, 10)
+	s.values[key] = v
+	return n, nil
+}
+
+func (s *LocalState) PushCapped(ctx context.Context, key, value string, limit int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := append(s.lists[key], value)
+	if limit > 0 && len(list) > limit {
+		list = append([]string(nil), list[len(list)-limit:]...)
+	}
+	s.lists[key] = list
+	return nil
+}
+
+func (s *LocalState) Range(ctx context.Context, key string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.lists[key]...), nil
+}
+
+func (s *LocalState) AddMember(ctx context.Context, key, member string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sets[key] == nil {
+		s.sets[key] = make(map[string]bool)
+	}
+	s.sets[key][member] = true
+	return nil
+}
+
+func (s *LocalState) RemoveMember(ctx context.Context, key, member string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sets[key], member)
+	if len(s.sets[key]) == 0 {
+		delete(s.sets, key)
+	}
+	return nil
+}
+
+func (s *LocalState) Members(ctx context.Context, key string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	members := make([]string, 0, len(s.sets[key]))
+	for member := range s.sets[key] {
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+func (s *LocalState) Keys(ctx context.Context, prefix string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := []string{}
+	for key := range s.values {
+		if _, ok := s.get(key); ok && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range s.lists {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range s.sets {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range s.streams {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *LocalState) Append(ctx context.Context, key, value string, limit int) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stream := s.streams[key]
+	if stream == nil {
+		stream = &localStream{}
+		s.streams[key] = stream
+	}
+	stream.last++
+	stream.entries = append(stream.entries, StreamEntry{Offset: stream.last, Value: value})
+	if limit > 0 && len(stream.entries) > limit {
+		stream.entries = append([]StreamEntry(nil), stream.entries[len(stream.entries)-limit:]...)
+	}
+	return stream.last, nil
+}
+
+func (s *LocalState) ReadAfter(ctx context.Context, key string, after uint64, count int) ([]StreamEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := []StreamEntry{}
+	if stream := s.streams[key]; stream != nil {
+		for _, entry := range stream.entries {
+			if entry.Offset > after && len(entries) < count {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	return entries, nil
+}
+
+func (s *LocalState) StreamBounds(ctx context.Context, key string) (uint64, uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stream := s.streams[key]
+	if stream == nil {
+		return 0, 0, nil
+	}
+	var first uint64
+	if len(stream.entries) > 0 {
+		first = stream.entries[0].Offset
+	}
+	return first, stream.last, nil
+}
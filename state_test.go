@@ -0,0 +1,203 @@
+package memoryos
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestRedisState runs a RedisState against an in-process Redis server
+func newTestRedisState(t *testing.T) (*RedisState, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewRedisState(client), server
+}
+
+func TestLocalStateCompareAndSwap(t *testing.T) {
+	testCompareAndSwap(t, NewLocalState())
+}
+
+func TestRedisStateCompareAndSwap(t *testing.T) {
+	state, _ := newTestRedisState(t)
+	testCompareAndSwap(t, state)
+}
+
+func testCompareAndSwap(t *testing.T, state StateBackend) {
+	ctx := context.Background()
+
+	if ok, err := state.CompareAndSwap(ctx, "k", "", "a", 0); err != nil || !ok {
+		t.Fatalf("create: ok=%v err=%v", ok, err)
+	}
+	if ok, _ := state.CompareAndSwap(ctx, "k", "", "b", 0); ok {
+		t.Fatal("create over an existing key succeeded")
+	}
+	if ok, _ := state.CompareAndSwap(ctx, "k", "stale", "b", 0); ok {
+		t.Fatal("swap with a stale value succeeded")
+	}
+	if ok, _ := state.CompareAndSwap(ctx, "k", "a", "b", 0); !ok {
+		t.Fatal("swap with the current value failed")
+	}
+	if ok, _ := state.CompareAndDelete(ctx, "k", "a"); ok {
+		t.Fatal("delete with a stale value succeeded")
+	}
+	if ok, _ := state.CompareAndDelete(ctx, "k", "b"); !ok {
+		t.Fatal("delete with the current value failed")
+	}
+	if _, err := state.Get(ctx, "k"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("get after delete: %v", err)
+	}
+}
+
+func TestLocalStateExpiry(t *testing.T) {
+	ctx := context.Background()
+	state := NewLocalState()
+	now := time.Now()
+	state.now = func() time.Time { return now }
+
+	state.CompareAndSwap(ctx, "lease", "", "owner", time.Second)
+	now = now.Add(2 * time.Second)
+	if _, err := state.Get(ctx, "lease"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expired value still readable: %v", err)
+	}
+	if ok, _ := state.CompareAndSwap(ctx, "lease", "", "other", time.Second); !ok {
+		t.Fatal("could not take over an expired value")
+	}
+}
+
+func TestRedisStateExpiry(t *testing.T) {
+	ctx := context.Background()
+	state, server := newTestRedisState(t)
+
+	if ok, err := state.CompareAndSwap(ctx, "lease", "", "owner", time.Second); err != nil || !ok {
+		t.Fatalf("take lease: ok=%v err=%v", ok, err)
+	}
+	if ttl := server.TTL("lease"); ttl != time.Second {
+		t.Fatalf("ttl = %v", ttl)
+	}
+	server.FastForward(2 * time.Second)
+	if _, err := state.Get(ctx, "lease"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expired value still readable: %v", err)
+	}
+	if ok, _ := state.CompareAndSwap(ctx, "lease", "", "other", 0); !ok {
+		t.Fatal("could not take over an expired value")
+	}
+	if ttl := server.TTL("lease"); ttl != 0 {
+		t.Fatalf("value without a ttl expires in %v", ttl)
+	}
+}
+
+func TestLocalStateListsAndSets(t *testing.T) {
+	testListsAndSets(t, NewLocalState())
+}
+
+func TestRedisStateListsAndSets(t *testing.T) {
+	state, _ := newTestRedisState(t)
+	testListsAndSets(t, state)
+}
+
+func testListsAndSets(t *testing.T, state StateBackend) {
+	ctx := context.Background()
+
+	for _, v := range []string{"1", "2", "3", "4"} {
+		if err := state.PushCapped(ctx, "list", v, 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, _ := state.Range(ctx, "list"); !reflect.DeepEqual(got, []string{"2", "3", "4"}) {
+		t.Fatalf("capped list = %v", got)
+	}
+	state.PushCapped(ctx, "uncapped", "1", 0)
+	state.PushCapped(ctx, "uncapped", "2", 0)
+	if got, _ := state.Range(ctx, "uncapped"); !reflect.DeepEqual(got, []string{"1", "2"}) {
+		t.Fatalf("uncapped list = %v", got)
+	}
+
+	state.AddMember(ctx, "set", "b")
+	state.AddMember(ctx, "set", "a")
+	state.RemoveMember(ctx, "set", "b")
+	if got, _ := state.Members(ctx, "set"); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Fatalf("members = %v", got)
+	}
+
+	if n, _ := state.IncrBy(ctx, "counter", 5); n != 5 {
+		t.Fatalf("counter = %d", n)
+	}
+	if n, _ := state.IncrBy(ctx, "counter", -2); n != 3 {
+		t.Fatalf("counter = %d", n)
+	}
+}
+
+func TestPrefixStateIsolatesKeys(t *testing.T) {
+	ctx := context.Background()
+	base := NewLocalState()
+	a, b := prefixState(base, "a/"), prefixState(base, "b/")
+
+	a.CompareAndSwap(ctx, "k", "", "from-a", 0)
+	if _, err := b.Get(ctx, "k"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("prefix b sees prefix a: %v", err)
+	}
+	if keys, _ := a.Keys(ctx, ""); !reflect.DeepEqual(keys, []string{"k"}) {
+		t.Fatalf("keys = %v", keys)
+	}
+	if keys, _ := base.Keys(ctx, ""); !reflect.DeepEqual(keys, []string{"a/k"}) {
+		t.Fatalf("base keys = %v", keys)
+	}
+}
+
+func TestRedisStateKeysEscapesPatterns(t *testing.T) {
+	ctx := context.Background()
+	state, _ := newTestRedisState(t)
+
+	for _, key := range []string{"team[1]:a", "team1:b", "team*:c"} {
+		state.CompareAndSwap(ctx, key, "", "v", 0)
+	}
+	if keys, _ := state.Keys(ctx, "team[1]:"); !reflect.DeepEqual(keys, []string{"team[1]:a"}) {
+		t.Fatalf("keys = %v", keys)
+	}
+	if keys, _ := state.Keys(ctx, "team*"); !reflect.DeepEqual(keys, []string{"team*:c"}) {
+		t.Fatalf("keys = %v", keys)
+	}
+}
+
+func TestLocalStateStreams(t *testing.T) {
+	testStreams(t, NewLocalState())
+}
+
+func TestRedisStateStreams(t *testing.T) {
+	state, _ := newTestRedisState(t)
+	testStreams(t, state)
+}
+
+func testStreams(t *testing.T, state StateBackend) {
+	ctx := context.Background()
+
+	if first, last, err := state.StreamBounds(ctx, "stream"); err != nil || first != 0 || last != 0 {
+		t.Fatalf("empty stream bounds = %d, %d, %v", first, last, err)
+	}
+	for i, v := range []string{"a", "b", "c", "d"} {
+		offset, err := state.Append(ctx, "stream", v, 3)
+		if err != nil || offset != uint64(i+1) {
+			t.Fatalf("append %s: offset %d, %v", v, offset, err)
+		}
+	}
+	if first, last, _ := state.StreamBounds(ctx, "stream"); first != 2 || last != 4 {
+		t.Fatalf("bounds = %d, %d", first, last)
+	}
+	entries, err := state.ReadAfter(ctx, "stream", 2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(entries, []StreamEntry{{3, "c"}, {4, "d"}}) {
+		t.Fatalf("entries = %+v", entries)
+	}
+	if entries, _ := state.ReadAfter(ctx, "stream", 0, 1); len(entries) != 1 || entries[0].Offset != 2 {
+		t.Fatalf("first entry = %+v", entries)
+	}
+}
@@ -0,0 +1,164 @@
+package memoryos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MetaVisibility is the memory metadata key that controls whether a memory
+// appears in team views. Memories without it are visible to the team.
+const MetaVisibility = "visibility"
+
+const (
+	VisibilityPrivate = "private" // only the owning agent
+	VisibilityTeam    = "team"    // every member of the owner's teams
+)
+
+// Sources of team view results
+const (
+	SourceAgent  = "agent"
+	SourceShared = "shared"
+)
+
+// AttributedMemory is a team view result tagged with where it came from
+type AttributedMemory struct {
+	*Memory
+	SourceAgentID string `json:"source_agent_id"`
+	Source        string `json:"source"`
+	SharedKey     string `json:"shared_key,omitempty"`
+}
+
+// TeamMemoryView searches and builds context across every member of a team
+// plus the team's shared memory. Shared keys the caller may not read are
+// left out.
+type TeamMemoryView struct {
+	memoryos MemoryBackend
+	roster   *TeamRoster
+	shared   *SharedStore
+	authz    *Authorizer
+}
+
+// NewTeamMemoryView creates a new team memory view
+func NewTeamMemoryView(memoryos MemoryBackend, roster *TeamRoster, shared *SharedStore, authz *Authorizer) *TeamMemoryView {
+	return &TeamMemoryView{
+		memoryos: memoryos,
+		roster:   roster,
+		shared:   shared,
+		authz:    authz,
+	}
+}
+
+// visibleTo reports whether a member's memory may be shown to the caller
+func visibleTo(memory *Memory, callerID string) bool {
+	if memory.AgentID == callerID {
+		return true
+	}
+	visibility, _ := memory.Metadata[MetaVisibility].(string)
+	return visibility != VisibilityPrivate
+}
+
+// collect gathers the memories of every member visible to the caller and
+// the team's shared values matching the query. An empty query matches
+// everything.
+func (v *TeamMemoryView) collect(ctx context.Context, teamID, callerID, query string, perAgent int) ([]*AttributedMemory, error) {
+	members, err := v.roster.Members(ctx, teamID)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []*AttributedMemory{}
+	for _, member := range members {
+		memories, err := v.memoryos.SearchMemories(ctx, member.AgentID, query, perAgent)
+		if err != nil {
+			return nil, fmt.Errorf("search %s: %w", member.AgentID, err)
+		}
+		for _, memory := range memories {
+			if visibleTo(memory, callerID) {
+				results = append(results, &AttributedMemory{Memory: memory, SourceAgentID: memory.AgentID, Source: SourceAgent})
+			}
+		}
+	}
+
+	namespace := TeamScope(teamID).Namespace()
+	needle := strings.ToLower(query)
+	keys, err := v.shared.Keys(ctx, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("shared keys: %w", err)
+	}
+	for _, key := range keys {
+		if err := v.authz.AuthorizeShared(ctx, callerID, TeamScope(teamID), key, ActionRead); err != nil {
+			if errors.Is(err, ErrForbidden) {
+				continue
+			}
+			return nil, err
+		}
+		entry, err := v.shared.Get(ctx, namespace, key)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("shared %s: %w", key, err)
+		}
+		if needle != "" && !strings.Contains(strings.ToLower(key+" "+entry.Content), needle) {
+			continue
+		}
+		results = append(results, &AttributedMemory{Memory: &entry.Memory, SourceAgentID: entry.AgentID, Source: SourceShared, SharedKey: key})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Importance != results[j].Importance {
+			return results[i].Importance > results[j].Importance
+		}
+		return results[i].UpdatedAt.After(results[j].UpdatedAt)
+	})
+	return results, nil
+}
+
+// Search runs a query across the team, most important results first
+func (v *TeamMemoryView) Search(ctx context.Context, teamID, callerID, query string, limit int) ([]*AttributedMemory, error) {
+	results, err := v.collect(ctx, teamID, callerID, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// ContextWindow builds a prompt context from the team's most important
+// memories and shared values, each line attributed to its source, until
+// maxTokens is reached
+func (v *TeamMemoryView) ContextWindow(ctx context.Context, teamID, callerID string, maxTokens int) (string, error) {
+	results, err := v.collect(ctx, teamID, callerID, "", queryScanLimit)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	used := 0
+	for _, result := range results {
+		var line string
+		if result.Source == SourceShared {
+			line = fmt.Sprintf("[team %s] %s = %s\n", teamID, result.SharedKey, result.Content)
+		} else {
+			line = fmt.Sprintf("[%s] (%s) %s\n", result.SourceAgentID, result.Type, result.Content)
+		}
+
+		tokens := estimateTokens(line)
+		if used+tokens > maxTokens {
+			break
+		}
+		b.WriteString(line)
+		used += tokens
+	}
+	return b.String(), nil
+}
+
+// estimateTokens approximates a token count at four characters per token
+func estimateTokens(text string) int {
+	return (len(text) + 3) / 4
+}
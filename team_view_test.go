@@ -0,0 +1,38 @@
+package memoryos
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestTeamViewHonoursSharedKeyACLs(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	s := newTestServer(t, b)
+	registerTestAgent(t, s, "owner")
+	registerTestAgent(t, s, "member")
+	s.roster.Create(ctx, &Team{ID: "team", Name: "team"}, "owner")
+	s.roster.AddMember(ctx, "team", "member", TeamRoleWriter)
+
+	s.shared.Create(ctx, "team", "public", json.RawMessage(`"open plan"`), "owner")
+	s.shared.Create(ctx, "team", "secret", json.RawMessage(`"hidden plan"`), "owner")
+	s.shared.SetACL(ctx, "team", "secret", map[string]string{"member": PermissionNone})
+
+	for _, target := range []string{"/team/search?team_id=team&q=plan", "/team/context?team_id=team"} {
+		rec := serve(t, s, http.MethodGet, target, "member", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d %s", target, rec.Code, rec.Body)
+		}
+		if body := rec.Body.String(); strings.Contains(body, "hidden") || !strings.Contains(body, "open plan") {
+			t.Fatalf("%s as member:\n%s", target, body)
+		}
+	}
+
+	results, err := s.views.Search(ctx, "team", "owner", "plan", 0)
+	if err != nil || len(results) != 2 {
+		t.Fatalf("owner sees %d results: %v", len(results), err)
+	}
+}
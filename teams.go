@@ -0,0 +1,342 @@
+package memoryos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// TeamRole is a member's role within a team
+type TeamRole string
+
+const (
+	TeamRoleOwner  TeamRole = "owner"  // manage members and every key
+	TeamRoleWriter TeamRole = "writer" // read and write shared keys
+	TeamRoleReader TeamRole = "reader" // read shared keys only
+)
+
+// TeamMember is an agent's membership in a team
+type TeamMember struct {
+	AgentID  string    `json:"agent_id"`
+	Role     TeamRole  `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// State keys of a team's roster and of the set of teams an agent belongs
+// to
+func rosterRecordKey(teamID string) string {
+	return "roster:" + teamID
+}
+
+func agentTeamsKey(agentID string) string {
+	return "agent-teams:" + agentID
+}
+
+type rosterRecord struct {
+	Members map[string]*TeamMember `json:"members"`
+	Deleted bool                   `json:"deleted,omitempty"`
+}
+
+// TeamRoster manages team membership and per-member roles. Rosters are kept
+// in the StateBackend and changed through a compare-and-set, so concurrent
+// changes on any server instance or the CLI never overwrite each other.
+// Teams created before the roster existed are adopted with their Members
+// as writers.
+type TeamRoster struct {
+	manager SharedBackend
+	state   StateBackend
+	shared  *SharedStore
+}
+
+// NewTeamRoster creates a new team roster
+func NewTeamRoster(manager SharedBackend, state StateBackend, shared *SharedStore) *TeamRoster {
+	return &TeamRoster{
+		manager: manager,
+		state:   state,
+		shared:  shared,
+	}
+}
+
+// ParseTeamRole validates a role name; an empty name means writer
+func ParseTeamRole(role string) (TeamRole, error) {
+	switch TeamRole(role) {
+	case "":
+		return TeamRoleWriter, nil
+	case TeamRoleOwner, TeamRoleWriter, TeamRoleReader:
+		return TeamRole(role), nil
+	default:
+		return "", errorf(ErrInvalid, "unknown team role: %s", role)
+	}
+}
+
+// read returns a team's stored roster, or nil if it has none, along with
+// the raw form that a compare-and-set must match
+func (r *TeamRoster) read(ctx context.Context, teamID string) (*rosterRecord, string, error) {
+	raw, err := r.state.Get(ctx, rosterRecordKey(teamID))
+	if errors.Is(err, ErrNotFound) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	var record rosterRecord
+	if err := json.Unmarshal([]byte(raw), &record); err != nil {
+		return nil, "", fmt.Errorf("roster of team %s: %w", teamID, err)
+	}
+	return &record, raw, nil
+}
+
+// load returns a live team's roster and its raw form, adopting a team the
+// manager holds without a roster. Missing and deleted teams are
+// ErrNotFound.
+func (r *TeamRoster) load(ctx context.Context, teamID string) (*rosterRecord, string, error) {
+	record, raw, err := r.read(ctx, teamID)
+	if err != nil {
+		return nil, "", err
+	}
+	if record != nil {
+		if record.Deleted {
+			return nil, "", errorf(ErrNotFound, "team not found: %s", teamID)
+		}
+		return record, raw, nil
+	}
+
+	team, err := r.manager.GetTeam(ctx, teamID)
+	if err != nil {
+		return nil, "", err
+	}
+	record = &rosterRecord{Members: make(map[string]*TeamMember)}
+	for _, agentID := range team.Members {
+		record.Members[agentID] = &TeamMember{AgentID: agentID, Role: TeamRoleWriter, JoinedAt: team.CreatedAt}
+	}
+	return record, "", nil
+}
+
+// swap writes record if the stored roster is still old ("" when there was
+// none)
+func (r *TeamRoster) swap(ctx context.Context, teamID, old string, record *rosterRecord) (bool, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return false, err
+	}
+	return r.state.CompareAndSwap(ctx, rosterRecordKey(teamID), old, string(data), 0)
+}
+
+// update lets change edit a team's roster and stores it through a
+// compare-and-set, rerunning change on the new roster when another writer
+// got there first
+func (r *TeamRoster) update(ctx context.Context, teamID string, change func(record *rosterRecord) error) (*rosterRecord, error) {
+	for attempt := 0; attempt < sharedWriteAttempts; attempt++ {
+		record, old, err := r.load(ctx, teamID)
+		if err != nil {
+			return nil, err
+		}
+		if err := change(record); err != nil {
+			return nil, err
+		}
+		ok, err := r.swap(ctx, teamID, old, record)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return record, nil
+		}
+	}
+	return nil, errorf(ErrConflict, "roster of team %s keeps changing", teamID)
+}
+
+// Create records the initial roster for a new team. The owner, if given,
+// gets the owner role; the team's other Members become writers. A team
+// that already has a roster is ErrConflict; a deleted team may be created
+// again.
+func (r *TeamRoster) Create(ctx context.Context, team *Team, ownerID string) error {
+	if err := ValidateTeamID(team.ID); err != nil {
+		return err
+	}
+
+	previous, old, err := r.read(ctx, team.ID)
+	if err != nil {
+		return err
+	}
+	if previous != nil && !previous.Deleted {
+		return errorf(ErrConflict, "team already exists: %s", team.ID)
+	}
+
+	now := time.Now()
+	record := &rosterRecord{Members: make(map[string]*TeamMember)}
+	for _, agentID := range team.Members {
+		record.Members[agentID] = &TeamMember{AgentID: agentID, Role: TeamRoleWriter, JoinedAt: now}
+	}
+	if ownerID != "" {
+		record.Members[ownerID] = &TeamMember{AgentID: ownerID, Role: TeamRoleOwner, JoinedAt: now}
+	}
+
+	ok, err := r.swap(ctx, team.ID, old, record)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errorf(ErrConflict, "team already exists: %s", team.ID)
+	}
+	for agentID := range record.Members {
+		if err := r.state.AddMember(ctx, agentTeamsKey(agentID), team.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Members lists a team's members ordered by agent ID
+func (r *TeamRoster) Members(ctx context.Context, teamID string) ([]TeamMember, error) {
+	record, _, err := r.load(ctx, teamID)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]TeamMember, 0, len(record.Members))
+	for _, member := range record.Members {
+		members = append(members, *member)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].AgentID < members[j].AgentID
+	})
+	return members, nil
+}
+
+// Member returns an agent's membership in a team
+func (r *TeamRoster) Member(ctx context.Context, teamID, agentID string) (*TeamMember, error) {
+	record, _, err := r.load(ctx, teamID)
+	if err != nil {
+		return nil, err
+	}
+	member, ok := record.Members[agentID]
+	if !ok {
+		return nil, errorf(ErrNotFound, "%s is not a member of team %s", agentID, teamID)
+	}
+	copied := *member
+	return &copied, nil
+}
+
+// AddMember adds an agent to a team, or changes its role if it is already
+// a member
+func (r *TeamRoster) AddMember(ctx context.Context, teamID, agentID string, role TeamRole) (*TeamMember, error) {
+	var member TeamMember
+	_, err := r.update(ctx, teamID, func(record *rosterRecord) error {
+		current, ok := record.Members[agentID]
+		if !ok {
+			current = &TeamMember{AgentID: agentID, JoinedAt: time.Now()}
+			record.Members[agentID] = current
+		} else if current.Role == TeamRoleOwner && role != TeamRoleOwner && countOwners(record) == 1 {
+			return errorf(ErrConflict, "cannot demote the last owner of team %s", teamID)
+		}
+		current.Role = role
+		member = *current
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.state.AddMember(ctx, agentTeamsKey(agentID), teamID); err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+// RemoveMember removes an agent from a team. The last owner cannot leave.
+func (r *TeamRoster) RemoveMember(ctx context.Context, teamID, agentID string) error {
+	_, err := r.update(ctx, teamID, func(record *rosterRecord) error {
+		member, ok := record.Members[agentID]
+		if !ok {
+			return errorf(ErrNotFound, "%s is not a member of team %s", agentID, teamID)
+		}
+		if member.Role == TeamRoleOwner && countOwners(record) == 1 {
+			return errorf(ErrConflict, "cannot remove the last owner of team %s", teamID)
+		}
+		delete(record.Members, agentID)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return r.state.RemoveMember(ctx, agentTeamsKey(agentID), teamID)
+}
+
+// RemoveAgent drops an agent from every team it belongs to and forgets its
+// team list. Unlike RemoveMember it may remove a team's last owner, since
+// the agent is being deregistered.
+func (r *TeamRoster) RemoveAgent(ctx context.Context, agentID string) error {
+	teams, err := r.state.Members(ctx, agentTeamsKey(agentID))
+	if err != nil {
+		return err
+	}
+
+	errNotMember := errors.New("not a member")
+	for _, teamID := range teams {
+		_, err := r.update(ctx, teamID, func(record *rosterRecord) error {
+			if _, ok := record.Members[agentID]; !ok {
+				return errNotMember
+			}
+			delete(record.Members, agentID)
+			return nil
+		})
+		if err != nil && !errors.Is(err, ErrNotFound) && !errors.Is(err, errNotMember) {
+			return err
+		}
+	}
+	return r.state.Delete(ctx, agentTeamsKey(agentID))
+}
+
+// TeamsFor lists the teams an agent belongs to
+func (r *TeamRoster) TeamsFor(ctx context.Context, agentID string) ([]string, error) {
+	teams, err := r.state.Members(ctx, agentTeamsKey(agentID))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(teams)
+	return teams, nil
+}
+
+// DeleteTeam removes the team's shared keys, marks the team deleted and
+// drops every member's index entry
+func (r *TeamRoster) DeleteTeam(ctx context.Context, teamID string) error {
+	if _, _, err := r.load(ctx, teamID); err != nil {
+		return err
+	}
+	if err := r.shared.DeleteNamespace(ctx, TeamScope(teamID).Namespace()); err != nil {
+		return err
+	}
+
+	var members []string
+	_, err := r.update(ctx, teamID, func(record *rosterRecord) error {
+		members = members[:0]
+		for agentID := range record.Members {
+			members = append(members, agentID)
+		}
+		record.Members = map[string]*TeamMember{}
+		record.Deleted = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, agentID := range members {
+		if err := r.state.RemoveMember(ctx, agentTeamsKey(agentID), teamID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func countOwners(record *rosterRecord) int {
+	owners := 0
+	for _, member := range record.Members {
+		if member.Role == TeamRoleOwner {
+			owners++
+		}
+	}
+	return owners
+}
@@ -0,0 +1,157 @@
+package memoryos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestRosterRecordsLiveOutsideTeamNamespaces(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	s := newTestServer(t, b)
+	s.roster.Create(ctx, &Team{ID: "victim", Name: "victim"}, "owner")
+
+	// Rosters are state records, not shared values a team could address
+	if keys, _ := s.shared.Keys(ctx, "victim"); len(keys) != 0 {
+		t.Fatalf("roster stored in the team namespace: %v", keys)
+	}
+	if err := s.roster.Create(ctx, &Team{ID: "roster:victim"}, "mallory"); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("team roster:victim: %v", err)
+	}
+
+	members, err := s.roster.Members(ctx, "victim")
+	if err != nil || len(members) != 1 || members[0].AgentID != "owner" {
+		t.Fatalf("members = %+v, %v", members, err)
+	}
+}
+
+func TestRosterAdoptsExistingTeams(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServer(t, newTestBackends())
+	// Created before the roster existed
+	s.manager.CreateTeam(ctx, &Team{ID: "legacy", Name: "legacy", Members: []string{"a"}})
+
+	member, err := s.roster.Member(ctx, "legacy", "a")
+	if err != nil || member.Role != TeamRoleWriter {
+		t.Fatalf("adopted member = %+v, %v", member, err)
+	}
+	if _, err := s.roster.AddMember(ctx, "legacy", "b", TeamRoleReader); err != nil {
+		t.Fatal(err)
+	}
+	if members, _ := s.roster.Members(ctx, "legacy"); len(members) != 2 {
+		t.Fatalf("members = %+v", members)
+	}
+	teams, err := s.roster.TeamsFor(ctx, "nobody")
+	if err != nil || len(teams) != 0 {
+		t.Fatalf("teams for an unknown agent = %v, %v", teams, err)
+	}
+}
+
+func TestRosterChangesDoNotOverwriteEachOther(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	// Two server instances on the same state
+	one, two := newTestServer(t, b), newTestServer(t, b)
+	one.roster.Create(ctx, &Team{ID: "team", Name: "team"}, "owner")
+	if err := two.roster.Create(ctx, &Team{ID: "team", Name: "team"}, "mallory"); !errors.Is(err, ErrConflict) {
+		t.Fatalf("second create: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		roster := one.roster
+		if i%2 == 1 {
+			roster = two.roster
+		}
+		wg.Add(1)
+		go func(agentID string) {
+			defer wg.Done()
+			if _, err := roster.AddMember(ctx, "team", agentID, TeamRoleWriter); err != nil {
+				t.Error(err)
+			}
+		}(fmt.Sprintf("agent-%d", i))
+	}
+	wg.Wait()
+
+	members, _ := one.roster.Members(ctx, "team")
+	if len(members) != 21 {
+		t.Fatalf("%d members after 20 concurrent joins", len(members))
+	}
+}
+
+func TestDeleteTeamRemovesSharedKeys(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	s := newTestServer(t, b)
+	s.roster.Create(ctx, &Team{ID: "team", Name: "team"}, "owner")
+	s.shared.Create(ctx, "team", "k", json.RawMessage(`1`), "owner")
+
+	if err := s.roster.DeleteTeam(ctx, "team"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.manager.GetSharedValue(ctx, "team", "k"); err == nil {
+		t.Fatal("shared key survived team deletion")
+	}
+	if teams, _ := s.roster.TeamsFor(ctx, "owner"); len(teams) != 0 {
+		t.Fatalf("owner still indexed in %v", teams)
+	}
+	if _, err := s.roster.Members(ctx, "team"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("members of a deleted team: %v", err)
+	}
+}
+
+func TestCreateTeamRequiresCallerAndFreeID(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServer(t, newTestBackends())
+	registerTestAgent(t, s, "owner")
+	registerTestAgent(t, s, "mallory")
+
+	for _, target := range []string{"/team", "/v1/teams"} {
+		if rec := serve(t, s, http.MethodPost, target, "", `{"ID":"anon"}`); rec.Code != http.StatusForbidden {
+			t.Fatalf("%s: anonymous create: %d %s", target, rec.Code, rec.Body)
+		}
+	}
+	if rec := serve(t, s, http.MethodPost, "/team", "owner", `{"ID":"team","Name":"team"}`); rec.Code != http.StatusOK {
+		t.Fatalf("create: %d %s", rec.Code, rec.Body)
+	}
+	for _, target := range []string{"/team", "/v1/teams"} {
+		if rec := serve(t, s, http.MethodPost, target, "mallory", `{"ID":"team","Name":"taken"}`); rec.Code != http.StatusConflict {
+			t.Fatalf("%s: create over an existing team: %d %s", target, rec.Code, rec.Body)
+		}
+	}
+	if member, err := s.roster.Member(ctx, "team", "owner"); err != nil || member.Role != TeamRoleOwner {
+		t.Fatalf("owner = %+v, %v", member, err)
+	}
+	if _, err := s.roster.Member(ctx, "team", "mallory"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("mallory joined through a second create: %v", err)
+	}
+}
+
+func TestTeamAndAgentReadsRequireAuthorization(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServer(t, newTestBackends())
+	registerTestAgent(t, s, "owner")
+	registerTestAgent(t, s, "outsider")
+	s.manager.CreateTeam(ctx, &Team{ID: "team", Name: "team"})
+	s.roster.Create(ctx, &Team{ID: "team", Name: "team"}, "owner")
+
+	if rec := serve(t, s, http.MethodGet, "/team?id=team", "outsider", ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("non-member read a team: %d %s", rec.Code, rec.Body)
+	}
+	if rec := serve(t, s, http.MethodGet, "/v1/teams/team", "owner", ""); rec.Code != http.StatusOK {
+		t.Fatalf("member read: %d %s", rec.Code, rec.Body)
+	}
+	for _, target := range []string{"/agent?id=owner", "/agent", "/v1/agents"} {
+		if rec := serve(t, s, http.MethodGet, target, "", ""); rec.Code != http.StatusForbidden {
+			t.Fatalf("%s: anonymous read: %d %s", target, rec.Code, rec.Body)
+		}
+	}
+	if rec := serve(t, s, http.MethodGet, "/agent?id=owner", "outsider", ""); rec.Code != http.StatusOK {
+		t.Fatalf("registered agent read: %d %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,488 @@
+package memoryos
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// Headers that select the tenant a request belongs to. An API key maps to
+// exactly one tenant; the tenant header may name it explicitly.
+const (
+	TenantHeader = "X-Tenant-ID"
+	APIKeyHeader = "X-API-Key"
+)
+
+// tenantKeyPrefix starts every storage key written on behalf of a tenant
+const tenantKeyPrefix = "tenant/"
+
+// DefaultTenantID is the tenant of requests that belong to no other. It is
+// stored unprefixed, where data written before tenants existed lives; no
+// agent, team or key of the default tenant can start with a tenant prefix,
+// since none of those IDs may contain "/".
+const DefaultTenantID = "default"
+
+// tenantStorage is the unprefixed storage that every tenant's backends wrap
+type tenantStorage struct {
+	memory MemoryBackend
+	shared SharedBackend
+	skills SkillBackend
+	state  StateBackend
+}
+
+// scoped returns a tenant's prefixed view of the storage. The default
+// tenant's view validates IDs the same way but adds no prefix.
+func (st *tenantStorage) scoped(tenantID string) (MemoryBackend, SharedBackend, SkillBackend, StateBackend) {
+	prefix := ""
+	if tenantID != DefaultTenantID {
+		prefix = tenantKeyPrefix + tenantID + "/"
+	}
+	return &tenantMemory{base: st.memory, prefix: prefix},
+		&tenantShared{base: st.shared, prefix: prefix, tenantID: tenantID},
+		&tenantSkills{base: st.skills, prefix: prefix},
+		prefixState(st.state, prefix)
+}
+
+// newTenantServer builds a tenant's server on its view of the storage
+func newTenantServer(storage *tenantStorage, tenantID string) *Server {
+	server := newServer(storage.scoped(tenantID))
+	server.tenantID = tenantID
+	return server
+}
+
+// ValidateTenantID rejects tenant IDs that could reach into another
+// tenant's keys, and the reserved default tenant
+func ValidateTenantID(tenantID string) error {
+	if tenantID == "" || tenantID == DefaultTenantID || strings.ContainsAny(tenantID, "/:") {
+		return errorf(ErrInvalid, "invalid tenant id: %q", tenantID)
+	}
+	return nil
+}
+
+// TenantRegistry maps tenants to their own isolated server. Each tenant
+// server has its own locks, change feed, roster and agent directory, and
+// prefixes every agent, team and shared memory key it hands to storage.
+// Requests that belong to no tenant go to the default server.
+type TenantRegistry struct {
+	base    *Server
+	storage *tenantStorage
+	mu      sync.RWMutex
+	tenants map[string]*Server
+}
+
+// NewTenantRegistry creates a registry whose default tenant is base and
+// whose tenants are built on storage
+func NewTenantRegistry(base *Server, storage *tenantStorage) *TenantRegistry {
+	return &TenantRegistry{
+		base:    base,
+		storage: storage,
+		tenants: make(map[string]*Server),
+	}
+}
+
+// Add registers a tenant and the hashes of the API keys that resolve to
+// it (see HashAPIKey). The hashes are kept by the server's authenticator
+// with the agent keys. Adding an existing tenant only adds keys.
+func (t *TenantRegistry) Add(tenantID string, keyHashes ...string) error {
+	if err := ValidateTenantID(tenantID); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	id := &Identity{TenantID: tenantID, Subject: "tenant " + tenantID, Method: "api_key"}
+	if err := t.base.auth.addKeys(keyHashes, id); err != nil {
+		return err
+	}
+
+	if _, ok := t.tenants[tenantID]; !ok {
+		server := newTenantServer(t.storage, tenantID)
+		server.closing = t.base.closing
+		server.validator.SetLimits(t.base.validator.Limits())
+		server.dedup.SetConfig(t.base.dedup.Config())
+		t.tenants[tenantID] = server
+	}
+	return nil
+}
+
+// Load registers tenants from a spec of the form
+// "tenant1=sha256:hash1,sha256:hash2;tenant2=sha256:hash3"
+func (t *TenantRegistry) Load(spec string) error {
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, hashes, _ := strings.Cut(entry, "=")
+		var keyHashes []string
+		for _, hash := range strings.Split(hashes, ",") {
+			if hash = strings.TrimSpace(hash); hash != "" {
+				keyHashes = append(keyHashes, hash)
+			}
+		}
+		if err := t.Add(strings.TrimSpace(id), keyHashes...); err != nil {
+			return fmt.Errorf("tenant %s: %w", strings.TrimSpace(id), err)
+		}
+	}
+	return nil
+}
+
+// SetQuotas sets the quotas of a tenant; an empty ID means the default
+// tenant
+func (t *TenantRegistry) SetQuotas(tenantID string, policy QuotaPolicy) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	server := t.base
+	if tenantID != "" && tenantID != DefaultTenantID {
+		var ok bool
+		if server, ok = t.tenants[tenantID]; !ok {
+			return errorf(ErrNotFound, "unknown tenant %s", tenantID)
+		}
+	}
+	server.quotas.SetPolicy(policy)
+	return nil
+}
+
+// SetValidationLimits sets the memory validation limits of every tenant,
+// including the default one
+func (t *TenantRegistry) SetValidationLimits(limits ValidationLimits) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	t.base.validator.SetLimits(limits)
+	for _, server := range t.tenants {
+		server.validator.SetLimits(limits)
+	}
+}
+
+// SetDedup sets the deduplication policies of every tenant, including the
+// default one
+func (t *TenantRegistry) SetDedup(config DedupConfig) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	t.base.dedup.SetConfig(config)
+	for _, server := range t.tenants {
+		server.dedup.SetConfig(config)
+	}
+}
+
+// Tenants lists the registered tenant IDs
+func (t *TenantRegistry) Tenants() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	ids := make([]string, 0, len(t.tenants))
+	for id := range t.tenants {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Resolve picks the server for a request from its authenticated identity.
+// The tenant header is only honoured when it names the tenant the caller
+// authenticated as; a caller that did not authenticate belongs to the
+// default tenant.
+func (t *TenantRegistry) Resolve(r *http.Request) (*Server, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	tenantID := DefaultTenantID
+	if id, ok := IdentityFromContext(r.Context()); ok && id.TenantID != "" {
+		tenantID = id.TenantID
+	}
+	if requested := r.Header.Get(TenantHeader); requested != "" && requested != tenantID {
+		return nil, fmt.Errorf("%w: caller does not belong to tenant %s", ErrForbidden, requested)
+	}
+	if tenantID == DefaultTenantID {
+		return t.base, nil
+	}
+
+	server, ok := t.tenants[tenantID]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown tenant %s", ErrForbidden, tenantID)
+	}
+	return server, nil
+}
+
+// TenantStats aggregates memory statistics over a tenant's agents
+type TenantStats struct {
+	TenantID      string         `json:"tenant_id,omitempty"`
+	Agents        int            `json:"agents"`
+	TotalMemories int            `json:"total_memories"`
+	ByType        map[string]int `json:"by_type"`
+	TotalTokens   int            `json:"total_tokens"`
+	AvgImportance float64        `json:"avg_importance"`
+	Quota         []QuotaUsage   `json:"quota,omitempty"`
+}
+
+// tenantStats reports the tenant's counted totals, the ones its quota is
+// enforced with, and the average importance over every agent in the
+// directory
+func (s *Server) tenantStats(ctx context.Context) (*TenantStats, error) {
+	agents, err := s.agents.List(ctx, AgentFilter{})
+	if err != nil {
+		return nil, err
+	}
+	usage, err := s.quotas.TenantUsage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &TenantStats{
+		TenantID:      s.tenantID,
+		Agents:        len(agents),
+		TotalMemories: usage.TotalMemories,
+		ByType:        usage.ByType,
+		TotalTokens:   usage.TotalTokens,
+		Quota:         quotaUsage(s.quotas.Policy().Tenant, usage),
+	}
+	importance, counted := 0.0, 0
+	for _, agent := range agents {
+		agentStats, err := s.memoryos.GetMemoryStats(ctx, agent.AgentID)
+		if err != nil {
+			return nil, fmt.Errorf("stats for %s: %w", agent.AgentID, err)
+		}
+		importance += agentStats.AvgImportance * float64(agentStats.TotalMemories)
+		counted += agentStats.TotalMemories
+	}
+	if counted > 0 {
+		stats.AvgImportance = importance / float64(counted)
+	}
+	return stats, nil
+}
+
+// ========== TENANT STORAGE ==========
+
+// tenantMemory prefixes agent IDs so a tenant's memories live apart from
+// every other tenant's
+type tenantMemory struct {
+	base   MemoryBackend
+	prefix string
+}
+
+func (m *tenantMemory) scoped(memory *Memory) *Memory {
+	if memory == nil {
+		return nil
+	}
+	copied := *memory
+	copied.AgentID = strings.TrimPrefix(memory.AgentID, m.prefix)
+	return &copied
+}
+
+func (m *tenantMemory) write(memory *Memory, fn func(*Memory) error) error {
+	if err := ValidateAgentID(memory.AgentID); err != nil {
+		return err
+	}
+	agentID := memory.AgentID
+	stored := *memory
+	stored.AgentID = m.prefix + agentID
+	if err := fn(&stored); err != nil {
+		return err
+	}
+	*memory = stored
+	memory.AgentID = agentID
+	return nil
+}
+
+func (m *tenantMemory) StoreMemory(ctx context.Context, memory *Memory) error {
+	return m.write(memory, func(stored *Memory) error { return m.base.StoreMemory(ctx, stored) })
+}
+
+func (m *tenantMemory) StoreMemories(ctx context.Context, memories []*Memory, opts BatchOptions) []error {
+	stored := make([]*Memory, len(memories))
+	errs := make([]error, len(memories))
+	for i, memory := range memories {
+		errs[i] = ValidateAgentID(memory.AgentID)
+		copied := *memory
+		copied.AgentID = m.prefix + memory.AgentID
+		stored[i] = &copied
+	}
+	errs = forwardBatch(ctx, m.base, stored, errs, opts)
+	for i, memory := range memories {
+		if errs[i] == nil {
+			agentID := memory.AgentID
+			*memory = *stored[i]
+			memory.AgentID = agentID
+		}
+	}
+	return errs
+}
+
+func (m *tenantMemory) GetMemory(ctx context.Context, agentID string, memoryType MemoryType, id string) (*Memory, error) {
+	memory, err := m.base.GetMemory(ctx, m.prefix+agentID, memoryType, id)
+	if err != nil {
+		return nil, err
+	}
+	return m.scoped(memory), nil
+}
+
+func (m *tenantMemory) DeleteMemory(ctx context.Context, agentID string, memoryType MemoryType, id string) error {
+	return m.base.DeleteMemory(ctx, m.prefix+agentID, memoryType, id)
+}
+
+func (m *tenantMemory) UpdateMemory(ctx context.Context, memory *Memory) error {
+	return m.write(memory, func(stored *Memory) error { return m.base.UpdateMemory(ctx, stored) })
+}
+
+func (m *tenantMemory) SearchMemories(ctx context.Context, agentID, query string, limit int) ([]*Memory, error) {
+	memories, err := m.base.SearchMemories(ctx, m.prefix+agentID, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	scoped := make([]*Memory, len(memories))
+	for i, memory := range memories {
+		scoped[i] = m.scoped(memory)
+	}
+	return scoped, nil
+}
+
+func (m *tenantMemory) GetContextWindow(ctx context.Context, agentID string, maxTokens int) (string, error) {
+	return m.base.GetContextWindow(ctx, m.prefix+agentID, maxTokens)
+}
+
+func (m *tenantMemory) GetMemoryStats(ctx context.Context, agentID string) (*MemoryStats, error) {
+	stats, err := m.base.GetMemoryStats(ctx, m.prefix+agentID)
+	if err != nil {
+		return nil, err
+	}
+	copied := *stats
+	copied.AgentID = agentID
+	return &copied, nil
+}
+
+// tenantShared prefixes agent IDs, team IDs and shared memory namespaces
+type tenantShared struct {
+	base     SharedBackend
+	prefix   string
+	tenantID string
+}
+
+// GetSystemHealth reports only the overall status; the backend's counters
+// span every tenant
+func (m *tenantShared) GetSystemHealth(ctx context.Context) (map[string]interface{}, error) {
+	health, err := m.base.GetSystemHealth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status, ok := health["status"]
+	if !ok {
+		status = "healthy"
+	}
+	return map[string]interface{}{"status": status, "tenant": m.tenantID}, nil
+}
+
+func (m *tenantShared) RegisterAgent(ctx context.Context, agent *Agent) error {
+	if agent.ID == "" {
+		agent.ID = uuid.New().String()
+	}
+	if err := ValidateAgentID(agent.ID); err != nil {
+		return err
+	}
+	agentID := agent.ID
+	stored := *agent
+	stored.ID = m.prefix + agentID
+	if err := m.base.RegisterAgent(ctx, &stored); err != nil {
+		return err
+	}
+	*agent = stored
+	agent.ID = agentID
+	return nil
+}
+
+func (m *tenantShared) GetAgent(ctx context.Context, agentID string) (*Agent, error) {
+	agent, err := m.base.GetAgent(ctx, m.prefix+agentID)
+	if err != nil {
+		return nil, err
+	}
+	copied := *agent
+	copied.ID = strings.TrimPrefix(agent.ID, m.prefix)
+	return &copied, nil
+}
+
+func (m *tenantShared) CreateTeam(ctx context.Context, team *Team) error {
+	if team.ID == "" {
+		team.ID = uuid.New().String()
+	}
+	if err := ValidateTeamID(team.ID); err != nil {
+		return err
+	}
+	teamID, members := team.ID, team.Members
+	stored := *team
+	stored.ID = m.prefix + teamID
+	stored.Members = m.prefixAll(members)
+	if err := m.base.CreateTeam(ctx, &stored); err != nil {
+		return err
+	}
+	*team = stored
+	team.ID, team.Members = teamID, members
+	return nil
+}
+
+func (m *tenantShared) GetTeam(ctx context.Context, teamID string) (*Team, error) {
+	team, err := m.base.GetTeam(ctx, m.prefix+teamID)
+	if err != nil {
+		return nil, err
+	}
+	copied := *team
+	copied.ID = teamID
+	copied.Members = make([]string, len(team.Members))
+	for i, member := range team.Members {
+		copied.Members[i] = strings.TrimPrefix(member, m.prefix)
+	}
+	return &copied, nil
+}
+
+func (m *tenantShared) CreateSharedValue(ctx context.Context, teamID, key, value string) error {
+	return m.base.CreateSharedValue(ctx, m.prefix+teamID, key, value)
+}
+
+func (m *tenantShared) GetSharedValue(ctx context.Context, teamID, key string) (string, error) {
+	return m.base.GetSharedValue(ctx, m.prefix+teamID, key)
+}
+
+func (m *tenantShared) UpdateSharedValue(ctx context.Context, teamID, key, value string) error {
+	return m.base.UpdateSharedValue(ctx, m.prefix+teamID, key, value)
+}
+
+func (m *tenantShared) DeleteSharedValue(ctx context.Context, teamID, key string) error {
+	return m.base.DeleteSharedValue(ctx, m.prefix+teamID, key)
+}
+
+func (m *tenantShared) prefixAll(ids []string) []string {
+	prefixed := make([]string, len(ids))
+	for i, id := range ids {
+		prefixed[i] = m.prefix + id
+	}
+	return prefixed
+}
+
+// tenantSkills prefixes the agent IDs skills are indexed under
+type tenantSkills struct {
+	base   SkillBackend
+	prefix string
+}
+
+func (m *tenantSkills) RegisterSkill(ctx context.Context, agentID string, skill *Skill) error {
+	return m.base.RegisterSkill(ctx, m.prefix+agentID, skill)
+}
+
+func (m *tenantSkills) GetSkill(ctx context.Context, agentID, name string) (*Skill, error) {
+	return m.base.GetSkill(ctx, m.prefix+agentID, name)
+}
+
+func (m *tenantSkills) GetSkillsByCategory(ctx context.Context, agentID, category string) ([]*Skill, error) {
+	return m.base.GetSkillsByCategory(ctx, m.prefix+agentID, category)
+}
+
+func (m *tenantSkills) DeleteSkill(ctx context.Context, agentID, name string) error {
+	return m.base.DeleteSkill(ctx, m.prefix+agentID, name)
+}
@@ -0,0 +1,132 @@
+package memoryos
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDefaultTenantIsUnprefixed(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	// Written before tenants existed
+	b.memory.StoreMemory(ctx, &Memory{ID: "old", AgentID: "a", Type: MemoryTypeSemantic, Content: "legacy"})
+	s := newTestServer(t, b)
+	registerTestAgent(t, s, "a")
+	if err := s.AddTenant("acme", "acme-key"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.memoryos.GetMemory(ctx, "a", "", "old"); err != nil {
+		t.Fatalf("existing memory hidden from the default tenant: %v", err)
+	}
+	if err := s.memoryos.StoreMemory(ctx, &Memory{ID: "m", AgentID: "a", Type: MemoryTypeSemantic, Content: "fact"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.memory.GetMemory(ctx, "a", "", "m"); err != nil {
+		t.Fatalf("default tenant memory stored under a prefix: %v", err)
+	}
+	if _, err := b.shared.GetAgent(ctx, "a"); err != nil {
+		t.Fatalf("default tenant agent stored under a prefix: %v", err)
+	}
+	if err := s.memoryos.StoreMemory(ctx, &Memory{AgentID: "tenant/acme/a", Type: MemoryTypeSemantic, Content: "x"}); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("default tenant reached into a tenant prefix: %v", err)
+	}
+
+	// A tenant's prefix is applied once, to raw storage
+	tenant := s.tenants.tenants["acme"]
+	tenant.manager.RegisterAgent(ctx, &Agent{ID: "b"})
+	if _, err := b.shared.GetAgent(ctx, tenantKeyPrefix+"acme/b"); err != nil {
+		t.Fatalf("tenant agent stored under the wrong key: %v", err)
+	}
+}
+
+func TestTenantIDsAreValidated(t *testing.T) {
+	s := newTestServer(t, newTestBackends())
+	for _, id := range []string{"", DefaultTenantID, "a/b", "a:b"} {
+		if err := s.AddTenant(id); !errors.Is(err, ErrInvalid) {
+			t.Errorf("tenant %q: %v", id, err)
+		}
+	}
+
+	ctx := context.Background()
+	if err := s.manager.RegisterAgent(ctx, &Agent{ID: "x/y"}); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("agent x/y: %v", err)
+	}
+	if err := s.manager.CreateTeam(ctx, &Team{ID: "x/y"}); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("team x/y: %v", err)
+	}
+	if err := s.memoryos.StoreMemory(ctx, &Memory{AgentID: "x/y", Type: MemoryTypeSemantic, Content: "fact"}); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("memory of agent x/y: %v", err)
+	}
+}
+
+func TestTenantHeaderNeedsMatchingCredentials(t *testing.T) {
+	s := newTestServer(t, newTestBackends())
+	if err := s.AddTenant("acme", "acme-key"); err != nil {
+		t.Fatal(err)
+	}
+
+	health := func(headers map[string]string) int {
+		req := httptest.NewRequest(http.MethodGet, "/health", nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := health(map[string]string{TenantHeader: "acme"}); code != http.StatusForbidden {
+		t.Fatalf("tenant header without credentials: status %d", code)
+	}
+	if code := health(map[string]string{TenantHeader: DefaultTenantID}); code != http.StatusOK {
+		t.Fatalf("default tenant header: status %d", code)
+	}
+	if code := health(map[string]string{APIKeyHeader: "acme-key", TenantHeader: "acme"}); code != http.StatusOK {
+		t.Fatalf("matching api key: status %d", code)
+	}
+	if code := health(map[string]string{APIKeyHeader: "acme-key", TenantHeader: "other"}); code != http.StatusForbidden {
+		t.Fatalf("api key of another tenant: status %d", code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/health", nil)
+	req.Header.Set(TenantHeader, DefaultTenantID)
+	req = req.WithContext(WithIdentity(req.Context(), &Identity{AgentID: "a", TenantID: "acme"}))
+	if _, err := s.tenants.Resolve(req); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("identity of another tenant: %v", err)
+	}
+}
+
+func TestTenantKeysNameTheirAgentWithTheHeader(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	s := newTestServer(t, b)
+	if err := s.SetAuth(&AuthConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddTenant("acme", "acme-key"); err != nil {
+		t.Fatal(err)
+	}
+	acme := s.tenants.tenants["acme"]
+	acme.manager.RegisterAgent(ctx, &Agent{ID: "a", Permissions: []string{PermissionRead, PermissionWrite}})
+	// An agent of the default tenant
+	s.manager.RegisterAgent(ctx, &Agent{ID: "d", Permissions: []string{PermissionRead, PermissionWrite}})
+
+	stats := func(agentID string) int {
+		req := httptest.NewRequest(http.MethodGet, "/stats?agent_id="+agentID, nil)
+		req.Header.Set(APIKeyHeader, "acme-key")
+		req.Header.Set(AgentIDHeader, agentID)
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := stats("a"); code != http.StatusOK {
+		t.Fatalf("tenant agent: status %d", code)
+	}
+	if code := stats("d"); code != http.StatusForbidden {
+		t.Fatalf("agent of the default tenant through a tenant key: status %d", code)
+	}
+}
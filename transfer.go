@@ -0,0 +1,302 @@
+package memoryos
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// queryScanLimit bounds how many candidate memories a MemoryQuery considers
+const queryScanLimit = 10000
+
+// Provenance metadata recorded on transferred memories
+const (
+	MetaSourceAgentID  = "source_agent_id"
+	MetaSourceMemoryID = "source_memory_id"
+	MetaTransferredAt  = "transferred_at"
+)
+
+// sharedMemoryKeyPrefix prefixes the shared keys that memories transferred
+// into team shared memory are stored under
+const sharedMemoryKeyPrefix = "memory:"
+
+// TransferMode selects whether transferred memories stay with the source
+type TransferMode string
+
+const (
+	TransferCopy TransferMode = "copy"
+	TransferMove TransferMode = "move"
+)
+
+// TransferRequest describes a memory handoff. Exactly one of ToAgentID and
+// ToTeamID is set; the query's AgentID is the source agent.
+type TransferRequest struct {
+	Query     MemoryQuery  `json:"query"`
+	ToAgentID string       `json:"to_agent_id,omitempty"`
+	ToTeamID  string       `json:"to_team_id,omitempty"`
+	Mode      TransferMode `json:"mode"`
+}
+
+// TransferResult reports what a transfer did. Transferred maps source
+// memory IDs to the IDs (or shared keys) of their copies.
+type TransferResult struct {
+	Matched     int               `json:"matched"`
+	Transferred map[string]string `json:"transferred"`
+	Duplicates  []string          `json:"duplicates"`
+	Removed     int               `json:"removed"`
+}
+
+// TransferError reports a transfer that failed part-way, with what it did
+// before failing
+type TransferError struct {
+	Result *TransferResult
+	err    error
+}
+
+func (e *TransferError) Error() string {
+	return e.err.Error()
+}
+
+func (e *TransferError) Unwrap() error {
+	return e.err
+}
+
+// ErrorDetails reports the partial result in error responses
+func (e *TransferError) ErrorDetails() interface{} {
+	return e.Result
+}
+
+// MemoryTransfer copies or moves memories between agents and into team
+// shared memory
+type MemoryTransfer struct {
+	memoryos MemoryBackend
+	shared   *SharedStore
+}
+
+// NewMemoryTransfer creates a new memory transfer
+func NewMemoryTransfer(memoryos MemoryBackend, shared *SharedStore) *MemoryTransfer {
+	return &MemoryTransfer{
+		memoryos: memoryos,
+		shared:   shared,
+	}
+}
+
+// Validate checks the request's mode and target
+func (req *TransferRequest) Validate() error {
+	if req.Mode == "" {
+		req.Mode = TransferCopy
+	}
+	if req.Mode != TransferCopy && req.Mode != TransferMove {
+		return errorf(ErrInvalid, "unknown transfer mode: %s", req.Mode)
+	}
+	if req.Query.AgentID == "" {
+		return errorf(ErrInvalid, "query.agent_id required")
+	}
+	if (req.ToAgentID == "") == (req.ToTeamID == "") {
+		return errorf(ErrInvalid, "exactly one of to_agent_id and to_team_id required")
+	}
+	if req.ToAgentID == req.Query.AgentID {
+		return errorf(ErrInvalid, "cannot transfer memories to their own agent")
+	}
+	return nil
+}
+
+// Find returns the memories matching a query
+func (t *MemoryTransfer) Find(ctx context.Context, query MemoryQuery) ([]*Memory, error) {
+	candidates, err := t.memoryos.SearchMemories(ctx, query.AgentID, strings.Join(query.Keywords, " "), queryScanLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	matched := []*Memory{}
+	for _, memory := range candidates {
+		if query.Matches(memory) {
+			matched = append(matched, memory)
+		}
+	}
+
+	if query.Offset > 0 {
+		if query.Offset >= len(matched) {
+			return []*Memory{}, nil
+		}
+		matched = matched[query.Offset:]
+	}
+	if query.Limit > 0 && len(matched) > query.Limit {
+		matched = matched[:query.Limit]
+	}
+	return matched, nil
+}
+
+// Matches reports whether a memory satisfies every condition of the query.
+// All tags and all keywords must be present.
+func (q MemoryQuery) Matches(memory *Memory) bool {
+	if q.Type != nil && memory.Type != *q.Type {
+		return false
+	}
+	if memory.Importance < q.MinImportance {
+		return false
+	}
+	if q.Since != nil && memory.CreatedAt.Before(*q.Since) {
+		return false
+	}
+	for _, tag := range q.Tags {
+		if !containsString(memory.Tags, tag) {
+			return false
+		}
+	}
+	content := strings.ToLower(memory.Content)
+	for _, keyword := range q.Keywords {
+		if !strings.Contains(content, strings.ToLower(keyword)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Transfer copies or moves the memories matching the request's query.
+// Memories the target already holds, either as an earlier transfer of the
+// same original or with the same type and content, are not copied again.
+// A failure part-way returns the partial result and a *TransferError.
+func (t *MemoryTransfer) Transfer(ctx context.Context, req TransferRequest) (*TransferResult, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	memories, err := t.Find(ctx, req.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	var existing []*Memory
+	if req.ToAgentID != "" {
+		existing, err = t.memoryos.SearchMemories(ctx, req.ToAgentID, "", queryScanLimit)
+	} else {
+		existing, err = t.teamMemories(ctx, req.ToTeamID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, 2*len(existing))
+	for _, memory := range existing {
+		for _, k := range dedupKeys(memory) {
+			seen[k] = true
+		}
+	}
+
+	result := &TransferResult{
+		Matched:     len(memories),
+		Transferred: make(map[string]string),
+		Duplicates:  []string{},
+	}
+	now := time.Now()
+	for _, memory := range memories {
+		copied := provenanceCopy(memory, now)
+		keys := dedupKeys(copied)
+
+		duplicate := false
+		for _, k := range keys {
+			duplicate = duplicate || seen[k]
+		}
+		if duplicate {
+			result.Duplicates = append(result.Duplicates, memory.ID)
+		} else {
+			target, err := t.store(ctx, req, copied)
+			if err != nil {
+				return result, &TransferError{Result: result, err: fmt.Errorf("transfer %s: %w", memory.ID, err)}
+			}
+			result.Transferred[memory.ID] = target
+			for _, k := range keys {
+				seen[k] = true
+			}
+		}
+
+		// A duplicate is already at the target, so a move still removes it
+		if req.Mode == TransferMove {
+			if err := t.memoryos.DeleteMemory(ctx, memory.AgentID, memory.Type, memory.ID); err != nil {
+				return result, &TransferError{Result: result, err: fmt.Errorf("remove %s: %w", memory.ID, err)}
+			}
+			result.Removed++
+		}
+	}
+	return result, nil
+}
+
+// store writes a transferred memory to the target agent or team
+func (t *MemoryTransfer) store(ctx context.Context, req TransferRequest, memory *Memory) (string, error) {
+	if req.ToAgentID != "" {
+		memory.AgentID = req.ToAgentID
+		if err := t.memoryos.StoreMemory(ctx, memory); err != nil {
+			return "", err
+		}
+		return memory.ID, nil
+	}
+
+	memory.AgentID = req.Query.AgentID
+	value, err := json.Marshal(memory)
+	if err != nil {
+		return "", err
+	}
+	key := sharedMemoryKeyPrefix + memory.ID
+	if _, err := t.shared.Create(ctx, TeamScope(req.ToTeamID).Namespace(), key, value, req.Query.AgentID); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// teamMemories decodes the memories previously transferred into a team
+func (t *MemoryTransfer) teamMemories(ctx context.Context, teamID string) ([]*Memory, error) {
+	namespace := TeamScope(teamID).Namespace()
+	keys, err := t.shared.Keys(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	memories := []*Memory{}
+	for _, key := range keys {
+		if !strings.HasPrefix(key, sharedMemoryKeyPrefix) {
+			continue
+		}
+		entry, err := t.shared.Get(ctx, namespace, key)
+		if err != nil {
+			continue
+		}
+		var memory Memory
+		if err := json.Unmarshal(entry.Value(), &memory); err == nil {
+			memories = append(memories, &memory)
+		}
+	}
+	return memories, nil
+}
+
+// provenanceCopy copies a memory under a new ID, recording where it came
+// from. A memory that was itself transferred keeps its original source.
+func provenanceCopy(memory *Memory, now time.Time) *Memory {
+	copied := *memory
+	copied.ID = uuid.New().String()
+	copied.UpdatedAt = now
+	copied.AccessCount = 0
+	copied.Tags = append([]string(nil), memory.Tags...)
+	copied.Metadata = make(map[string]interface{}, len(memory.Metadata)+3)
+	for k, v := range memory.Metadata {
+		copied.Metadata[k] = v
+	}
+	if _, ok := copied.Metadata[MetaSourceMemoryID]; !ok {
+		copied.Metadata[MetaSourceAgentID] = memory.AgentID
+		copied.Metadata[MetaSourceMemoryID] = memory.ID
+	}
+	copied.Metadata[MetaTransferredAt] = now.Format(time.RFC3339)
+	return &copied
+}
+
+// dedupKeys identifies a memory for deduplication by its original ID (its
+// own ID if it was never transferred) and by its type and content
+func dedupKeys(memory *Memory) []string {
+	keys := []string{"content:" + string(memory.Type) + "\x00" + memory.Content}
+	if source, ok := memory.Metadata[MetaSourceMemoryID].(string); ok {
+		return append(keys, "source:"+source)
+	}
+	return append(keys, "source:"+memory.ID)
+}
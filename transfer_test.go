@@ -0,0 +1,51 @@
+package memoryos
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestTransferToAgentNeedsWriteOnTarget(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	s := newTestServer(t, b)
+	registerTestAgent(t, s, "a")
+	registerTestAgent(t, s, "b")
+	s.manager.RegisterAgent(ctx, &Agent{ID: "root", Name: "root", Role: RoleAdmin, Permissions: []string{PermissionWrite}})
+	s.memoryos.StoreMemory(ctx, &Memory{ID: "m1", AgentID: "a", Type: MemoryTypeSemantic, Content: "fact"})
+
+	body := `{"query": {"agent_id": "a"}, "to_agent_id": "b"}`
+	if rec := serve(t, s, http.MethodPost, "/memory/transfer", "a", body); rec.Code != http.StatusForbidden {
+		t.Fatalf("transfer into another agent: status %d %s", rec.Code, rec.Body)
+	}
+	if left, _ := s.memoryos.SearchMemories(ctx, "b", "", 0); len(left) != 0 {
+		t.Fatalf("denied transfer wrote %d memories", len(left))
+	}
+
+	if rec := serve(t, s, http.MethodPost, "/memory/transfer", "root", body); rec.Code != http.StatusOK {
+		t.Fatalf("admin transfer: status %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestTransferReportsPartialResult(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackends()
+	for _, id := range []string{"m1", "m2"} {
+		b.memory.StoreMemory(ctx, &Memory{ID: id, AgentID: "a", Type: MemoryTypeSemantic, Content: "fact " + id})
+	}
+	transfer := NewMemoryTransfer(coreMemory{failingDeletes{b.memory, "m2"}}, newTestSharedStore(b))
+
+	result, err := transfer.Transfer(ctx, TransferRequest{Query: MemoryQuery{AgentID: "a"}, ToAgentID: "b", Mode: TransferMove})
+	var partial *TransferError
+	if !errors.As(err, &partial) {
+		t.Fatalf("err = %v", err)
+	}
+	if result == nil || len(result.Transferred) != 2 || result.Removed != 1 {
+		t.Fatalf("result = %+v", result)
+	}
+	if _, body := apiError(err, http.StatusInternalServerError); body.Details != result {
+		t.Fatalf("error details = %+v", body.Details)
+	}
+}
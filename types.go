@@ -86,6 +86,7 @@ type SharedMemory struct {
 	Version    int                   `json:"version"`
 	Locked     bool                   `json:"locked"`
 	LockOwner  string                 `json:"lock_owner,omitempty"`
+	CRDT       CRDTType               `json:"crdt,omitempty"` // CRDT type of a key in CRDT mode
 }
 
 // CompressedContext represents compressed context for LLM prompts
@@ -102,14 +103,14 @@ type CompressedContext struct {
 
 // MemoryQuery represents a query for searching memories
 type MemoryQuery struct {
-	AgentID   string
-	Type      *MemoryType
-	Tags      []string
-	Keywords  []string
-	MinImportance float64
-	Since     *time.Time
-	Limit     int
-	Offset    int
+	AgentID   string        `json:"agent_id"`
+	Type      *MemoryType   `json:"type,omitempty"`
+	Tags      []string      `json:"tags,omitempty"`
+	Keywords  []string      `json:"keywords,omitempty"`
+	MinImportance float64   `json:"min_importance,omitempty"`
+	Since     *time.Time    `json:"since,omitempty"`
+	Limit     int           `json:"limit,omitempty"`
+	Offset    int           `json:"offset,omitempty"`
 }
 
 // MemoryStats represents memory usage statistics
@@ -121,6 +122,7 @@ type MemoryStats struct {
 	AvgImportance     float64           `json:"avg_importance"`
 	MostAccessed      []string          `json:"most_accessed"`
 	LastConsolidation time.Time         `json:"last_consolidation"`
+	Quota             []QuotaUsage      `json:"quota,omitempty"`
 }
 
 // ToJSON converts memory to JSON string
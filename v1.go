@@ -0,0 +1,636 @@
+package memoryos
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/google/uuid"
+)
+
+// routeHandler is a handler served by the server of the request's tenant
+type routeHandler func(*Server, http.ResponseWriter, *http.Request)
+
+// sharedParams maps the /v1 shared memory path parameters onto the query
+// parameters read by the shared memory handlers
+var sharedParams = []string{"scope", "scope", "scope_id", "scope_id", "key", "key"}
+
+// v1Routes registers the versioned resource API. Resources are addressed
+// by path; filters and options stay in the query string.
+func (s *Server) v1Routes(rt *Router) {
+	handle := func(method, pattern string, handler routeHandler) {
+		rt.Handle(method, pattern, s.tenantRoute(handler))
+	}
+
+	handle(http.MethodGet, "/v1/agents", withPathQuery((*Server).handleAgent))
+	handle(http.MethodPost, "/v1/agents", (*Server).v1RegisterAgent)
+	handle(http.MethodGet, "/v1/agents/{agent}", withPathQuery((*Server).handleAgent, "agent", "id"))
+	handle(http.MethodDelete, "/v1/agents/{agent}", (*Server).v1DeregisterAgent)
+	handle(http.MethodPost, "/v1/agents/{agent}/heartbeat", withPathQuery((*Server).handleAgentHeartbeat, "agent", "id"))
+	handle(http.MethodGet, "/v1/agents/{agent}/stats", withPathQuery((*Server).handleStats, "agent", "agent_id"))
+	handle(http.MethodGet, "/v1/agents/{agent}/context", withPathQuery((*Server).handleContext, "agent", "agent_id"))
+	handle(http.MethodGet, "/v1/agents/{agent}/teams", withPathQuery((*Server).handleTeamMembers, "agent", "agent_id"))
+
+	handle(http.MethodGet, "/v1/agents/{agent}/memories", withPathQuery((*Server).handleSearch, "agent", "agent_id"))
+	handle(http.MethodPost, "/v1/agents/{agent}/memories", (*Server).v1StoreMemory)
+	handle(http.MethodGet, "/v1/agents/{agent}/memories/{id}", (*Server).v1GetMemory)
+	handle(http.MethodPut, "/v1/agents/{agent}/memories/{id}", (*Server).v1UpdateMemory)
+	handle(http.MethodDelete, "/v1/agents/{agent}/memories/{id}", (*Server).v1DeleteMemory)
+	handle(http.MethodGet, "/v1/agents/{agent}/memories/batch", (*Server).v1GetMemories)
+	handle(http.MethodPost, "/v1/agents/{agent}/memories/batch", (*Server).v1StoreMemories)
+	handle(http.MethodDelete, "/v1/agents/{agent}/memories/batch", (*Server).v1DeleteMemories)
+	handle(http.MethodPost, "/v1/transfers", (*Server).handleTransfer)
+
+	handle(http.MethodGet, "/v1/agents/{agent}/skills", withPathQuery((*Server).handleSkill, "agent", "agent_id"))
+	handle(http.MethodPost, "/v1/agents/{agent}/skills", (*Server).v1CreateSkill)
+	handle(http.MethodGet, "/v1/agents/{agent}/skills/{name}", withPathQuery((*Server).handleSkill, "agent", "agent_id", "name", "name"))
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		handle(method, "/v1/agents/{agent}/skills/{name}/versions", withPathQuery((*Server).handleSkillVersions, "agent", "agent_id", "name", "name"))
+	}
+	handle(http.MethodPost, "/v1/agents/{agent}/skills/{name}/rollback", withPathQuery((*Server).handleSkillRollback, "agent", "agent_id", "name", "name"))
+	handle(http.MethodGet, "/v1/agents/{agent}/skills/{name}/tool", withPathQuery((*Server).handleSkillTool, "agent", "agent_id", "name", "name"))
+
+	handle(http.MethodPost, "/v1/teams", (*Server).v1CreateTeam)
+	handle(http.MethodGet, "/v1/teams/{team}", withPathQuery((*Server).handleTeam, "team", "id"))
+	handle(http.MethodDelete, "/v1/teams/{team}", (*Server).v1DeleteTeam)
+	handle(http.MethodPut, "/v1/teams/{team}/organization/{org}", withPathQuery((*Server).handleTeam, "team", "id", "org", "organization_id"))
+	handle(http.MethodGet, "/v1/teams/{team}/members", withPathQuery((*Server).handleTeamMembers, "team", "team_id"))
+	handle(http.MethodPut, "/v1/teams/{team}/members/{member}", (*Server).v1PutTeamMember)
+	handle(http.MethodDelete, "/v1/teams/{team}/members/{member}", (*Server).v1RemoveTeamMember)
+	handle(http.MethodGet, "/v1/teams/{team}/memories", withPathQuery((*Server).handleTeamSearch, "team", "team_id"))
+	handle(http.MethodGet, "/v1/teams/{team}/context", withPathQuery((*Server).handleTeamContext, "team", "team_id"))
+
+	for _, method := range []string{http.MethodGet, http.MethodPut} {
+		handle(method, "/v1/scopes/{scope}/default", withPathQuery((*Server).handleScopeDefault, "scope", "scope"))
+	}
+
+	// Global scope has no ID: /v1/scopes/global/values/{key}
+	for _, scope := range []string{"/v1/scopes/{scope}/{scope_id}", "/v1/scopes/{scope}"} {
+		value := scope + "/values/{key}"
+		handle(http.MethodPost, value, (*Server).v1CreateShared)
+		handle(http.MethodGet, value, withPathQuery((*Server).handleShared, sharedParams...))
+		handle(http.MethodPut, value, withPathQuery((*Server).handleSharedValue, sharedParams...))
+		handle(http.MethodPatch, value, withPathQuery((*Server).handleSharedValue, sharedParams...))
+		handle(http.MethodDelete, value, (*Server).v1DeleteShared)
+		for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete} {
+			handle(method, value+"/lock", withPathQuery((*Server).handleSharedLock, sharedParams...))
+		}
+		for _, method := range []string{http.MethodGet, http.MethodPut} {
+			handle(method, value+"/acl", withPathQuery((*Server).handleSharedACL, sharedParams...))
+		}
+		for _, method := range []string{http.MethodGet, http.MethodPost} {
+			handle(method, value+"/crdt", withPathQuery((*Server).handleSharedCRDT, sharedParams...))
+		}
+		handle(http.MethodGet, value+"/history", withPathQuery((*Server).handleSharedHistory, sharedParams...))
+		handle(http.MethodPost, value+"/restore", withPathQuery((*Server).handleSharedRestore, sharedParams...))
+		handle(http.MethodGet, scope+"/events", withPathQuery((*Server).handleSharedEvents, sharedParams...))
+	}
+
+	handle(http.MethodGet, "/v1/stats", (*Server).handleStats)
+}
+
+// withPathQuery serves a /v1 route with a handler that reads query
+// parameters. Path parameters are copied into the query under the names
+// the handler expects, given as param, name pairs; a client cannot
+// override them.
+func withPathQuery(handler routeHandler, pairs ...string) routeHandler {
+	return func(s *Server, w http.ResponseWriter, r *http.Request) {
+		handler(s, w, pathQuery(r, pairs...))
+	}
+}
+
+func pathQuery(r *http.Request, pairs ...string) *http.Request {
+	if len(pairs) == 0 {
+		return r
+	}
+	query := r.URL.Query()
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if value := PathParam(r, pairs[i]); value != "" {
+			query.Set(pairs[i+1], value)
+		} else {
+			query.Del(pairs[i+1])
+		}
+	}
+
+	routed := r.WithContext(r.Context())
+	u := *r.URL
+	u.RawQuery = query.Encode()
+	routed.URL = &u
+	return routed
+}
+
+// resourcePath joins escaped path segments into a Location
+func resourcePath(segments ...string) string {
+	path := ""
+	for _, segment := range segments {
+		path += "/" + url.PathEscape(segment)
+	}
+	return path
+}
+
+// writeCreated answers 201 Created with a Location header and a JSON body
+func writeCreated(w http.ResponseWriter, location string, v interface{}) {
+	w.Header().Set("Location", location)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(v)
+}
+
+// ========== V1 AGENT ENDPOINTS ==========
+
+func (s *Server) v1RegisterAgent(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var agent Agent
+	if err := json.NewDecoder(r.Body).Decode(&agent); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if err := s.admitAgent(r, &agent); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.manager.RegisterAgent(ctx, &agent); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+	if err := s.agents.Register(ctx, &agent); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeCreated(w, resourcePath("v1", "agents", agent.ID), agent)
+}
+
+// v1DeregisterAgent answers 204, or 200 with what was removed when
+// purge=true
+func (s *Server) v1DeregisterAgent(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	agentID := PathParam(r, "agent")
+	purge := r.URL.Query().Get("purge") == "true"
+
+	if !s.authorizeMemory(w, r, agentID, ActionDelete) {
+		return
+	}
+	if _, err := s.manager.GetAgent(ctx, agentID); err != nil {
+		httpError(w, fmt.Sprintf("agent not registered: %s", agentID), http.StatusNotFound)
+		return
+	}
+	deregistered, err := s.agents.IsDeregistered(ctx, agentID)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+	if deregistered {
+		httpError(w, fmt.Sprintf("agent not registered: %s", agentID), http.StatusNotFound)
+		return
+	}
+
+	result, err := s.agents.Deregister(ctx, agentID, purge)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if !purge {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// ========== V1 MEMORY ENDPOINTS ==========
+
+func (s *Server) v1StoreMemory(w http.ResponseWriter, r *http.Request) {
+	agentID := PathParam(r, "agent")
+
+	var req storeMemoryRequest
+	if err := decodeRequest(r, &req); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	if req.AgentID != "" && req.AgentID != agentID {
+		httpError(w, "agent_id does not match the path", http.StatusBadRequest)
+		return
+	}
+	req.AgentID = agentID
+
+	memory := req.Memory()
+	if err := s.validator.Validate(memory, false); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if !s.authorizeMemory(w, r, agentID, ActionWrite) {
+		return
+	}
+
+	if err := s.memoryos.StoreMemory(r.Context(), memory); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeCreated(w, resourcePath("v1", "agents", agentID, "memories", memory.ID), memory)
+}
+
+func (s *Server) v1GetMemory(w http.ResponseWriter, r *http.Request) {
+	agentID := PathParam(r, "agent")
+
+	if !s.authorizeMemory(w, r, agentID, ActionRead) {
+		return
+	}
+
+	memory, err := s.memoryos.GetMemory(r.Context(), agentID, MemoryType(r.URL.Query().Get("type")), PathParam(r, "id"))
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(memory)
+}
+
+// v1UpdateMemory replaces a memory. The agent and ID come from the path;
+// a body naming a different one is rejected.
+func (s *Server) v1UpdateMemory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	agentID := PathParam(r, "agent")
+	memoryID := PathParam(r, "id")
+
+	var memory Memory
+	if err := decodeRequest(r, &memory); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	if (memory.AgentID != "" && memory.AgentID != agentID) || (memory.ID != "" && memory.ID != memoryID) {
+		httpError(w, "agent_id and id must match the path", http.StatusBadRequest)
+		return
+	}
+	memory.AgentID, memory.ID = agentID, memoryID
+	if err := s.validator.Validate(&memory, true); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if !s.authorizeMemory(w, r, agentID, ActionWrite) {
+		return
+	}
+
+	existing, err := s.memoryos.GetMemory(ctx, agentID, memory.Type, memoryID)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+	if memory.CreatedAt.IsZero() {
+		memory.CreatedAt = existing.CreatedAt
+	}
+	if err := s.memoryos.UpdateMemory(ctx, &memory); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(memory)
+}
+
+func (s *Server) v1DeleteMemory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	agentID := PathParam(r, "agent")
+	memoryID := PathParam(r, "id")
+	memoryType := MemoryType(r.URL.Query().Get("type"))
+
+	if !s.authorizeMemory(w, r, agentID, ActionDelete) {
+		return
+	}
+
+	if _, err := s.memoryos.GetMemory(ctx, agentID, memoryType, memoryID); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+	if err := s.memoryos.DeleteMemory(ctx, agentID, memoryType, memoryID); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ========== V1 BATCH ENDPOINTS ==========
+
+// storeBatchRequest is the body of a batch store request
+type storeBatchRequest struct {
+	Memories []storeMemoryRequest `json:"memories"`
+	Atomic   bool                 `json:"atomic"`
+}
+
+// checkBatchSize rejects empty batches and batches over maxBatchSize
+func checkBatchSize(n int) error {
+	if n == 0 {
+		return errorf(ErrInvalid, "batch is empty")
+	}
+	if n > maxBatchSize {
+		return errorf(ErrTooLarge, "batch holds %d items, limit %d", n, maxBatchSize)
+	}
+	return nil
+}
+
+// writeBatchResult answers 200 when every item succeeded and 207
+// Multi-Status otherwise, with the outcome of each item in the body
+func writeBatchResult(w http.ResponseWriter, result *BatchResult) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(result.status())
+	json.NewEncoder(w).Encode(result)
+}
+
+// v1StoreMemories stores a batch of memories for the agent. Each memory is
+// validated and checked against the quotas on its own unless the batch is
+// atomic, in which case one failure stores nothing.
+func (s *Server) v1StoreMemories(w http.ResponseWriter, r *http.Request) {
+	agentID := PathParam(r, "agent")
+
+	var req storeBatchRequest
+	if err := decodeRequest(r, &req); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	if err := checkBatchSize(len(req.Memories)); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	memories := make([]*Memory, len(req.Memories))
+	for i := range req.Memories {
+		if req.Memories[i].AgentID != "" && req.Memories[i].AgentID != agentID {
+			httpError(w, fmt.Sprintf("memories[%d].agent_id does not match the path", i), http.StatusBadRequest)
+			return
+		}
+		req.Memories[i].AgentID = agentID
+		memories[i] = req.Memories[i].Memory()
+	}
+
+	if !s.authorizeMemory(w, r, agentID, ActionWrite) {
+		return
+	}
+
+	errs := storeMemories(r.Context(), s.memoryos, memories, BatchOptions{Atomic: req.Atomic})
+	result := newBatchResult(len(memories))
+	for i, memory := range memories {
+		result.add(memory.ID, http.StatusCreated, nil, errs[i])
+	}
+	writeBatchResult(w, result)
+}
+
+// v1GetMemories returns the memories named by repeated id query parameters
+func (s *Server) v1GetMemories(w http.ResponseWriter, r *http.Request) {
+	agentID := PathParam(r, "agent")
+	ids := r.URL.Query()["id"]
+
+	if err := checkBatchSize(len(ids)); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if !s.authorizeMemory(w, r, agentID, ActionRead) {
+		return
+	}
+
+	writeBatchResult(w, getMemories(r.Context(), s.memoryos, agentID, MemoryType(r.URL.Query().Get("type")), ids))
+}
+
+// v1DeleteMemories deletes the agent's memories matching the MemoryQuery in
+// the body. The query must have at least one condition.
+func (s *Server) v1DeleteMemories(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	agentID := PathParam(r, "agent")
+
+	var query MemoryQuery
+	if err := decodeRequest(r, &query); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	if query.AgentID != "" && query.AgentID != agentID {
+		httpError(w, "agent_id does not match the path", http.StatusBadRequest)
+		return
+	}
+	query.AgentID = agentID
+	if !query.hasConditions() {
+		httpError(w, "query needs at least one of type, tags, keywords, min_importance and since", http.StatusBadRequest)
+		return
+	}
+
+	if !s.authorizeMemory(w, r, agentID, ActionDelete) {
+		return
+	}
+
+	memories, err := s.transfer.Find(ctx, query)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeBatchResult(w, deleteMemories(ctx, s.memoryos, memories))
+}
+
+// ========== V1 SKILL ENDPOINTS ==========
+
+func (s *Server) v1CreateSkill(w http.ResponseWriter, r *http.Request) {
+	agentID := PathParam(r, "agent")
+
+	if !s.authorizeMemory(w, r, agentID, ActionWrite) {
+		return
+	}
+
+	var skill Skill
+	if err := json.NewDecoder(r.Body).Decode(&skill); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	if skill.Name == "" {
+		httpError(w, "name required", http.StatusBadRequest)
+		return
+	}
+
+	skill.ID = uuid.New().String()
+	if err := s.skills.Register(r.Context(), agentID, &skill); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeCreated(w, resourcePath("v1", "agents", agentID, "skills", skill.Name), skill)
+}
+
+// ========== V1 TEAM ENDPOINTS ==========
+
+func (s *Server) v1CreateTeam(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var team Team
+	if err := json.NewDecoder(r.Body).Decode(&team); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if err := s.admitTeam(r, &team); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.manager.CreateTeam(ctx, &team); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+	if err := s.roster.Create(ctx, &team, CallerID(r)); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeCreated(w, resourcePath("v1", "teams", team.ID), team)
+}
+
+func (s *Server) v1DeleteTeam(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	teamID := PathParam(r, "team")
+
+	if _, err := s.roster.Members(ctx, teamID); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+	if err := s.authz.AuthorizeTeamOwner(ctx, CallerID(r), teamID, ActionDelete); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.roster.DeleteTeam(ctx, teamID); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// v1PutTeamMember adds a member (201) or changes its role (200). The role
+// comes from an optional {"role": ...} body and defaults to writer.
+func (s *Server) v1PutTeamMember(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	teamID := PathParam(r, "team")
+	memberID := PathParam(r, "member")
+
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	role, err := ParseTeamRole(req.Role)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if _, err := s.roster.Members(ctx, teamID); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+	if err := s.authz.AuthorizeTeamOwner(ctx, CallerID(r), teamID, ActionWrite); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_, err = s.roster.Member(ctx, teamID, memberID)
+	existed := err == nil
+
+	member, err := s.roster.AddMember(ctx, teamID, memberID, role)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if !existed {
+		writeCreated(w, resourcePath("v1", "teams", teamID, "members", memberID), member)
+		return
+	}
+	json.NewEncoder(w).Encode(member)
+}
+
+func (s *Server) v1RemoveTeamMember(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	teamID := PathParam(r, "team")
+	memberID := PathParam(r, "member")
+
+	if _, err := s.roster.Member(ctx, teamID, memberID); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Members may always leave a team; removing others takes an owner
+	if memberID != CallerID(r) {
+		if err := s.authz.AuthorizeTeamOwner(ctx, CallerID(r), teamID, ActionDelete); err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := s.roster.RemoveMember(ctx, teamID, memberID); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ========== V1 SHARED MEMORY ENDPOINTS ==========
+
+// v1CreateShared creates a shared value at its own URL; an existing key
+// answers 409
+func (s *Server) v1CreateShared(w http.ResponseWriter, r *http.Request) {
+	r = pathQuery(r, sharedParams...)
+	key := r.URL.Query().Get("key")
+
+	ref, agentID, ok := s.authorizeShared(w, r, key, ActionWrite)
+	if !ok {
+		return
+	}
+
+	value, err := decodeSharedValue(r)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	entry, err := s.shared.Create(r.Context(), ref.Namespace(), key, value, agentID)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", versionETag(entry.Version))
+	writeCreated(w, r.URL.EscapedPath(), map[string]interface{}{"value": entry.Value(), "version": entry.Version})
+}
+
+func (s *Server) v1DeleteShared(w http.ResponseWriter, r *http.Request) {
+	r = pathQuery(r, sharedParams...)
+	key := r.URL.Query().Get("key")
+
+	ref, agentID, ok := s.authorizeShared(w, r, key, ActionDelete)
+	if !ok {
+		return
+	}
+	namespace := ref.Namespace()
+
+	cond, err := sharedPrecondition(r)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	if _, err := s.shared.Get(r.Context(), namespace, key); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+	if err := s.shared.Delete(r.Context(), namespace, key, agentID, cond); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,279 @@
+package memoryos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ValidationLimits bound the size and shape of stored memories. Zero
+// disables a limit.
+type ValidationLimits struct {
+	MaxContentBytes  int `json:"max_content_bytes,omitempty"`
+	MaxTags          int `json:"max_tags,omitempty"`
+	MaxTagLength     int `json:"max_tag_length,omitempty"`
+	MaxMetadataDepth int `json:"max_metadata_depth,omitempty"`
+	MaxMetadataBytes int `json:"max_metadata_bytes,omitempty"`
+	// EmbeddingDims fixes the embedding size. When zero, each agent's
+	// embeddings only have to match the size of its earlier ones.
+	EmbeddingDims int `json:"embedding_dims,omitempty"`
+}
+
+// DefaultValidationLimits are applied unless configured otherwise
+func DefaultValidationLimits() ValidationLimits {
+	return ValidationLimits{
+		MaxContentBytes:  64 << 10,
+		MaxTags:          32,
+		MaxTagLength:     64,
+		MaxMetadataDepth: 5,
+		MaxMetadataBytes: 16 << 10,
+	}
+}
+
+// knownMemoryTypes are the memory types a client may store
+var knownMemoryTypes = []MemoryType{MemoryTypeEpisodic, MemoryTypeSemantic, MemoryTypeSkill, MemoryTypeWorking, MemoryTypeShared}
+
+// FieldError describes one invalid field of a request
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every invalid field of a memory. It matches
+// ErrInvalid and its fields are reported as error details.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		parts[i] = field.Field + ": " + field.Message
+	}
+	return "invalid memory: " + strings.Join(parts, "; ")
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrInvalid
+}
+
+// ErrorDetails reports the invalid fields in error responses
+func (e *ValidationError) ErrorDetails() interface{} {
+	return map[string]interface{}{"fields": e.Fields}
+}
+
+func (e *ValidationError) add(field, format string, args ...interface{}) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// MemoryValidator wraps a MemoryBackend and rejects memories that are
+// malformed or over the validation limits before they are stored
+type MemoryValidator struct {
+	MemoryBackend
+	mu     sync.Mutex
+	limits ValidationLimits
+	dims   map[string]int
+}
+
+// NewMemoryValidator creates a validator with the given limits
+func NewMemoryValidator(backend MemoryBackend, limits ValidationLimits) *MemoryValidator {
+	return &MemoryValidator{
+		MemoryBackend: backend,
+		limits:        limits,
+		dims:          make(map[string]int),
+	}
+}
+
+// SetLimits replaces the limits
+func (v *MemoryValidator) SetLimits(limits ValidationLimits) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.limits = limits
+}
+
+// Limits returns the current limits
+func (v *MemoryValidator) Limits() ValidationLimits {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.limits
+}
+
+// Validate checks a memory to be stored. Updates also need the memory ID.
+func (v *MemoryValidator) Validate(memory *Memory, update bool) error {
+	v.mu.Lock()
+	limits := v.limits
+	dims := v.dims[memory.AgentID]
+	v.mu.Unlock()
+
+	verr := &ValidationError{}
+	if memory.AgentID == "" {
+		verr.add("agent_id", "required")
+	}
+	if update && memory.ID == "" {
+		verr.add("id", "required")
+	}
+
+	if memory.Type == "" {
+		verr.add("type", "required")
+	} else if !isKnownMemoryType(memory.Type) {
+		verr.add("type", "unknown memory type %q", memory.Type)
+	}
+
+	if strings.TrimSpace(memory.Content) == "" {
+		verr.add("content", "required")
+	} else if limits.MaxContentBytes > 0 && len(memory.Content) > limits.MaxContentBytes {
+		verr.add("content", "is %d bytes, limit %d", len(memory.Content), limits.MaxContentBytes)
+	}
+
+	if math.IsNaN(memory.Importance) || memory.Importance < 0 || memory.Importance > 1 {
+		verr.add("importance", "must be between 0.0 and 1.0")
+	}
+
+	if limits.MaxTags > 0 && len(memory.Tags) > limits.MaxTags {
+		verr.add("tags", "has %d tags, limit %d", len(memory.Tags), limits.MaxTags)
+	}
+	for i, tag := range memory.Tags {
+		field := fmt.Sprintf("tags[%d]", i)
+		if strings.TrimSpace(tag) == "" {
+			verr.add(field, "must not be empty")
+		} else if limits.MaxTagLength > 0 && len(tag) > limits.MaxTagLength {
+			verr.add(field, "is %d bytes, limit %d", len(tag), limits.MaxTagLength)
+		}
+	}
+
+	if len(memory.Metadata) > 0 {
+		if depth := metadataDepth(memory.Metadata); limits.MaxMetadataDepth > 0 && depth > limits.MaxMetadataDepth {
+			verr.add("metadata", "is nested %d levels deep, limit %d", depth, limits.MaxMetadataDepth)
+		}
+		data, err := json.Marshal(memory.Metadata)
+		if err != nil {
+			verr.add("metadata", "is not JSON: %v", err)
+		} else if limits.MaxMetadataBytes > 0 && len(data) > limits.MaxMetadataBytes {
+			verr.add("metadata", "is %d bytes, limit %d", len(data), limits.MaxMetadataBytes)
+		}
+	}
+
+	if n := len(memory.Embeddings); n > 0 {
+		switch {
+		case limits.EmbeddingDims > 0 && n != limits.EmbeddingDims:
+			verr.add("embeddings", "has %d dimensions, expected %d", n, limits.EmbeddingDims)
+		case limits.EmbeddingDims == 0 && dims > 0 && n != dims:
+			verr.add("embeddings", "has %d dimensions, agent %s uses %d", n, memory.AgentID, dims)
+		}
+		for i, x := range memory.Embeddings {
+			if math.IsNaN(x) || math.IsInf(x, 0) {
+				verr.add(fmt.Sprintf("embeddings[%d]", i), "must be a finite number")
+				break
+			}
+		}
+	}
+
+	if len(verr.Fields) > 0 {
+		return verr
+	}
+	return nil
+}
+
+func isKnownMemoryType(t MemoryType) bool {
+	for _, known := range knownMemoryTypes {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+// metadataDepth counts the nesting levels of a metadata value; a flat map
+// is one level deep
+func metadataDepth(value interface{}) int {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		deepest := 0
+		for _, child := range v {
+			if d := metadataDepth(child); d > deepest {
+				deepest = d
+			}
+		}
+		return deepest + 1
+	case []interface{}:
+		deepest := 0
+		for _, child := range v {
+			if d := metadataDepth(child); d > deepest {
+				deepest = d
+			}
+		}
+		return deepest + 1
+	default:
+		return 0
+	}
+}
+
+// remember records the embedding size an agent uses
+func (v *MemoryValidator) remember(memory *Memory) {
+	if len(memory.Embeddings) == 0 {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, ok := v.dims[memory.AgentID]; !ok {
+		v.dims[memory.AgentID] = len(memory.Embeddings)
+	}
+}
+
+// StoreMemory validates a memory before storing it
+func (v *MemoryValidator) StoreMemory(ctx context.Context, memory *Memory) error {
+	if err := v.Validate(memory, false); err != nil {
+		return err
+	}
+	if err := v.MemoryBackend.StoreMemory(ctx, memory); err != nil {
+		return err
+	}
+	v.remember(memory)
+	return nil
+}
+
+// UpdateMemory validates a memory before updating it
+func (v *MemoryValidator) UpdateMemory(ctx context.Context, memory *Memory) error {
+	if err := v.Validate(memory, true); err != nil {
+		return err
+	}
+	if err := v.MemoryBackend.UpdateMemory(ctx, memory); err != nil {
+		return err
+	}
+	v.remember(memory)
+	return nil
+}
+
+// StoreMemories validates each memory of a batch and stores the valid ones
+func (v *MemoryValidator) StoreMemories(ctx context.Context, memories []*Memory, opts BatchOptions) []error {
+	errs := make([]error, len(memories))
+	for i, memory := range memories {
+		errs[i] = v.Validate(memory, false)
+	}
+	errs = forwardBatch(ctx, v.MemoryBackend, memories, errs, opts)
+	for i, memory := range memories {
+		if errs[i] == nil {
+			v.remember(memory)
+		}
+	}
+	return errs
+}
+
+// decodeRequest decodes a JSON request body, reporting a field of the
+// wrong JSON type as a field error
+func decodeRequest(r *http.Request, v interface{}) error {
+	err := json.NewDecoder(r.Body).Decode(v)
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return &ValidationError{Fields: []FieldError{{Field: typeErr.Field, Message: "cannot be a JSON " + typeErr.Value}}}
+	}
+	if err != nil {
+		return errorf(ErrInvalid, "invalid request body: %w", err)
+	}
+	return nil
+}